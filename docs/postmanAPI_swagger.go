@@ -4,14 +4,80 @@ The documentation is then used to generate an OpenAPI spec.
 */
 package docs
 
-import ns "github.com/armosec/cluster-notifier-api-go/notificationserver"
+import (
+	"time"
 
-// Notification IDs
+	ns "github.com/armosec/cluster-notifier-api-go/notificationserver"
+)
+
+// Connection delivery
+//
+// The outcome of delivering a notification to a single connection
+type connectionDelivery struct {
+	// ID of the connection the notification was routed to
+	//
+	// Example: 5577006791947779410
+	ID int `json:"id"`
+	// Attributes the connection registered with
+	//
+	// Example: {"customerGUID": "b5b28ef9-d297-4a93-aec4-22de5b21e802", "clusterName": "minikube"}
+	Attributes map[string]string `json:"attributes"`
+	// Outcome of the delivery
+	//
+	// Enum: sent,failed,async,acked,unacked,replied,noReply,duplicate
+	// Example: sent
+	Status string `json:"status"`
+	// Reason the delivery failed
+	Error string `json:"error,omitempty"`
+	// Reply of the subscriber to a request
+	//
+	// Example: {"scanned": true}
+	Reply interface{} `json:"reply,omitempty"`
+}
+
+// Batch item result
 //
-// The IDs of the sent notifications
+// The outcome of a single notification of a batch
+type batchItemResult struct {
+	// Position of the notification in the batch
+	//
+	// Example: 0
+	Index int `json:"index"`
+	// HTTP status the notification would have been answered with on its own
+	//
+	// Example: 200
+	Status int `json:"status"`
+	// Outcome of routing the notification, if it was routed
+	Result *sendResult `json:"result,omitempty"`
+	// Why the notification failed
+	Error *errorResponse `json:"error,omitempty"`
+}
+
+// Send result
 //
-// Example: [1, 2, 3]
-type notificationIDs []int
+// The outcome of routing a notification
+type sendResult struct {
+	// ID the gateway generated for the notification
+	//
+	// Example: 0b4d7e4e-6c53-4a0a-9b8a-6d4d3a8f2c11
+	NotificationID string `json:"notificationID"`
+	// Correlation ID of a request
+	//
+	// Example: scan-1
+	CorrelationID string `json:"correlationID,omitempty"`
+	// Connections the notification was routed to. Empty if nobody subscribed to the target
+	Connections []connectionDelivery `json:"connections"`
+	// Set when nobody subscribed to the target and the notification was buffered until a subscriber connects
+	Queued bool `json:"queued,omitempty"`
+	// Set when nobody subscribed to the target locally and the notification was forwarded to the parent gateway, in bidirectional routing mode
+	Forwarded bool `json:"forwarded,omitempty"`
+	// Number of connections that already received the notification within the dedup window, and were skipped
+	Duplicates int `json:"duplicates,omitempty"`
+	// Set when the notification was scheduled, it is routed then
+	//
+	// Example: 2024-01-01T02:00:00Z
+	DeliverAt *time.Time `json:"deliverAt,omitempty"`
+}
 
 /*
 A request to send a notification has been successfully received.
@@ -20,17 +86,209 @@ swagger:response postSendNotificationOk
 */
 type postSendNotificationOk struct {
 	// In: body
-	Body notificationIDs
+	Body sendResult
 }
 
 /*
-A request to send a notification is malformed
+A request to send a notification is malformed, or the notification breaks the configured attribute keys and value formats.
 
 swagger:response postSendNotificationBadRequest
 */
 type postSendNotificationBadRequest struct {
 	// In: body
-	Body string
+	Body errorResponse
+}
+
+/*
+Nobody subscribed to the target, in strict mode.
+
+swagger:response postSendNotificationNoSubscribers
+*/
+type postSendNotificationNoSubscribers struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+The method is not supported.
+
+swagger:response methodNotAllowed
+*/
+type methodNotAllowed struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+A request with the same correlation ID is waiting for its replies, or a notification with the same message ID is scheduled.
+
+swagger:response postSendNotificationConflict
+*/
+type postSendNotificationConflict struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+The request body exceeds the maximal body size.
+
+swagger:response postSendNotificationTooLarge
+*/
+type postSendNotificationTooLarge struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+The sender, or a target attribute value, exceeded its rate limit.
+
+swagger:response postSendNotificationTooManyRequests
+*/
+type postSendNotificationTooManyRequests struct {
+	// Seconds to wait before sending again
+	RetryAfter int `json:"Retry-After"`
+	// In: body
+	Body errorResponse
+}
+
+/*
+The notification could not be sent, e.g. buffering it failed.
+
+swagger:response postSendNotificationFailed
+*/
+type postSendNotificationFailed struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+The notification could not be written to some of the connections, they are listed as failures.
+
+swagger:response postSendNotificationBadGateway
+*/
+type postSendNotificationBadGateway struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+The gateway is shutting down, or the scheduler is full.
+
+swagger:response postSendNotificationUnavailable
+*/
+type postSendNotificationUnavailable struct {
+	// In: body
+	Body errorResponse
+}
+
+// Gateway notification
+//
+// A notification with the gateway delivery options
+type notification struct {
+	ns.Notification
+	// ID of the notification. Stamped by the gateway on notifications that require an acknowledgement, or on every notification when deduplication is enabled. Repeats of an ID are dropped within the dedup window
+	//
+	// Example: 0b4d7e4e-6c53-4a0a-9b8a-6d4d3a8f2c11
+	MessageID string `json:"messageID,omitempty"`
+	// Whether every subscriber has to acknowledge the notification by sending back `{"ack": "<messageID>"}`
+	//
+	// Unacknowledged notifications are sent again and eventually dead-lettered.
+	// Synchronous senders wait for the acknowledgements.
+	//
+	// Example: true
+	RequireAck bool `json:"requireAck,omitempty"`
+	// How long a synchronous sender waits for the acknowledgements, in milliseconds. The gateway default if not set
+	//
+	// Example: 5000
+	AckTimeoutMs int `json:"ackTimeoutMs,omitempty"`
+	// Makes the notification a request. Every subscriber replies by sending back `{"replyTo": "<correlationID>", "reply": <reply>}`
+	//
+	// The response lists the replies, the request is sent synchronously.
+	//
+	// Example: scan-1
+	CorrelationID string `json:"correlationID,omitempty"`
+	// How long to wait for the replies to a request, in milliseconds. The gateway default if not set
+	//
+	// Example: 5000
+	ReplyTimeoutMs int `json:"replyTimeoutMs,omitempty"`
+	// Number of gateways that forwarded the notification to their parent. Stamped by the gateways, in bidirectional routing mode
+	//
+	// Example: 1
+	Hops int `json:"hops,omitempty"`
+	// Selects the subscribers by attribute expressions on top of the target, which is then optional
+	Match *match `json:"match,omitempty"`
+	// Schedules the notification, the gateway it is sent to holds it until then. Must be within the maximal delay of the gateway
+	//
+	// Example: 2024-01-01T02:00:00Z
+	DeliverAt *time.Time `json:"deliverAt,omitempty"`
+	// Schedules the notification a number of milliseconds after it is received. Cannot be set with deliverAt
+	//
+	// Example: 60000
+	DelayMs int `json:"delayMs,omitempty"`
+}
+
+// Error response
+//
+// The body of a failed request
+type errorResponse struct {
+	// Stable identifier of the error
+	//
+	// Enum: invalid_request,invalid_notification,body_too_large,rate_limited,no_subscribers,request_pending,delivery_failed,send_failed,scheduler_full,already_scheduled,shutting_down,method_not_allowed,not_found,unauthorized,forbidden,streaming_unsupported
+	// Example: invalid_notification
+	Code string `json:"code"`
+	// Example: invalid notification: target.cluster: attribute is not allowed
+	Message string `json:"message"`
+	// ID of the request, sent back in the X-Request-ID header as well
+	//
+	// Example: 5f0c4a4e-5cbb-4d3e-9a47-3b1f0e5b8a1d
+	RequestID string `json:"requestID"`
+	// ID of the notification, if it was routed
+	NotificationID string `json:"notificationID,omitempty"`
+	// Problems found in an invalid notification
+	Violations []violation `json:"violations,omitempty"`
+	// Connections the notification could not be delivered to
+	Failures []connectionDelivery `json:"failures,omitempty"`
+}
+
+// Violation
+//
+// A problem of a notification
+type violation struct {
+	// Path of the offending field, empty if the notification could not be parsed
+	//
+	// Example: target.customerGUID
+	Field string `json:"field,omitempty"`
+	// Example: attribute is not allowed
+	Message string `json:"message"`
+}
+
+// Match
+//
+// Attribute expressions selecting the subscribers. All of them must hold
+type match struct {
+	Expressions []expression `json:"expressions"`
+	// Whether a subscriber must have every key of the target and of the expressions.
+	// By default, like the target, the keys a subscriber does not have are ignored as long as it has one of them
+	RequireAll bool `json:"requireAll,omitempty"`
+}
+
+// Expression
+//
+// Selects the subscribers by an attribute
+type expression struct {
+	// Attribute key
+	//
+	// Example: cluster
+	Key string `json:"key"`
+	// How the attribute is compared with the values. `=` takes a single value, `!=` and `notIn` exclude all the values
+	//
+	// Enum: =,!=,in,notIn
+	// Example: =
+	Operator string `json:"operator"`
+	// Shell patterns of the attribute value
+	//
+	// Example: ["prod-*"]
+	Values []string `json:"values"`
 }
 
 /*
@@ -38,9 +296,47 @@ swagger:parameters postSendNotification
 */
 type postSendNotificationParams struct {
 	// In: body
-	Body ns.Notification
+	Body notification
+}
+
+/*
+The notifications of the batch were read, the result of each is streamed back in the format of the request, a JSON array or newline delimited JSON.
+
+swagger:response postSendNotificationBatchOk
+*/
+type postSendNotificationBatchOk struct {
+	// In: body
+	Body []batchItemResult
+}
+
+/*
+swagger:parameters postSendNotificationBatch
+*/
+type postSendNotificationBatchParams struct {
+	// A JSON array of notifications, or a stream of newline delimited notifications
+	//
+	// In: body
+	Body []notification
 }
 
+/*
+swagger:route POST /v1/sendnotification/batch postSendNotificationBatch
+Send many notifications to the listeners, one at a time, in order
+
+Consumes:
+- application/json
+- application/x-ndjson
+
+Produces:
+- application/json
+- application/x-ndjson
+
+Responses:
+  200: postSendNotificationBatchOk
+  405: methodNotAllowed
+  503: postSendNotificationUnavailable
+*/
+
 /*
 swagger:route POST /v1/sendnotification postSendNotification
 Send a notification to the listeners
@@ -48,4 +344,365 @@ Send a notification to the listeners
 Responses:
   200: postSendNotificationOk
   400: postSendNotificationBadRequest
+  404: postSendNotificationNoSubscribers
+  405: methodNotAllowed
+  409: postSendNotificationConflict
+  413: postSendNotificationTooLarge
+  429: postSendNotificationTooManyRequests
+  500: postSendNotificationFailed
+  502: postSendNotificationBadGateway
+  503: postSendNotificationUnavailable
+*/
+
+// Upstream link status
+//
+// The state of a link to the parent gateway
+type upstreamLinkStatus struct {
+	// Attributes the link registered with in the parent gateway
+	Attributes map[string]string `json:"attributes"`
+	// URL of the parent gateway the link is connected to, or last tried to connect to
+	Parent string `json:"parent,omitempty"`
+	// State of the link
+	//
+	// Enum: connecting,connected,disconnected
+	State string `json:"state"`
+	// Time of the last state transition
+	Since string `json:"since"`
+	// Number of consecutive failed connection attempts
+	Failures int `json:"failures"`
+	// Reason of the last failure
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Parent status
+//
+// The links to one of the parent gateways
+type parentStatus struct {
+	// URL of the parent gateway
+	//
+	// Example: wss://ens.euprod1.cyberarmorsoft.com/v1/waitfornotification
+	URL string `json:"url"`
+	// Number of links connected or trying to connect to the parent
+	Links int `json:"links"`
+	// Number of links connected to the parent
+	Connected int `json:"connected"`
+}
+
+// Health
+//
+// The state of the links to the parent gateway
+type health struct {
+	Upstream []upstreamLinkStatus `json:"upstream"`
+	Parents  []parentStatus       `json:"parents"`
+}
+
+/*
+Every set of attributes has a link connected to a parent gateway.
+
+swagger:response getHealthOk
+*/
+type getHealthOk struct {
+	// In: body
+	Body health
+}
+
+/*
+At least one set of attributes has no link connected to a parent gateway.
+
+swagger:response getHealthUnavailable
+*/
+type getHealthUnavailable struct {
+	// In: body
+	Body health
+}
+
+/*
+swagger:route GET /v1/health getHealth
+Report the state of the links to the parent gateway
+
+Responses:
+  200: getHealthOk
+  503: getHealthUnavailable
+*/
+
+// Connection info
+//
+// A connection of the routing table
+type connectionInfo struct {
+	// ID of the connection
+	//
+	// Example: 5577006791947779410
+	ID int `json:"id"`
+	// Whether the connection is of a local subscriber or to the parent gateway
+	//
+	// Enum: incoming,outgoing
+	Direction string `json:"direction"`
+	// Attributes the connection registered with
+	//
+	// Example: {"customerGUID": "b5b28ef9-d297-4a93-aec4-22de5b21e802", "clusterName": "minikube"}
+	Attributes map[string]string `json:"attributes"`
+	// Attribute sets a multiplexed link of an edge gateway subscribed to. The connection is routed by them instead of its attributes
+	//
+	// Example: [{"customerGUID": "b5b28ef9-d297-4a93-aec4-22de5b21e802", "clusterName": "minikube"}]
+	Subscriptions []map[string]string `json:"subscriptions,omitempty"`
+	// URL of the parent gateway of an outgoing connection
+	Parent string `json:"parent,omitempty"`
+	// Whether the connection is a Server-Sent Events stream rather than a websocket
+	Stream bool `json:"stream"`
+	// Address of the peer
+	//
+	// Example: 10.0.0.12:51234
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Time the connection was registered
+	ConnectedAt string `json:"connectedAt"`
+	// Number of notifications written to the connection
+	MessagesSent int `json:"messagesSent"`
+	// Number of bytes of the notifications written to the connection
+	BytesSent int `json:"bytesSent"`
+}
+
+// Connection list
+//
+// The connections matching a filter
+type connectionList struct {
+	Connections []connectionInfo `json:"connections"`
+}
+
+// Disconnect result
+//
+// The IDs of the disconnected connections
+type disconnectResult struct {
+	Disconnected []int `json:"disconnected"`
+}
+
+/*
+swagger:parameters listConnections disconnectConnections
+*/
+type connectionsParams struct {
+	// Whether to select the connections of local subscribers or to the parent gateway
+	//
+	// In: path
+	// Required: true
+	// Enum: incoming,outgoing
+	Direction string `json:"direction"`
+}
+
+/*
+swagger:parameters getConnection disconnectConnection
+*/
+type connectionParams struct {
+	// Whether the connection is of a local subscriber or to the parent gateway
+	//
+	// In: path
+	// Required: true
+	// Enum: incoming,outgoing
+	Direction string `json:"direction"`
+	// ID of the connection
+	//
+	// In: path
+	// Required: true
+	ID int `json:"id"`
+}
+
+/*
+The connections matching the query attributes.
+
+swagger:response listConnectionsOk
+*/
+type listConnectionsOk struct {
+	// In: body
+	Body connectionList
+}
+
+/*
+The connection.
+
+swagger:response getConnectionOk
+*/
+type getConnectionOk struct {
+	// In: body
+	Body connectionInfo
+}
+
+/*
+The connections were disconnected.
+
+swagger:response disconnectOk
+*/
+type disconnectOk struct {
+	// In: body
+	Body disconnectResult
+}
+
+// Scheduled notification
+//
+// A notification held until it is due
+type scheduledNotification struct {
+	// Message ID of the notification
+	//
+	// Example: 0b4d7e4e-6c53-4a0a-9b8a-6d4d3a8f2c11
+	ID string `json:"id"`
+	// Example: {"customerGUID": "b5b28ef9-d297-4a93-aec4-22de5b21e802"}
+	Target map[string]string `json:"target,omitempty"`
+	// When the notification is routed
+	//
+	// Example: 2024-01-01T02:00:00Z
+	DeliverAt time.Time `json:"deliverAt"`
+	// When the notification was received
+	//
+	// Example: 2024-01-01T01:00:00Z
+	Scheduled time.Time `json:"scheduled"`
+	// The notification as it will be routed, base64 encoded
+	Notification []byte `json:"notification"`
+}
+
+// Scheduled list
+//
+// The pending scheduled notifications, the next due first
+type scheduledList struct {
+	Scheduled []scheduledNotification `json:"scheduled"`
+}
+
+/*
+The scheduled notifications whose target has the query attributes.
+
+swagger:response listScheduledOk
+*/
+type listScheduledOk struct {
+	// In: body
+	Body scheduledList
+}
+
+/*
+The scheduled notification.
+
+swagger:response scheduledNotificationOk
+*/
+type scheduledNotificationOk struct {
+	// In: body
+	Body scheduledNotification
+}
+
+/*
+swagger:parameters getScheduled cancelScheduled
+*/
+type scheduledParams struct {
+	// Message ID of the scheduled notification
+	//
+	// In: path
+	// Required: true
+	ID string `json:"id"`
+}
+
+/*
+swagger:route GET /v1/admin/scheduled admin listScheduled
+List the scheduled notifications whose target has the attributes of the query, e.g. `?customerGUID=<guid>`. All of them are listed if there are no attributes
+
+Security:
+  adminToken:
+
+Responses:
+  200: listScheduledOk
+  401: adminUnauthorized
+  404: adminNotFound
+*/
+
+/*
+swagger:route GET /v1/admin/scheduled/{id} admin getScheduled
+Inspect a scheduled notification
+
+Security:
+  adminToken:
+
+Responses:
+  200: scheduledNotificationOk
+  401: adminUnauthorized
+  404: adminNotFound
+*/
+
+/*
+swagger:route DELETE /v1/admin/scheduled/{id} admin cancelScheduled
+Cancel a scheduled notification before it is due
+
+Security:
+  adminToken:
+
+Responses:
+  200: scheduledNotificationOk
+  401: adminUnauthorized
+  404: adminNotFound
+*/
+
+/*
+The admin token is missing or invalid.
+
+swagger:response adminUnauthorized
+*/
+type adminUnauthorized struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+There is no such connection or scheduled notification, or the admin API or scheduling is disabled.
+
+swagger:response adminNotFound
+*/
+type adminNotFound struct {
+	// In: body
+	Body errorResponse
+}
+
+/*
+swagger:route GET /v1/admin/connections/{direction} admin listConnections
+List the connections matching the attributes of the query, e.g. `?customerGUID=<guid>`. All the connections are listed if there are no attributes.
+Omitting the direction lists the connections of both directions
+
+Security:
+  adminToken:
+
+Responses:
+  200: listConnectionsOk
+  401: adminUnauthorized
+  404: adminNotFound
+*/
+
+/*
+swagger:route GET /v1/admin/connections/{direction}/{id} admin getConnection
+Inspect a connection
+
+Security:
+  adminToken:
+
+Responses:
+  200: getConnectionOk
+  401: adminUnauthorized
+  404: adminNotFound
+*/
+
+/*
+swagger:route DELETE /v1/admin/connections/{direction}/{id} admin disconnectConnection
+Disconnect a connection. The links to the parent gateway reconnect
+
+Security:
+  adminToken:
+
+Responses:
+  200: disconnectOk
+  401: adminUnauthorized
+  404: adminNotFound
+*/
+
+/*
+swagger:route DELETE /v1/admin/connections/{direction} admin disconnectConnections
+Disconnect the connections matching the attributes of the query, e.g. `?customerGUID=<guid>`. At least one attribute is required
+
+Security:
+  adminToken:
+
+Responses:
+  200: disconnectOk
+  400: postSendNotificationBadRequest
+  401: adminUnauthorized
+  404: adminNotFound
 */
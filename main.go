@@ -20,7 +20,11 @@ func main() {
 	isReadinessReady := false
 	go probes.InitReadinessV1(&isReadinessReady)
 
-	gateway := gateway.NewGateway()
+	cfg, err := gateway.LoadConfig(os.Getenv(gateway.ConfigEnvironmentVariable))
+	if err != nil {
+		logger.L().Fatal("failed to load config", helpers.Error(err))
+	}
+	gateway := gateway.NewGateway(cfg)
 	isReadinessReady = true
 	gateway.SetupAndServe()
 }
@@ -0,0 +1,330 @@
+package gateway
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultAckTimeout       = 10 * time.Second
+	defaultAckRetryInterval = 5 * time.Second
+	defaultAckMaxRetries    = 3
+)
+
+// AckFrame is the frame a subscriber sends back after processing a notification that requires an acknowledgement
+type AckFrame struct {
+	Ack string `json:"ack" bson:"ack"`
+}
+
+// parseAckFrame returns the acknowledged message ID if a given message is an AckFrame
+func parseAckFrame(message []byte) (string, bool) {
+	frame := AckFrame{}
+	if err := json.Unmarshal(message, &frame); err == nil && frame.Ack != "" {
+		return frame.Ack, true
+	}
+	frame = AckFrame{}
+	if err := bson.Unmarshal(message, &frame); err == nil && frame.Ack != "" {
+		return frame.Ack, true
+	}
+	return "", false
+}
+
+// stampMessage sets the given top level fields of a JSON or BSON encoded message, keeping its encoding. A nil value removes the field
+func stampMessage(message []byte, fields map[string]interface{}) ([]byte, error) {
+	if json.Valid(message) {
+		m := map[string]json.RawMessage{}
+		if err := json.Unmarshal(message, &m); err != nil {
+			return message, err
+		}
+		for k, v := range fields {
+			if v == nil {
+				delete(m, k)
+				continue
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return message, err
+			}
+			m[k] = b
+		}
+		return json.Marshal(m)
+	}
+	m := bson.M{}
+	if err := bson.Unmarshal(message, &m); err != nil {
+		return message, err
+	}
+	for k, v := range fields {
+		if v == nil {
+			delete(m, k)
+			continue
+		}
+		m[k] = v
+	}
+	return bson.Marshal(m)
+}
+
+// DeadLetter is a notification that was not acknowledged by a subscriber after all the retries
+type DeadLetter struct {
+	MessageID    string            `json:"messageID"`
+	ConnectionID int               `json:"connectionID"`
+	Attributes   map[string]string `json:"attributes"`
+	Notification []byte            `json:"notification"`
+	Retries      int               `json:"retries"`
+	Time         time.Time         `json:"time"`
+}
+
+// DeadLetterSink receives the notifications that were never acknowledged
+type DeadLetterSink interface {
+	Send(deadLetter DeadLetter)
+}
+
+// LogDeadLetterSink logs the dead letters
+type LogDeadLetterSink struct{}
+
+// Send logs a given dead letter
+func (LogDeadLetterSink) Send(deadLetter DeadLetter) {
+	logger.L().Error("notification was not acknowledged", helpers.String("messageID", deadLetter.MessageID), helpers.Int("id", deadLetter.ConnectionID), helpers.String("attributes", strutils.ObjectToString(deadLetter.Attributes)), helpers.Int("retries", deadLetter.Retries))
+}
+
+// FileDeadLetterSink appends the dead letters to a file, one JSON object per line
+type FileDeadLetterSink struct {
+	path  string
+	mutex *sync.Mutex
+}
+
+// NewFileDeadLetterSink creates a new FileDeadLetterSink writing to a given path
+func NewFileDeadLetterSink(path string) *FileDeadLetterSink {
+	return &FileDeadLetterSink{path: path, mutex: &sync.Mutex{}}
+}
+
+// Send appends a given dead letter to the file
+func (fs *FileDeadLetterSink) Send(deadLetter DeadLetter) {
+	LogDeadLetterSink{}.Send(deadLetter)
+
+	b, _ := json.Marshal(deadLetter)
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	f, err := os.OpenFile(fs.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		logger.L().Error("failed to open dead letter file", helpers.String("path", fs.path), helpers.Error(err))
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		logger.L().Error("failed to write dead letter", helpers.String("path", fs.path), helpers.Error(err))
+	}
+}
+
+// pendingAck is a notification written to a connection and not yet acknowledged
+type pendingAck struct {
+	messageID    string
+	conn         *websocketactions.Connection
+	notification []byte
+	message      *websocketactions.PreparedMessage
+	retries      int
+	timer        *time.Timer
+	// acked is set once the connection acknowledged the notification, before settled is closed
+	acked bool
+	// settled is closed once the connection acknowledged the notification, or went away
+	settled chan struct{}
+}
+
+// AckTracker keeps track of the notifications that wait for an acknowledgement.
+// Notifications that are not acknowledged within the retry interval are written again,
+// and after the maximal number of retries they are sent to the dead letter sink
+type AckTracker struct {
+	wa            websocketactions.IWebsocketActions
+	pending       map[string]map[int]*pendingAck
+	mutex         *sync.Mutex
+	timeout       time.Duration
+	retryInterval time.Duration
+	maxRetries    int
+	deadLetters   DeadLetterSink
+}
+
+// NewAckTracker creates a new AckTracker
+func NewAckTracker(wa websocketactions.IWebsocketActions, timeout, retryInterval time.Duration, maxRetries int, deadLetters DeadLetterSink) *AckTracker {
+	return &AckTracker{
+		wa:            wa,
+		pending:       map[string]map[int]*pendingAck{},
+		mutex:         &sync.Mutex{},
+		timeout:       timeout,
+		retryInterval: retryInterval,
+		maxRetries:    maxRetries,
+		deadLetters:   deadLetters,
+	}
+}
+
+// newAckTracker creates the configured AckTracker
+func newAckTracker(wa websocketactions.IWebsocketActions, cfg AcksConfig) *AckTracker {
+	var deadLetters DeadLetterSink = LogDeadLetterSink{}
+	if cfg.DeadLetterFile != "" {
+		deadLetters = NewFileDeadLetterSink(cfg.DeadLetterFile)
+	}
+	return NewAckTracker(wa, time.Duration(cfg.Timeout), time.Duration(cfg.RetryInterval), cfg.MaxRetries, deadLetters)
+}
+
+// Track starts waiting for a connection to acknowledge a notification. Call it before writing the notification
+func (at *AckTracker) Track(messageID string, conn *websocketactions.Connection, notification []byte, message *websocketactions.PreparedMessage) *pendingAck {
+	p := &pendingAck{
+		messageID:    messageID,
+		conn:         conn,
+		notification: notification,
+		message:      message,
+		settled:      make(chan struct{}),
+	}
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+	if _, ok := at.pending[messageID]; !ok {
+		at.pending[messageID] = map[int]*pendingAck{}
+	}
+	at.pending[messageID][conn.ID] = p
+	p.timer = time.AfterFunc(at.retryInterval, func() { at.retry(p) })
+	return p
+}
+
+// Ack marks a notification as acknowledged by a connection. Returns false if the notification was not pending
+func (at *AckTracker) Ack(messageID string, connID int) bool {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+	p, ok := at.pending[messageID][connID]
+	if !ok {
+		return false
+	}
+	at.remove(p)
+	p.acked = true
+	close(p.settled)
+	return true
+}
+
+// DropConnection stops tracking the notifications written to a connection that went away.
+// They are neither retried nor sent to the dead letter sink, and the senders waiting for them stop waiting
+func (at *AckTracker) DropConnection(connID int) {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+	for _, connections := range at.pending {
+		if p, ok := connections[connID]; ok {
+			at.remove(p)
+			close(p.settled)
+		}
+	}
+}
+
+// Wait waits for the given notifications to be acknowledged, up to a given timeout (the default timeout if 0).
+// Returns the IDs of the connections that acknowledged, a connection that went away did not
+func (at *AckTracker) Wait(pending []*pendingAck, timeout time.Duration) map[int]bool {
+	if timeout <= 0 {
+		timeout = at.timeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	acked := map[int]bool{}
+	timedOut := false
+	for _, p := range pending {
+		if timedOut {
+			// only collect the notifications that were already acknowledged
+			select {
+			case <-p.settled:
+				if p.acked {
+					acked[p.conn.ID] = true
+				}
+			default:
+			}
+			continue
+		}
+		select {
+		case <-p.settled:
+			if p.acked {
+				acked[p.conn.ID] = true
+			}
+		case <-deadline.C:
+			timedOut = true
+		}
+	}
+	return acked
+}
+
+// remove stops tracking a notification. The caller must hold the lock
+func (at *AckTracker) remove(p *pendingAck) {
+	p.timer.Stop()
+	delete(at.pending[p.messageID], p.conn.ID)
+	if len(at.pending[p.messageID]) == 0 {
+		delete(at.pending, p.messageID)
+	}
+}
+
+// retry writes an unacknowledged notification again, or sends it to the dead letter sink once the retries are exhausted
+func (at *AckTracker) retry(p *pendingAck) {
+	at.mutex.Lock()
+	if _, ok := at.pending[p.messageID][p.conn.ID]; !ok {
+		// acknowledged meanwhile
+		at.mutex.Unlock()
+		return
+	}
+	if p.retries >= at.maxRetries {
+		at.remove(p)
+		at.mutex.Unlock()
+		at.deadLetters.Send(DeadLetter{
+			MessageID:    p.messageID,
+			ConnectionID: p.conn.ID,
+			Attributes:   p.conn.GetAttributes(),
+			Notification: p.notification,
+			Retries:      p.retries,
+			Time:         time.Now(),
+		})
+		return
+	}
+	p.retries++
+	retries := p.retries
+	at.mutex.Unlock()
+
+	logger.L().Warning("notification was not acknowledged, sending again", helpers.String("messageID", p.messageID), helpers.Int("id", p.conn.ID), helpers.Int("retry", retries))
+	// written by the writer of the connection, so the retry is bound by the write timeout and the overflow policy like any notification
+	p.conn.Enqueue(&websocketactions.QueuedWrite{
+		Write: func() error { return at.wa.WritePreparedMessage(p.conn, p.message) },
+		Done: func(err error) {
+			if err != nil {
+				logger.L().Warning("failed to send unacknowledged notification", helpers.String("messageID", p.messageID), helpers.Int("id", p.conn.ID), helpers.Error(err))
+			}
+			// the next retry is scheduled once this one was written, so the retries of a notification never overlap
+			at.mutex.Lock()
+			if _, ok := at.pending[p.messageID][p.conn.ID]; ok {
+				p.timer.Reset(at.retryInterval)
+			}
+			at.mutex.Unlock()
+		},
+	})
+}
+
+// ackUpstream acknowledges a notification to the connection that sent it, once all the subscribers it was routed to acknowledged it
+func (nh *Gateway) ackUpstream(connObj *websocketactions.Connection, result *SendResult) {
+	if len(result.Connections) == 0 {
+		return
+	}
+	for i := range result.Connections {
+		// a subscriber that already received the notification is tracked by its first delivery
+		if result.Connections[i].Status != DeliveryStatusAcked && result.Connections[i].Status != DeliveryStatusDuplicate {
+			logger.L().Warning("not acknowledging notification, some subscribers did not acknowledge it", helpers.String("messageID", result.NotificationID), helpers.Int("id", connObj.ID))
+			return
+		}
+	}
+	b, _ := json.Marshal(AckFrame{Ack: result.NotificationID})
+	if err := nh.wa.WriteBinaryMessage(connObj, b); err != nil {
+		logger.L().Error("failed to acknowledge notification", helpers.String("messageID", result.NotificationID), helpers.Int("id", connObj.ID), helpers.Error(err))
+	}
+}
+
+// ackTimeout returns the time a synchronous sender waits for the acknowledgements of a notification
+func (n *Notification) ackTimeout() time.Duration {
+	return time.Duration(n.AckTimeoutMs) * time.Millisecond
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type deadLetterSinkMock struct {
+	mutex       sync.Mutex
+	deadLetters []DeadLetter
+}
+
+func (ds *deadLetterSinkMock) Send(deadLetter DeadLetter) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	ds.deadLetters = append(ds.deadLetters, deadLetter)
+}
+
+func (ds *deadLetterSinkMock) len() int {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	return len(ds.deadLetters)
+}
+
+func TestParseAckFrame(t *testing.T) {
+	id, ok := parseAckFrame([]byte(`{"ack":"abc"}`))
+	assert.True(t, ok)
+	assert.Equal(t, "abc", id)
+
+	b, _ := bson.Marshal(AckFrame{Ack: "def"})
+	id, ok = parseAckFrame(b)
+	assert.True(t, ok)
+	assert.Equal(t, "def", id)
+
+	_, ok = parseAckFrame([]byte(`{"target":{"customer":"test"}}`))
+	assert.False(t, ok)
+}
+
+func TestStampMessage(t *testing.T) {
+	stamped, err := stampMessage([]byte(`{"target":{"customer":"test"},"notification":[1,2]}`), map[string]interface{}{"messageID": "abc"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"target":{"customer":"test"},"notification":[1,2],"messageID":"abc"}`, string(stamped))
+
+	b, _ := bson.Marshal(NotificationMock(ATTRIBUTES_MOCK, false))
+	stamped, err = stampMessage(b, map[string]interface{}{"messageID": "abc"})
+	assert.NoError(t, err)
+	n := &Notification{}
+	assert.NoError(t, bson.Unmarshal(stamped, n))
+	assert.Equal(t, "abc", n.MessageID)
+	assert.Equal(t, ATTRIBUTES_MOCK, n.Target)
+}
+
+func TestUnmarshalMessageAckOptions(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	n, err := ns.UnmarshalMessage([]byte(`{"target":{"customer":"test"},"requireAck":true,"ackTimeoutMs":100,"messageID":"abc"}`))
+	assert.NoError(t, err)
+	assert.True(t, n.RequireAck)
+	assert.Equal(t, 100*time.Millisecond, n.ackTimeout())
+	assert.Equal(t, "abc", n.MessageID)
+	assert.Equal(t, "test", n.Target["customer"])
+}
+
+func TestAckTrackerDeadLetter(t *testing.T) {
+	sink := &deadLetterSinkMock{}
+	at := NewAckTracker(&websocketactions.WebsocketActionsMock{}, time.Second, time.Millisecond, 2, sink)
+	conn := ConnectionMock()
+	pm, _ := websocketactions.NewPreparedMessage([]byte("{}"))
+
+	p := at.Track("acked", conn, []byte("{}"), pm)
+	assert.True(t, at.Ack("acked", conn.ID))
+	assert.False(t, at.Ack("acked", conn.ID), "a notification is acknowledged once")
+	assert.Equal(t, map[int]bool{conn.ID: true}, at.Wait([]*pendingAck{p}, time.Second))
+
+	p = at.Track("unacked", conn, []byte("{}"), pm)
+	assert.Equal(t, map[int]bool{}, at.Wait([]*pendingAck{p}, 5*time.Millisecond))
+	assert.Eventually(t, func() bool { return sink.len() == 1 }, time.Second, time.Millisecond)
+	sink.mutex.Lock()
+	assert.Equal(t, "unacked", sink.deadLetters[0].MessageID)
+	assert.Equal(t, 2, sink.deadLetters[0].Retries)
+	sink.mutex.Unlock()
+	assert.Equal(t, 0, pendingAcksMock(at))
+}
+
+// pendingAcksMock returns the number of notifications an AckTracker waits for
+func pendingAcksMock(at *AckTracker) int {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+	return len(at.pending)
+}
+
+func TestAckTrackerDropConnection(t *testing.T) {
+	sink := &deadLetterSinkMock{}
+	at := NewAckTracker(&websocketactions.WebsocketActionsMock{}, time.Second, 5*time.Millisecond, 2, sink)
+	conn := ConnectionMock()
+	other := websocketactions.NewConnection(nil, conn.ID+1, ATTRIBUTES_MOCK)
+	pm, _ := websocketactions.NewPreparedMessage([]byte("{}"))
+
+	dropped := at.Track("abc", conn, []byte("{}"), pm)
+	kept := at.Track("abc", other, []byte("{}"), pm)
+	go at.DropConnection(conn.ID)
+	start := time.Now()
+	assert.Equal(t, map[int]bool{}, at.Wait([]*pendingAck{dropped}, time.Second))
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the sender stops waiting for a connection that went away")
+
+	assert.True(t, at.Ack("abc", other.ID))
+	assert.Equal(t, map[int]bool{other.ID: true}, at.Wait([]*pendingAck{kept}, time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, sink.len(), "a connection that went away is not dead lettered")
+	assert.Equal(t, 0, pendingAcksMock(at))
+
+	ns := NewNotificationServerEdgeMock()
+	subscriber, id := ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	ns.acks.Track("abc", subscriber, []byte("{}"), pm)
+	ns.CleanupIncomingConnection(id)
+	assert.Equal(t, 0, pendingAcksMock(ns.acks), "cleaning up a connection drops its pending acknowledgements")
+}
+
+func TestSendNotificationRequireAck(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	acked, _ := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "a"}, &websocket.Conn{}, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "b"}, &websocket.Conn{}, nil)
+
+	n := NotificationMock(map[string]string{"customer": "test"}, true)
+	n.RequireAck = true
+	n.AckTimeoutMs = 50
+	n.MessageID = "abc"
+	go func() {
+		for !ns.acks.Ack("abc", acked.ID) {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	message, _ := json.Marshal(n)
+	result, err := ns.SendNotification(n, message)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", result.NotificationID)
+	if assert.Equal(t, 2, len(result.Connections)) {
+		assert.Equal(t, DeliveryStatusAcked, result.Connections[0].Status)
+		assert.Equal(t, DeliveryStatusUnacked, result.Connections[1].Status)
+	}
+}
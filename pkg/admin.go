@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+// PathAdminConnectionsV1 is the admin API path of the routing table, served by the REST API listener
+const PathAdminConnectionsV1 = "/v1/admin/connections"
+
+// PathAdminScheduledV1 is the admin API path of the scheduled notifications, served by the REST API listener
+const PathAdminScheduledV1 = "/v1/admin/scheduled"
+
+const (
+	// ConnectionDirectionIncoming a connection of a local subscriber
+	ConnectionDirectionIncoming = "incoming"
+	// ConnectionDirectionOutgoing a connection to the parent gateway
+	ConnectionDirectionOutgoing = "outgoing"
+)
+
+// ConnectionInfo describes a connection of the routing table
+type ConnectionInfo struct {
+	ID         int               `json:"id"`
+	Direction  string            `json:"direction"`
+	Attributes map[string]string `json:"attributes"`
+	// Subscriptions are the attribute sets a gateway link advertised, the connection is routed by them instead of its attributes
+	Subscriptions []map[string]string `json:"subscriptions,omitempty"`
+	// Parent is the URL of the parent gateway of an outgoing connection
+	Parent       string    `json:"parent,omitempty"`
+	Stream       bool      `json:"stream"`
+	RemoteAddr   string    `json:"remoteAddr,omitempty"`
+	ConnectedAt  time.Time `json:"connectedAt"`
+	MessagesSent uint64    `json:"messagesSent"`
+	BytesSent    uint64    `json:"bytesSent"`
+}
+
+func (nh *Gateway) newConnectionInfo(conn *websocketactions.Connection, direction string) ConnectionInfo {
+	messages, bytes := conn.Sent()
+	parent := ""
+	if direction == ConnectionDirectionOutgoing {
+		parent = nh.connectionParent(conn.ID)
+	}
+	return ConnectionInfo{
+		ID:            conn.ID,
+		Direction:     direction,
+		Attributes:    conn.GetAttributes(),
+		Subscriptions: conn.Subscriptions(),
+		Parent:        parent,
+		Stream:        conn.IsStream(),
+		RemoteAddr:    conn.RemoteAddr(),
+		ConnectedAt:   conn.ConnectedAt(),
+		MessagesSent:  messages,
+		BytesSent:     bytes,
+	}
+}
+
+// AdminConnectionsHandler lists, inspects and disconnects the connections of the routing table:
+//
+//	GET    /v1/admin/connections[/{direction}][?attributes]  lists the connections matching the query attributes, all if there are none
+//	GET    /v1/admin/connections/{direction}/{id}             inspects a connection
+//	DELETE /v1/admin/connections/{direction}/{id}             disconnects a connection
+//	DELETE /v1/admin/connections/{direction}?attributes       disconnects the connections matching the query attributes
+//
+// The direction is either "incoming" or "outgoing". The admin API requires the admin token, it is disabled if there is none
+func (nh *Gateway) AdminConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if !nh.authorizeAdmin(w, r) {
+		return
+	}
+	direction, id, err := parseAdminConnectionsPath(r.URL.Path)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: err.Error()})
+		return
+	}
+	attributes := queryAttributes(r)
+
+	switch r.Method {
+	case http.MethodGet:
+		if id != nil {
+			conn := nh.findConnection(direction, *id)
+			if conn == nil {
+				writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: fmt.Sprintf("no %s connection with ID %d", direction, *id)})
+				return
+			}
+			writeJSON(w, http.StatusOK, nh.newConnectionInfo(conn, direction))
+			return
+		}
+		connections := []ConnectionInfo{}
+		for _, d := range []string{ConnectionDirectionIncoming, ConnectionDirectionOutgoing} {
+			if direction == "" || direction == d {
+				for _, conn := range connectionsMatching(nh.router(d), attributes) {
+					connections = append(connections, nh.newConnectionInfo(conn, d))
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"connections": connections})
+	case http.MethodDelete:
+		if direction == "" {
+			writeError(w, r, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: "the direction of the connections to disconnect is required"})
+			return
+		}
+		var disconnected []int
+		if id != nil {
+			conn := nh.findConnection(direction, *id)
+			if conn == nil {
+				writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: fmt.Sprintf("no %s connection with ID %d", direction, *id)})
+				return
+			}
+			nh.wa.Close(conn)
+			disconnected = nh.removeDisconnected(direction, []*websocketactions.Connection{conn})
+		} else {
+			if len(attributes) == 0 {
+				writeError(w, r, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: "no attributes received"})
+				return
+			}
+			disconnected = nh.disconnectMatching(direction, attributes)
+		}
+		logger.L().Info("disconnected connections by admin request", helpers.String("direction", direction), helpers.Interface("ids", disconnected))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"disconnected": disconnected})
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
+	}
+}
+
+// AdminScheduledHandler lists, inspects and cancels the scheduled notifications:
+//
+//	GET    /v1/admin/scheduled[?attributes]  lists the scheduled notifications whose target has the query attributes, the next due first
+//	GET    /v1/admin/scheduled/{id}          inspects a scheduled notification
+//	DELETE /v1/admin/scheduled/{id}          cancels a scheduled notification
+//
+// The ID is the message ID of the notification. Responds with 404 if scheduling is disabled
+func (nh *Gateway) AdminScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	if !nh.authorizeAdmin(w, r) {
+		return
+	}
+	if nh.scheduler == nil {
+		writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: "scheduling is disabled"})
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, PathAdminScheduledV1), "/")
+	if strings.Contains(id, "/") {
+		writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: fmt.Sprintf("unknown path '%s'", r.URL.Path)})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if id != "" {
+			sn, ok := nh.scheduler.Get(id)
+			if !ok {
+				writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: fmt.Sprintf("no scheduled notification with ID '%s'", id)})
+				return
+			}
+			writeJSON(w, http.StatusOK, sn)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"scheduled": nh.scheduler.List(queryAttributes(r))})
+	case http.MethodDelete:
+		if id == "" {
+			writeError(w, r, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: "the ID of the notification to cancel is required"})
+			return
+		}
+		sn, ok := nh.scheduler.Cancel(id)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: fmt.Sprintf("no scheduled notification with ID '%s'", id)})
+			return
+		}
+		logger.L().Info("cancelled scheduled notification by admin request", helpers.String("notificationID", id), helpers.String("target", strutils.ObjectToString(sn.Target)))
+		writeJSON(w, http.StatusOK, sn)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
+	}
+}
+
+// queryAttributes returns the attributes of the query of an admin request
+func queryAttributes(r *http.Request) map[string]string {
+	attributes := map[string]string{}
+	for k, v := range r.URL.Query() {
+		if k != "" && len(v) > 0 {
+			attributes[k] = v[0]
+		}
+	}
+	return attributes
+}
+
+// authorizeAdmin checks the admin token of a request. Responds with 404 if the admin API is disabled, and with 401 if the token does not match.
+// Returns true if the request is authorized
+func (nh *Gateway) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := nh.currentConfig().Admin.Token
+	if token == "" {
+		writeError(w, r, http.StatusNotFound, &ErrorResponse{Code: ErrorCodeNotFound, Message: "not found"})
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+		logger.L().Warning("unauthorized admin request", helpers.String("path", r.URL.Path), helpers.String("remote address", r.RemoteAddr))
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, http.StatusUnauthorized, &ErrorResponse{Code: ErrorCodeUnauthorized, Message: "invalid admin token"})
+		return false
+	}
+	return true
+}
+
+// parseAdminConnectionsPath returns the direction and the connection ID of an admin API path. Both are optional
+func parseAdminConnectionsPath(path string) (string, *int, error) {
+	rest := strings.Trim(strings.TrimPrefix(path, PathAdminConnectionsV1), "/")
+	if rest == "" {
+		return "", nil, nil
+	}
+	parts := strings.Split(rest, "/")
+	if parts[0] != ConnectionDirectionIncoming && parts[0] != ConnectionDirectionOutgoing {
+		return "", nil, fmt.Errorf("unknown connection direction '%s'", parts[0])
+	}
+	switch len(parts) {
+	case 1:
+		return parts[0], nil, nil
+	case 2:
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid connection ID '%s'", parts[1])
+		}
+		return parts[0], &id, nil
+	default:
+		return "", nil, fmt.Errorf("unknown path '%s'", path)
+	}
+}
+
+// router returns the routing table of a direction
+func (nh *Gateway) router(direction string) Router {
+	if direction == ConnectionDirectionOutgoing {
+		return nh.outgoingConnections
+	}
+	return nh.incomingConnections
+}
+
+// connectionsMatching returns the connections of a router matching the given attributes, all of them if there are no attributes
+func connectionsMatching(router Router, attributes map[string]string) []*websocketactions.Connection {
+	if len(attributes) == 0 {
+		return router.List()
+	}
+	return router.Get(attributes)
+}
+
+// findConnection returns the connection with a given ID, nil if there is none
+func (nh *Gateway) findConnection(direction string, id int) *websocketactions.Connection {
+	for _, conn := range nh.router(direction).List() {
+		if conn.ID == id {
+			return conn
+		}
+	}
+	return nil
+}
+
+// disconnectMatching closes the connections matching the given attributes and returns their IDs
+func (nh *Gateway) disconnectMatching(direction string, attributes map[string]string) []int {
+	conns := nh.router(direction).Get(attributes)
+	nh.router(direction).CloseConnections(nh.wa, attributes)
+	return nh.removeDisconnected(direction, conns)
+}
+
+// removeDisconnected cleans up the given closed connections and returns their IDs.
+// Incoming connections are removed from the routing table, the links to the parent gateway reconnect
+func (nh *Gateway) removeDisconnected(direction string, conns []*websocketactions.Connection) []int {
+	ids := make([]int, 0, len(conns))
+	for _, conn := range conns {
+		if direction == ConnectionDirectionIncoming {
+			nh.CleanupIncomingConnection(conn.ID)
+		}
+		logger.L().Debug("disconnected connection", helpers.String("direction", direction), helpers.Int("id", conn.ID), helpers.String("attributes", strutils.ObjectToString(conn.GetAttributes())))
+		ids = append(ids, conn.ID)
+	}
+	return ids
+}
+
+// writeJSON responds with a given status and JSON body
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
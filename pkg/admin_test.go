@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequestMock(ns *Gateway, method, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ns.AdminConnectionsHandler(w, r)
+	return w
+}
+
+func TestAdminConnectionsAuthorization(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	assert.Equal(t, http.StatusNotFound, adminRequestMock(ns, http.MethodGet, PathAdminConnectionsV1, "").Code, "the admin API is disabled without a token")
+
+	ns.config.Admin.Token = "secret"
+	assert.Equal(t, http.StatusUnauthorized, adminRequestMock(ns, http.MethodGet, PathAdminConnectionsV1, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequestMock(ns, http.MethodGet, PathAdminConnectionsV1, "guess").Code)
+	assert.Equal(t, http.StatusOK, adminRequestMock(ns, http.MethodGet, PathAdminConnectionsV1, "secret").Code)
+}
+
+func TestAdminConnectionsList(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Admin.Token = "secret"
+	_, id := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "a"}, nil, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "b"}, nil, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "other"}, nil, nil)
+	ns.outgoingConnections.Append(map[string]string{"customer": "test"}, nil, nil)
+	_, err := ns.SendNotification(NotificationMock(map[string]string{"cluster": "a"}, true), []byte("{}"))
+	assert.NoError(t, err)
+
+	list := func(target string) []ConnectionInfo {
+		w := adminRequestMock(ns, http.MethodGet, target, "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := struct {
+			Connections []ConnectionInfo `json:"connections"`
+		}{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Connections
+	}
+	assert.Equal(t, 4, len(list(PathAdminConnectionsV1)))
+	assert.Equal(t, 3, len(list(PathAdminConnectionsV1+"/incoming")))
+	assert.Equal(t, 3, len(list(PathAdminConnectionsV1+"?customer=test")), "the filter matches like the routing")
+	outgoing := list(PathAdminConnectionsV1 + "/outgoing?customer=test")
+	if assert.Equal(t, 1, len(outgoing)) {
+		assert.Equal(t, ConnectionDirectionOutgoing, outgoing[0].Direction)
+	}
+
+	w := adminRequestMock(ns, http.MethodGet, fmt.Sprintf("%s/incoming/%d", PathAdminConnectionsV1, id), "secret")
+	info := ConnectionInfo{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, id, info.ID)
+	assert.Equal(t, uint64(1), info.MessagesSent)
+	assert.Equal(t, uint64(2), info.BytesSent)
+	assert.False(t, info.ConnectedAt.IsZero())
+
+	assert.Equal(t, http.StatusNotFound, adminRequestMock(ns, http.MethodGet, PathAdminConnectionsV1+"/outgoing/1", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, adminRequestMock(ns, http.MethodGet, PathAdminConnectionsV1+"/sideways", "secret").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequestMock(ns, http.MethodPost, PathAdminConnectionsV1, "secret").Code)
+}
+
+func TestAdminConnectionsDisconnect(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Admin.Token = "secret"
+	_, id := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "a"}, nil, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "b"}, nil, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "other"}, nil, nil)
+
+	w := adminRequestMock(ns, http.MethodDelete, fmt.Sprintf("%s/incoming/%d", PathAdminConnectionsV1, id), "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"disconnected":[%d]}`, id), w.Body.String())
+	assert.Equal(t, 2, ns.incomingConnections.Len())
+	assert.Equal(t, http.StatusNotFound, adminRequestMock(ns, http.MethodDelete, fmt.Sprintf("%s/incoming/%d", PathAdminConnectionsV1, id), "secret").Code)
+
+	assert.Equal(t, http.StatusBadRequest, adminRequestMock(ns, http.MethodDelete, PathAdminConnectionsV1+"/incoming", "secret").Code, "disconnecting everything requires attributes")
+	assert.Equal(t, http.StatusBadRequest, adminRequestMock(ns, http.MethodDelete, PathAdminConnectionsV1+"?customer=test", "secret").Code, "disconnecting requires a direction")
+
+	w = adminRequestMock(ns, http.MethodDelete, PathAdminConnectionsV1+"/incoming?customer=test", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, ns.incomingConnections.Len())
+	assert.Equal(t, 1, len(ns.incomingConnections.Get(map[string]string{"customer": "other"})))
+}
+
+func TestParseAdminConnectionsPath(t *testing.T) {
+	id := 42
+	tests := []struct {
+		path      string
+		direction string
+		id        *int
+		wantErr   bool
+	}{
+		{path: PathAdminConnectionsV1},
+		{path: PathAdminConnectionsV1 + "/"},
+		{path: PathAdminConnectionsV1 + "/incoming", direction: ConnectionDirectionIncoming},
+		{path: PathAdminConnectionsV1 + "/outgoing/42", direction: ConnectionDirectionOutgoing, id: &id},
+		{path: PathAdminConnectionsV1 + "/outgoing/x", wantErr: true},
+		{path: PathAdminConnectionsV1 + "/other", wantErr: true},
+		{path: PathAdminConnectionsV1 + "/incoming/1/2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			direction, id, err := parseAdminConnectionsPath(tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.direction, direction)
+			assert.Equal(t, tt.id, id)
+		})
+	}
+}
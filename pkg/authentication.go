@@ -0,0 +1,262 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	beServerV1 "github.com/kubescape/backend/pkg/server/v1"
+)
+
+// AnyValue allows a credential to subscribe to any value of an attribute
+const AnyValue = "*"
+
+// CredentialPolicy binds a credential to the attribute values it may subscribe to
+type CredentialPolicy struct {
+	// Name identifies the credential in logs
+	Name string `json:"name"`
+	// AccessKey is matched against the access key header
+	AccessKey string `json:"accessKey,omitempty"`
+	// Subject is matched against the "sub" claim of a bearer token
+	Subject string `json:"subject,omitempty"`
+	// Allowed maps attribute keys to the values the credential may subscribe to.
+	// A subscription must set every listed key to one of its values, AnyValue allows any value
+	Allowed map[string][]string `json:"allowed"`
+}
+
+// JWTPolicy describes how bearer tokens are validated
+type JWTPolicy struct {
+	// HMACSecret validates HS256/HS384/HS512 signed tokens
+	HMACSecret string `json:"hmacSecret,omitempty"`
+	// PublicKeyFile is a PEM encoded RSA or ECDSA public key validating RS*/ES* signed tokens
+	PublicKeyFile string `json:"publicKeyFile,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+	Audience      string `json:"audience,omitempty"`
+	// AllowedClaim is a token claim holding the allowed attribute values, used when no credential policy matches the subject
+	AllowedClaim string `json:"allowedClaim,omitempty"`
+}
+
+// AuthPolicy describes the credentials allowed to subscribe
+type AuthPolicy struct {
+	Credentials []CredentialPolicy `json:"credentials"`
+	JWT         *JWTPolicy         `json:"jwt,omitempty"`
+}
+
+// Principal is an authenticated client
+type Principal struct {
+	// Name identifies the credential, never the secret itself
+	Name    string
+	Allowed map[string][]string
+}
+
+// Authorize checks a principal may subscribe to the given attributes.
+// Since routing matches on any shared attribute, every key the principal is bound to must be present
+func (p *Principal) Authorize(attributes map[string]string) error {
+	for key, values := range p.Allowed {
+		value, ok := attributes[key]
+		if !ok {
+			return fmt.Errorf("attribute '%s' is required", key)
+		}
+		allowed := false
+		for _, v := range values {
+			if v == AnyValue || v == value {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("not allowed to subscribe to %s=%s", key, value)
+		}
+	}
+	return nil
+}
+
+// Authenticator authenticates clients by an access key header or a bearer JWT, according to an AuthPolicy
+type Authenticator struct {
+	// accessKeys are keyed by the SHA-256 of the access key
+	accessKeys map[[sha256.Size]byte]*CredentialPolicy
+	subjects   map[string]*CredentialPolicy
+	jwtPolicy  *JWTPolicy
+	jwtKey     interface{}
+	jwtMethods []string
+}
+
+// NewAuthenticator creates an Authenticator enforcing a given policy
+func NewAuthenticator(policy *AuthPolicy) (*Authenticator, error) {
+	a := &Authenticator{
+		accessKeys: map[[sha256.Size]byte]*CredentialPolicy{},
+		subjects:   map[string]*CredentialPolicy{},
+		jwtPolicy:  policy.JWT,
+	}
+	for i := range policy.Credentials {
+		c := &policy.Credentials[i]
+		if c.AccessKey == "" && c.Subject == "" {
+			return nil, fmt.Errorf("credential '%s' has neither an access key nor a subject", c.Name)
+		}
+		if c.AccessKey != "" {
+			a.accessKeys[sha256.Sum256([]byte(c.AccessKey))] = c
+		}
+		if c.Subject != "" {
+			a.subjects[c.Subject] = c
+		}
+	}
+	if policy.JWT == nil {
+		return a, nil
+	}
+	switch {
+	case policy.JWT.HMACSecret != "":
+		a.jwtKey = []byte(policy.JWT.HMACSecret)
+		a.jwtMethods = []string{"HS256", "HS384", "HS512"}
+	case policy.JWT.PublicKeyFile != "":
+		pem, err := os.ReadFile(policy.JWT.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key, reason: %s", err.Error())
+		}
+		if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			a.jwtKey = key
+			a.jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+		} else if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+			a.jwtKey = key
+			a.jwtMethods = []string{"ES256", "ES384", "ES512"}
+		} else {
+			return nil, fmt.Errorf("JWT public key is neither RSA nor ECDSA")
+		}
+	default:
+		return nil, fmt.Errorf("JWT policy requires a HMAC secret or a public key file")
+	}
+	return a, nil
+}
+
+// LoadAuthPolicy reads an AuthPolicy from a JSON file
+func LoadAuthPolicy(path string) (*AuthPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth policy '%s', reason: %s", path, err.Error())
+	}
+	policy := &AuthPolicy{}
+	if err := json.Unmarshal(b, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse auth policy '%s', reason: %s", path, err.Error())
+	}
+	return policy, nil
+}
+
+// newAuthenticator creates the configured Authenticator.
+// Returns nil when authentication is disabled
+func newAuthenticator(cfg AuthConfig) *Authenticator {
+	path := cfg.PolicyFile
+	if path == "" {
+		logger.L().Warning("no auth policy configured, subscribers are not authenticated")
+		return nil
+	}
+	policy, err := LoadAuthPolicy(path)
+	if err == nil {
+		var a *Authenticator
+		if a, err = NewAuthenticator(policy); err == nil {
+			logger.L().Info("loaded auth policy", helpers.String("path", path), helpers.Int("credentials", len(policy.Credentials)))
+			return a
+		}
+	}
+	// never fall back to accepting everyone
+	logger.L().Fatal("failed to load auth policy", helpers.String("path", path), helpers.Error(err))
+	return nil
+}
+
+// Authenticate returns the principal of a request carrying an access key header or a bearer token
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if accessKey := r.Header.Get(beServerV1.AccessKeyHeader); accessKey != "" {
+		c, ok := a.accessKeys[sha256.Sum256([]byte(accessKey))]
+		if !ok {
+			return nil, fmt.Errorf("unknown access key")
+		}
+		return &Principal{Name: c.Name, Allowed: c.Allowed}, nil
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return a.authenticateToken(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return nil, fmt.Errorf("missing credentials")
+}
+
+// authenticateToken validates a bearer JWT and returns its principal
+func (a *Authenticator) authenticateToken(token string) (*Principal, error) {
+	if a.jwtKey == nil {
+		return nil, fmt.Errorf("bearer tokens are not accepted")
+	}
+	options := []jwt.ParserOption{jwt.WithValidMethods(a.jwtMethods), jwt.WithExpirationRequired()}
+	if a.jwtPolicy.Issuer != "" {
+		options = append(options, jwt.WithIssuer(a.jwtPolicy.Issuer))
+	}
+	if a.jwtPolicy.Audience != "" {
+		options = append(options, jwt.WithAudience(a.jwtPolicy.Audience))
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return a.jwtKey, nil }, options...); err != nil {
+		return nil, fmt.Errorf("invalid bearer token, reason: %s", err.Error())
+	}
+	subject, _ := claims.GetSubject()
+	if c, ok := a.subjects[subject]; ok {
+		return &Principal{Name: c.Name, Allowed: c.Allowed}, nil
+	}
+	if a.jwtPolicy.AllowedClaim != "" {
+		if allowed, ok := parseAllowedClaim(claims[a.jwtPolicy.AllowedClaim]); ok {
+			return &Principal{Name: subject, Allowed: allowed}, nil
+		}
+	}
+	return nil, fmt.Errorf("no policy for subject '%s'", subject)
+}
+
+// parseAllowedClaim converts a claim of the form {"key": "value"} or {"key": ["value", ...]} to allowed attribute values
+func parseAllowedClaim(claim interface{}) (map[string][]string, bool) {
+	m, ok := claim.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	allowed := map[string][]string{}
+	for key, value := range m {
+		switch v := value.(type) {
+		case string:
+			allowed[key] = []string{v}
+		case []interface{}:
+			for i := range v {
+				s, ok := v[i].(string)
+				if !ok {
+					return nil, false
+				}
+				allowed[key] = append(allowed[key], s)
+			}
+		default:
+			return nil, false
+		}
+	}
+	return allowed, true
+}
+
+// authorizeSubscription authenticates a subscription request and checks it may subscribe to the attributes in its query and client certificate.
+// Returns the error to respond with when the subscription is rejected, nil otherwise
+func (nh *Gateway) authorizeSubscription(r *http.Request) *restError {
+	attributes, err := nh.parseURLPath(r.URL)
+	if err != nil {
+		return &restError{status: http.StatusBadRequest, response: &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: err.Error()}}
+	}
+	if attributes, err = nh.serverTLS.applyClientAttributes(r, attributes); err != nil {
+		return &restError{status: http.StatusForbidden, response: &ErrorResponse{Code: ErrorCodeForbidden, Message: err.Error()}}
+	}
+	if nh.authenticator == nil {
+		return nil
+	}
+	principal, err := nh.authenticator.Authenticate(r)
+	if err != nil {
+		return &restError{status: http.StatusUnauthorized, response: &ErrorResponse{Code: ErrorCodeUnauthorized, Message: err.Error()}}
+	}
+	if err := principal.Authorize(attributes); err != nil {
+		logger.L().Warning("rejected subscription", helpers.String("credential", principal.Name), helpers.Error(err))
+		return &restError{status: http.StatusForbidden, response: &ErrorResponse{Code: ErrorCodeForbidden, Message: err.Error()}}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	beServerV1 "github.com/kubescape/backend/pkg/server/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func authPolicyMock() *AuthPolicy {
+	return &AuthPolicy{
+		Credentials: []CredentialPolicy{
+			{Name: "operator", AccessKey: "key-1", Allowed: map[string][]string{"customer": {"test"}}},
+			{Name: "backend", Subject: "backend", Allowed: map[string][]string{"customer": {AnyValue}}},
+		},
+		JWT: &JWTPolicy{HMACSecret: "secret", Issuer: "kubescape", AllowedClaim: "gateway"},
+	}
+}
+
+func signedTokenMock(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	return token
+}
+
+func TestAuthenticatorAccessKey(t *testing.T) {
+	a, err := NewAuthenticator(authPolicyMock())
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = a.Authenticate(r)
+	assert.Error(t, err, "credentials are required")
+
+	r.Header.Set(beServerV1.AccessKeyHeader, "key-2")
+	_, err = a.Authenticate(r)
+	assert.Error(t, err)
+
+	r.Header.Set(beServerV1.AccessKeyHeader, "key-1")
+	p, err := a.Authenticate(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "operator", p.Name)
+}
+
+func TestAuthenticatorBearerToken(t *testing.T) {
+	a, err := NewAuthenticator(authPolicyMock())
+	assert.NoError(t, err)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "subject policy",
+			claims: jwt.MapClaims{"sub": "backend", "iss": "kubescape", "exp": exp},
+			want:   "backend",
+		},
+		{
+			name:   "allowed claim",
+			claims: jwt.MapClaims{"sub": "operator-2", "iss": "kubescape", "exp": exp, "gateway": map[string]interface{}{"customer": []string{"a", "b"}}},
+			want:   "operator-2",
+		},
+		{
+			name:    "unknown subject",
+			claims:  jwt.MapClaims{"sub": "other", "iss": "kubescape", "exp": exp},
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			claims:  jwt.MapClaims{"sub": "backend", "iss": "other", "exp": exp},
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			claims:  jwt.MapClaims{"sub": "backend", "iss": "kubescape", "exp": time.Now().Add(-time.Hour).Unix()},
+			wantErr: true,
+		},
+		{
+			name:    "no expiration",
+			claims:  jwt.MapClaims{"sub": "backend", "iss": "kubescape"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+signedTokenMock(t, tt.claims))
+			p, err := a.Authenticate(r)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, p.Name)
+			}
+		})
+	}
+}
+
+func TestPrincipalAuthorize(t *testing.T) {
+	p := &Principal{Allowed: map[string][]string{"customer": {"a", "b"}, "cluster": {AnyValue}}}
+	assert.NoError(t, p.Authorize(map[string]string{"customer": "a", "cluster": "x"}))
+	assert.NoError(t, p.Authorize(map[string]string{"customer": "b", "cluster": "y", "component": "z"}))
+	assert.Error(t, p.Authorize(map[string]string{"customer": "c", "cluster": "x"}))
+	assert.Error(t, p.Authorize(map[string]string{"cluster": "x"}), "bound keys must be present, otherwise the subscription matches any customer")
+}
+
+func TestAuthorizeSubscription(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	assert.Nil(t, ns.authorizeSubscription(httptest.NewRequest(http.MethodGet, "/?customer=other", nil)), "authentication is disabled by default")
+
+	ns.authenticator, _ = NewAuthenticator(authPolicyMock())
+	assert.Nil(t, func() *restError {
+		r := httptest.NewRequest(http.MethodGet, "/?customer=test", nil)
+		r.Header.Set(beServerV1.AccessKeyHeader, "key-1")
+		return ns.authorizeSubscription(r)
+	}())
+	for query, want := range map[string]string{"?customer=other": ErrorCodeForbidden, "?cluster=yay": ErrorCodeForbidden, "": ErrorCodeInvalidRequest} {
+		r := httptest.NewRequest(http.MethodGet, "/"+query, nil)
+		r.Header.Set(beServerV1.AccessKeyHeader, "key-1")
+		if e := ns.authorizeSubscription(r); assert.NotNil(t, e, query) {
+			assert.Equal(t, want, e.response.Code, query)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	ns.WebsocketNotificationHandler(w, httptest.NewRequest(http.MethodGet, "/?customer=test", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"unauthorized"`)
+}
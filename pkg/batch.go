@@ -0,0 +1,255 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode"
+
+	notifier "github.com/armosec/cluster-notifier-api-go/notificationserver"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+// PathBatchV1 is the REST API path sending many notifications in one request
+const PathBatchV1 = notifier.PathRESTV1 + "/batch"
+
+// maxBatchPreparedMessages bounds the prepared messages a batch keeps for reuse
+const maxBatchPreparedMessages = 64
+
+// BatchItemResult is the outcome of a single notification of a batch
+type BatchItemResult struct {
+	// Index is the position of the notification in the batch
+	Index int `json:"index"`
+	// Status is the HTTP status the notification would have been answered with on its own
+	Status int            `json:"status"`
+	Result *SendResult    `json:"result,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+}
+
+// preparedMessages reuses the prepared message of a notification for the identical notifications of a batch,
+// so their websocket frames are built once. A nil preparedMessages prepares every notification
+type preparedMessages struct {
+	messages map[[sha256.Size]byte]*websocketactions.PreparedMessage
+}
+
+func newPreparedMessages() *preparedMessages {
+	return &preparedMessages{messages: map[[sha256.Size]byte]*websocketactions.PreparedMessage{}}
+}
+
+// prepare returns the prepared message of a notification, reusing the message of an identical notification
+func (pm *preparedMessages) prepare(notification []byte) (*websocketactions.PreparedMessage, error) {
+	if pm == nil {
+		return websocketactions.NewPreparedMessage(notification)
+	}
+	key := sha256.Sum256(notification)
+	if preparedMessage, ok := pm.messages[key]; ok {
+		return preparedMessage, nil
+	}
+	preparedMessage, err := websocketactions.NewPreparedMessage(notification)
+	if err != nil {
+		return nil, err
+	}
+	if len(pm.messages) >= maxBatchPreparedMessages {
+		// a batch of distinct notifications must not keep all of them in memory
+		pm.messages = map[[sha256.Size]byte]*websocketactions.PreparedMessage{}
+	}
+	pm.messages[key] = preparedMessage
+	return preparedMessage, nil
+}
+
+// batchWriter streams the results of a batch, as a JSON array or as newline delimited JSON
+type batchWriter struct {
+	w     http.ResponseWriter
+	array bool
+	count int
+}
+
+func newBatchWriter(w http.ResponseWriter, array bool) *batchWriter {
+	if array {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	return &batchWriter{w: w, array: array}
+}
+
+// write sends the result of a notification to the sender right away
+func (bw *batchWriter) write(item *BatchItemResult) {
+	b, _ := json.Marshal(item)
+	switch {
+	case !bw.array:
+		b = append(b, '\n')
+	case bw.count == 0:
+		b = append([]byte{'['}, b...)
+	default:
+		b = append([]byte{','}, b...)
+	}
+	bw.count++
+	bw.w.Write(b)
+	if flusher, ok := bw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// close ends the JSON array of the results
+func (bw *batchWriter) close() {
+	if !bw.array {
+		return
+	}
+	if bw.count == 0 {
+		bw.w.Write([]byte("[]"))
+		return
+	}
+	bw.w.Write([]byte("]"))
+}
+
+// errBatchItemTooLarge is returned by an itemLimitReader once the notification being decoded exceeds the maximal body size
+var errBatchItemTooLarge = errors.New("notification exceeds the maximal body size")
+
+// itemLimitReader reads the body of a batch for its decoder, and fails once the decoder reads further than a limit.
+// The limit is moved to the start of every notification, so a notification is bounded while it is decoded, not once it was buffered whole
+type itemLimitReader struct {
+	r     *bufio.Reader
+	read  int64
+	limit int64
+	// exceeded is set once a read failed for reaching the limit
+	exceeded bool
+}
+
+// from bounds the notification starting at a given offset of the body to a given size, 0 does not bound it
+func (l *itemLimitReader) from(offset int64, size int) {
+	l.limit = 0
+	if size > 0 {
+		l.limit = offset + int64(size)
+	}
+}
+
+func (l *itemLimitReader) Read(p []byte) (int, error) {
+	if l.limit > 0 {
+		if l.read >= l.limit {
+			// the body may just end at the limit
+			if _, err := l.r.Peek(1); err != nil {
+				return 0, err
+			}
+			l.exceeded = true
+			return 0, errBatchItemTooLarge
+		}
+		if remaining := l.limit - l.read; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// isJSONArray reports whether a body holds a JSON array of notifications, rather than newline delimited JSON
+func isJSONArray(body *bufio.Reader) bool {
+	for {
+		b, err := body.ReadByte()
+		if err != nil {
+			return false
+		}
+		if !unicode.IsSpace(rune(b)) {
+			body.UnreadByte()
+			return b == '['
+		}
+	}
+}
+
+// BatchNotificationHandler sends the notifications of a JSON array, or of a newline delimited JSON stream, received over the REST API.
+// The notifications are read and sent one at a time, in order, and the result of each is streamed back in the same format,
+// so a batch never has to fit in memory. Every notification is validated, rate limited and limited to the maximal body size on its own,
+// a notification exceeding the maximal body size is not read any further and ends the batch
+func (nh *Gateway) BatchNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	id := requestID(w, r)
+	if r.Method != http.MethodPost {
+		logger.L().Error("Method not allowed. returning 405", helpers.String("requestID", id))
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+	if nh.shuttingDown.Load() {
+		writeError(w, r, http.StatusServiceUnavailable, &ErrorResponse{Code: ErrorCodeShuttingDown, Message: "gateway is shutting down"})
+		return
+	}
+	defer r.Body.Close()
+
+	body := bufio.NewReader(r.Body)
+	array := isJSONArray(body)
+	limited := &itemLimitReader{r: body}
+	decoder := json.NewDecoder(limited)
+	if array {
+		decoder.Token() // the opening bracket
+	}
+	results := newBatchWriter(w, array)
+	defer results.close()
+	fail := func(index, status int, response *ErrorResponse) {
+		response.RequestID = id
+		results.write(&BatchItemResult{Index: index, Status: status, Error: response})
+	}
+
+	sender := ""
+	if nh.rateLimiter != nil {
+		sender = nh.senderOf(r)
+	}
+	prepared := newPreparedMessages()
+	maxBodySize := nh.currentConfig().Validation.MaxBodySize
+	tooLarge := func(index int) {
+		logger.L().Error("in BatchNotificationHandler, notification too large", helpers.String("requestID", id), helpers.Int("index", index), helpers.Int("maxBodySize", maxBodySize))
+		fail(index, http.StatusRequestEntityTooLarge, &ErrorResponse{Code: ErrorCodeBodyTooLarge, Message: fmt.Sprintf("notification exceeds %d bytes", maxBodySize)})
+	}
+	index := 0
+	for ; ; index++ {
+		// the separator and the whitespace before a notification are bounded like the notification
+		limited.from(decoder.InputOffset(), maxBodySize+1)
+		if !decoder.More() {
+			break
+		}
+		if nh.shuttingDown.Load() {
+			fail(index, http.StatusServiceUnavailable, &ErrorResponse{Code: ErrorCodeShuttingDown, Message: "gateway is shutting down"})
+			return
+		}
+		notification := json.RawMessage{}
+		limited.from(decoder.InputOffset(), maxBodySize+1)
+		if err := decoder.Decode(&notification); err != nil {
+			// the rest of the stream cannot be parsed
+			if errors.Is(err, errBatchItemTooLarge) {
+				tooLarge(index)
+				return
+			}
+			logger.L().Error("in BatchNotificationHandler Decode", helpers.String("requestID", id), helpers.Int("index", index), helpers.Error(err))
+			fail(index, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: fmt.Sprintf("malformed batch, reason: %s", err.Error())})
+			return
+		}
+		if maxBodySize > 0 && len(notification) > maxBodySize {
+			tooLarge(index)
+			return
+		}
+		result, e := nh.sendRestNotification(id, sender, notification, prepared)
+		if e != nil {
+			e.response.RequestID = id
+			results.write(&BatchItemResult{Index: index, Status: e.status, Result: result, Error: e.response})
+			continue
+		}
+		results.write(&BatchItemResult{Index: index, Status: http.StatusOK, Result: result})
+	}
+	if limited.exceeded {
+		// the whitespace before a notification exceeded the maximal body size
+		tooLarge(index)
+		return
+	}
+	if array {
+		limited.from(0, 0)
+		if _, err := decoder.Token(); err != nil {
+			fail(index, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: fmt.Sprintf("malformed batch, reason: %s", err.Error())})
+		}
+	}
+	logger.L().Info("in BatchNotificationHandler, batch sent", helpers.String("requestID", id), helpers.Int("notifications", index))
+}
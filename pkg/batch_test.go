@@ -0,0 +1,148 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// sendBatchMock sends a batch to a given gateway and returns the results, none if the whole batch failed
+func sendBatchMock(t *testing.T, ns *Gateway, body string) (*httptest.ResponseRecorder, []BatchItemResult) {
+	w := httptest.NewRecorder()
+	ns.BatchNotificationHandler(w, httptest.NewRequest(http.MethodPost, PathBatchV1, strings.NewReader(body)))
+	return w, batchResultsMock(t, w)
+}
+
+// batchResultsMock returns the results of a batch, none if the whole batch failed
+func batchResultsMock(t *testing.T, w *httptest.ResponseRecorder) []BatchItemResult {
+	results := []BatchItemResult{}
+	if w.Code != http.StatusOK {
+		return results
+	}
+	if w.Header().Get("Content-Type") == "application/json" {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results), w.Body.String())
+		return results
+	}
+	decoder := json.NewDecoder(w.Body)
+	for decoder.More() {
+		item := BatchItemResult{}
+		if !assert.NoError(t, decoder.Decode(&item)) {
+			break
+		}
+		results = append(results, item)
+	}
+	return results
+}
+
+func statuses(results []BatchItemResult) []int {
+	s := []int{}
+	for _, item := range results {
+		s = append(s, item.Status)
+	}
+	return s
+}
+
+func TestBatchNotificationHandler(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Validation.MaxBodySize = 128
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+
+	w, results := sendBatchMock(t, ns, ` [
+		{"target":{"customer":"test"},"sendSynchronicity":true},
+		{"target":{}},
+		{"target":{"customer":"test"},"notification":"`+strings.Repeat("a", 128)+`"},
+		{"target":{"customer":"test","cluster":"yay"},"sendSynchronicity":true}
+	]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, []int{http.StatusOK, http.StatusBadRequest, http.StatusRequestEntityTooLarge}, statuses(results), "a notification too large ends the batch")
+	if assert.Equal(t, 3, len(results)) {
+		for i, item := range results {
+			assert.Equal(t, i, item.Index)
+		}
+		assert.Equal(t, 1, len(results[0].Result.Connections))
+		assert.Equal(t, DeliveryStatusSent, results[0].Result.Connections[0].Status)
+		assert.Equal(t, ErrorCodeInvalidNotification, results[1].Error.Code)
+		assert.Equal(t, ErrorCodeBodyTooLarge, results[2].Error.Code)
+		assert.Equal(t, w.Header().Get(RequestIDHeader), results[1].Error.RequestID)
+	}
+
+	_, results = sendBatchMock(t, ns, `[]`)
+	assert.Equal(t, 0, len(results))
+}
+
+// endlessReader reads the same byte forever, counting the bytes read
+type endlessReader struct {
+	read int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += len(p)
+	return len(p), nil
+}
+
+func TestBatchNotificationHandlerItemTooLarge(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Validation.MaxBodySize = 1024
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+
+	for _, prefix := range []string{`[{"target":{"customer":"test"}}, {"notification":"`, `{"target":{"customer":"test"}}` + "\n" + `{"notification":"`} {
+		endless := &endlessReader{}
+		w := httptest.NewRecorder()
+		ns.BatchNotificationHandler(w, httptest.NewRequest(http.MethodPost, PathBatchV1, io.MultiReader(strings.NewReader(prefix), endless)))
+		assert.Less(t, endless.read, 64*1024, "a notification is bounded while it is read")
+		assert.Equal(t, []int{http.StatusOK, http.StatusRequestEntityTooLarge}, statuses(batchResultsMock(t, w)), w.Body.String())
+	}
+
+	// notifications ending right at the limit are read whole
+	notification := `{"target":{"customer":"test"},"notification":"` + strings.Repeat("a", 1024-48) + `"}`
+	_, results := sendBatchMock(t, ns, "["+notification+","+notification+"]")
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, statuses(results))
+	_, results = sendBatchMock(t, ns, notification+"\n"+notification)
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK}, statuses(results))
+}
+
+func TestBatchNotificationHandlerNDJSON(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Routing.Strict = true
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+
+	w, results := sendBatchMock(t, ns, `{"target":{"customer":"test"}}
+{"target":{"customer":"nobody"}}
+{"target":{"customer":"test"}`)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, []int{http.StatusOK, http.StatusNotFound, http.StatusBadRequest}, statuses(results))
+	if assert.Equal(t, 3, len(results)) {
+		assert.Equal(t, ErrorCodeNoSubscribers, results[1].Error.Code)
+		assert.NotEmpty(t, results[1].Result.NotificationID)
+		assert.Equal(t, ErrorCodeInvalidRequest, results[2].Error.Code, "a truncated stream ends the batch")
+	}
+
+	ns.shuttingDown.Store(true)
+	w, _ = sendBatchMock(t, ns, `{"target":{"customer":"test"}}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestPreparedMessages(t *testing.T) {
+	prepared := newPreparedMessages()
+	first, err := prepared.prepare([]byte(`{"target":{"customer":"a"}}`))
+	assert.NoError(t, err)
+	second, _ := prepared.prepare([]byte(`{"target":{"customer":"a"}}`))
+	other, _ := prepared.prepare([]byte(`{"target":{"customer":"b"}}`))
+	assert.Same(t, first, second, "identical notifications share their prepared message")
+	assert.NotSame(t, first, other)
+
+	var none *preparedMessages
+	third, err := none.prepare([]byte(`{"target":{"customer":"a"}}`))
+	assert.NoError(t, err)
+	assert.NotSame(t, first, third)
+}
@@ -0,0 +1,552 @@
+package gateway
+
+import (
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	notifier "github.com/armosec/cluster-notifier-api-go/notificationserver"
+	"github.com/kubescape/backend/pkg/servicediscovery"
+	v2 "github.com/kubescape/backend/pkg/servicediscovery/v2"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultCredentialsPath      = "/etc/credentials"
+	defaultServiceDiscoveryPath = "/etc/config/services.json"
+)
+
+// Duration is a time.Duration written as a Go duration string, e.g. "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\", got %s", string(b))
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the configuration of a Gateway.
+// It is loaded from the JSON or YAML file set by the CONFIG environment variable, and the environment variables override it
+type Config struct {
+	Listeners  ListenersConfig  `json:"listeners"`
+	Parent     ParentConfig     `json:"parent"`
+	Routing    RoutingConfig    `json:"routing"`
+	Buffer     BufferConfig     `json:"buffer"`
+	Acks       AcksConfig       `json:"acks"`
+	Requests   RequestsConfig   `json:"requests"`
+	Dedup      DedupConfig      `json:"dedup"`
+	RateLimits RateLimitsConfig `json:"rateLimits"`
+	Validation ValidationConfig `json:"validation"`
+	Scheduler  SchedulerConfig  `json:"scheduler"`
+	SendQueue  SendQueueConfig  `json:"sendQueue"`
+	Auth       AuthConfig       `json:"auth"`
+	Admin      AdminConfig      `json:"admin"`
+	Metrics    MetricsConfig    `json:"metrics"`
+	// ShutdownTimeout is the time a graceful shutdown may take
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// RootGatewayURL is the parent URL key of the shared cluster config (clusterData.json), used if Parent.URL is not set
+	RootGatewayURL string `json:"rootGatewayURL,omitempty"`
+	// path is the file the config was loaded from, empty if there is none
+	path string
+}
+
+// ListenersConfig configures the listeners
+type ListenersConfig struct {
+	WebsocketPort string    `json:"websocketPort"`
+	RestAPIPort   string    `json:"restAPIPort"`
+	TLS           TLSConfig `json:"tls"`
+}
+
+// TLSConfig configures TLS on both listeners. TLS is disabled if CertFile and KeyFile are not set
+type TLSConfig struct {
+	CertFile     string `json:"certFile,omitempty"`
+	KeyFile      string `json:"keyFile,omitempty"`
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+	// ClientSubjectAttributes maps client certificate subject fields (e.g. "CN") to connection attributes
+	ClientSubjectAttributes map[string]string `json:"clientSubjectAttributes,omitempty"`
+}
+
+// ParentConfig configures the link to the parent gateway
+type ParentConfig struct {
+	// URL of the parent gateway, discovered from ServiceDiscoveryPath if not set. A gateway without a parent is a root gateway
+	URL string `json:"url,omitempty"`
+	// URLs are the parent gateways in order of preference, they take precedence over URL
+	URLs                    []string `json:"urls,omitempty"`
+	ServiceDiscoveryPath    string   `json:"serviceDiscoveryPath"`
+	CredentialsPath         string   `json:"credentialsPath"`
+	ReconnectInitialBackoff Duration `json:"reconnectInitialBackoff"`
+	ReconnectMaxBackoff     Duration `json:"reconnectMaxBackoff"`
+	// Multiplexed advertises the attribute sets of the local subscribers over each link, so the parent only routes what they subscribed to.
+	// The parent must support subscription frames
+	Multiplexed bool `json:"multiplexed"`
+	// Mode is how a gateway with several parents links to them, either "failover" or "active-active"
+	Mode string `json:"mode"`
+	// HealthCheckInterval is how often a link that failed over probes the parents it prefers, to fail back to them
+	HealthCheckInterval Duration `json:"healthCheckInterval"`
+}
+
+// parentURLs returns the URLs of the parent gateways in order of preference, none for a root gateway
+func (p *ParentConfig) parentURLs() []string {
+	if len(p.URLs) > 0 {
+		return p.URLs
+	}
+	if p.URL != "" {
+		return []string{p.URL}
+	}
+	return nil
+}
+
+// RoutingConfig configures how subscriptions are routed
+type RoutingConfig struct {
+	// ParentAttributes are the attribute keys of a subscription an edge gateway subscribes to the parent with
+	ParentAttributes []string `json:"parentAttributes"`
+	// Mode is either RoutingModeDownstream or RoutingModeBidirectional
+	Mode string `json:"mode"`
+	// MaxHops is the number of gateways a notification may be forwarded to the parent by
+	MaxHops int `json:"maxHops"`
+	// Strict responds 404 to the REST API senders of notifications nobody subscribed to, which were neither buffered nor forwarded
+	Strict bool `json:"strict"`
+}
+
+// BufferConfig configures buffering notifications for routes without subscribers. Buffering is disabled if Type is not set
+type BufferConfig struct {
+	// Type is NotificationBufferMemory or NotificationBufferDisk
+	Type string   `json:"type,omitempty"`
+	Dir  string   `json:"dir"`
+	TTL  Duration `json:"ttl"`
+	Size int      `json:"size"`
+}
+
+// AcksConfig configures delivery acknowledgements
+type AcksConfig struct {
+	Timeout       Duration `json:"timeout"`
+	RetryInterval Duration `json:"retryInterval"`
+	MaxRetries    int      `json:"maxRetries"`
+	// DeadLetterFile is the file the unacknowledged notifications are appended to, they are only logged if not set
+	DeadLetterFile string `json:"deadLetterFile,omitempty"`
+}
+
+// RequestsConfig configures the requests, the notifications the subscribers reply to
+type RequestsConfig struct {
+	// ReplyTimeout is how long to wait for the replies to a request that does not set replyTimeoutMs
+	ReplyTimeout Duration `json:"replyTimeout"`
+}
+
+// DedupConfig configures dropping the notifications a subscriber already received
+type DedupConfig struct {
+	// Window is how long the message IDs of the notifications are remembered, 0 disables deduplication.
+	// When enabled, every notification is stamped with a message ID
+	Window Duration `json:"window"`
+	// Size caps the number of remembered deliveries
+	Size int `json:"size"`
+}
+
+// RateLimitsConfig configures the rate limits of the notifications sent over the REST API and the websockets. A limit whose rate is 0 is disabled
+type RateLimitsConfig struct {
+	// Sender limits every sender, identified by its credential or else its address
+	Sender RateLimit `json:"sender"`
+	// Target limits the notifications to every value of the TargetAttributes, e.g. to every customer
+	Target RateLimit `json:"target"`
+	// TargetAttributes are the target attribute keys the Target limit applies to every value of
+	TargetAttributes []string `json:"targetAttributes"`
+}
+
+// RateLimit is a token bucket
+type RateLimit struct {
+	// Rate is the number of notifications per second
+	Rate float64 `json:"rate"`
+	// Burst is the number of notifications that may be sent at once, the rate rounded up if not set
+	Burst int `json:"burst,omitempty"`
+}
+
+// ValidationConfig configures the checks of the notifications received over the REST API and the websockets
+type ValidationConfig struct {
+	// MaxBodySize is the size in bytes of the largest REST API request body, 0 for no limit
+	MaxBodySize int `json:"maxBodySize"`
+	// MaxFrameSize is the size in bytes of the largest websocket message a subscriber may send, 0 for no limit
+	MaxFrameSize int `json:"maxFrameSize"`
+	// TargetKeys are the attribute keys the notifications may select their subscribers by, any key if empty
+	TargetKeys []string `json:"targetKeys,omitempty"`
+	// TargetFormats map attribute keys to a regular expression their target values must match entirely
+	TargetFormats map[string]string `json:"targetFormats,omitempty"`
+}
+
+// SchedulerConfig configures the notifications delivered at a later time, with deliverAt or delayMs. Scheduling is disabled if Size is 0
+type SchedulerConfig struct {
+	// Dir is the directory the scheduled notifications are persisted to, so they survive restarts. They are kept in memory only if not set
+	Dir string `json:"dir,omitempty"`
+	// Size caps the number of pending scheduled notifications, 0 disables scheduling
+	Size int `json:"size"`
+	// MaxDelay is how far in the future a notification may be scheduled, 0 for no limit
+	MaxDelay Duration `json:"maxDelay"`
+}
+
+// SendQueueConfig configures the send queue of every subscriber connection
+type SendQueueConfig struct {
+	// Size is the number of notifications a connection queues before its OverflowPolicy applies
+	Size int `json:"size"`
+	// WriteTimeout is the time a single write may take before the subscriber is disconnected
+	WriteTimeout Duration `json:"writeTimeout"`
+	// OverflowPolicy is "drop-oldest", "drop-newest" or "disconnect"
+	OverflowPolicy string `json:"overflowPolicy"`
+}
+
+// AuthConfig configures the authentication of the subscribers. Subscribers are not authenticated if PolicyFile is not set
+type AuthConfig struct {
+	PolicyFile string `json:"policyFile,omitempty"`
+}
+
+// AdminConfig configures the admin API. The admin API is disabled if Token is not set
+type AdminConfig struct {
+	// Token is the bearer token of the admin API, separate from the credentials of the senders and subscribers
+	Token string `json:"token,omitempty"`
+}
+
+// MetricsConfig configures the metrics
+type MetricsConfig struct {
+	// AttributeKeys are the attribute keys the connection gauges are broken down by
+	AttributeKeys []string `json:"attributeKeys"`
+}
+
+// DefaultConfig returns the configuration used when nothing is configured
+func DefaultConfig() *Config {
+	return &Config{
+		Listeners: ListenersConfig{
+			WebsocketPort: PortWebsocket,
+			RestAPIPort:   PortRestAPI,
+		},
+		Parent: ParentConfig{
+			ServiceDiscoveryPath:    defaultServiceDiscoveryPath,
+			CredentialsPath:         defaultCredentialsPath,
+			ReconnectInitialBackoff: Duration(defaultReconnectInitialBackoff),
+			ReconnectMaxBackoff:     Duration(defaultReconnectMaxBackoff),
+			Mode:                    ParentModeFailover,
+			HealthCheckInterval:     Duration(defaultHealthCheckInterval),
+		},
+		Routing: RoutingConfig{
+			ParentAttributes: []string{notifier.TargetCustomer},
+			Mode:             RoutingModeDownstream,
+			MaxHops:          defaultMaxHops,
+		},
+		Buffer: BufferConfig{
+			Dir:  defaultNotificationBufferDir,
+			TTL:  Duration(defaultNotificationBufferTTL),
+			Size: defaultNotificationBufferSize,
+		},
+		Acks: AcksConfig{
+			Timeout:       Duration(defaultAckTimeout),
+			RetryInterval: Duration(defaultAckRetryInterval),
+			MaxRetries:    defaultAckMaxRetries,
+		},
+		Requests: RequestsConfig{
+			ReplyTimeout: Duration(defaultReplyTimeout),
+		},
+		Dedup: DedupConfig{
+			Size: defaultDedupSize,
+		},
+		RateLimits: RateLimitsConfig{
+			TargetAttributes: []string{notifier.TargetCustomer},
+		},
+		Validation: ValidationConfig{
+			MaxBodySize:  defaultMaxBodySize,
+			MaxFrameSize: defaultMaxFrameSize,
+		},
+		Scheduler: SchedulerConfig{
+			MaxDelay: Duration(defaultSchedulerMaxDelay),
+		},
+		SendQueue: SendQueueConfig{
+			Size:           defaultSendQueueSize,
+			WriteTimeout:   Duration(defaultWriteTimeout),
+			OverflowPolicy: string(websocketactions.OverflowDisconnect),
+		},
+		Metrics: MetricsConfig{
+			AttributeKeys: []string{},
+		},
+		ShutdownTimeout: Duration(defaultShutdownTimeout),
+	}
+}
+
+// LoadConfig loads the configuration. Settings are taken, from the lowest precedence to the highest, from:
+// the defaults, the JSON or YAML file at a given path (skipped if empty), and the environment variables.
+// The parent URL is discovered from the service discovery file if none of them set it
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	cfg.path = path
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config '%s', reason: %s", path, err.Error())
+		}
+		// YAML is a superset of JSON, so both are parsed the same way
+		if err := yaml.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config '%s', reason: %s", path, err.Error())
+		}
+		logger.L().Info("loaded config", helpers.String("path", path))
+	}
+	if cfg.Parent.URL == "" {
+		cfg.Parent.URL = cfg.RootGatewayURL
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if len(cfg.Parent.URLs) > 0 {
+		// the preferred parent is the parent of the settings and logs that expect a single one
+		cfg.Parent.URL = cfg.Parent.URLs[0]
+	}
+	if cfg.Parent.URL == "" {
+		cfg.Parent.URL = discoverParentURL(cfg.Parent.ServiceDiscoveryPath)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config, reason: %s", err.Error())
+	}
+	return cfg, nil
+}
+
+// discoverParentURL reads the parent gateway URL from a service discovery file
+func discoverParentURL(path string) string {
+	services, err := servicediscovery.GetServices(v2.NewServiceDiscoveryFileV2(path))
+	if err != nil {
+		logger.L().Warning(err.Error())
+		return ""
+	}
+	u := services.GetGatewayUrl()
+	logger.L().Info("loaded gw url (service discovery)", helpers.String("url", u))
+	return u
+}
+
+// applyEnv overrides the configuration with the environment variables that are set
+func (cfg *Config) applyEnv() error {
+	var errs []string
+	str := func(name string, target *string) {
+		if v := os.Getenv(name); v != "" {
+			*target = v
+		}
+	}
+	duration := func(name string, target *Duration) {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*target = Duration(d)
+		}
+	}
+	boolean := func(name string, target *bool) {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*target = b
+		}
+	}
+	number := func(name string, target *float64) {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*target = f
+		}
+	}
+	integer := func(name string, target *int) {
+		if v := os.Getenv(name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				return
+			}
+			*target = i
+		}
+	}
+
+	str(GatewayWebsocketPortEnvironmentVariable, &cfg.Listeners.WebsocketPort)
+	str(GatewayRestApiPortEnvironmentVariable, &cfg.Listeners.RestAPIPort)
+	str(TLSCertFileEnvironmentVariable, &cfg.Listeners.TLS.CertFile)
+	str(TLSKeyFileEnvironmentVariable, &cfg.Listeners.TLS.KeyFile)
+	str(TLSClientCAFileEnvironmentVariable, &cfg.Listeners.TLS.ClientCAFile)
+	if v := os.Getenv(TLSClientSubjectAttributesEnvironmentVariable); v != "" {
+		mapping, err := parseSubjectAttributes(v)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", TLSClientSubjectAttributesEnvironmentVariable, err.Error()))
+		} else {
+			cfg.Listeners.TLS.ClientSubjectAttributes = mapping
+		}
+	}
+	if v := os.Getenv(ParentGatewayHostEnvironmentVariable); v != "" {
+		logger.L().Info("loaded gw url from env var", helpers.String("url", v))
+		cfg.Parent.URL = v
+	}
+	str(CredentialsPathEnvironmentVariable, &cfg.Parent.CredentialsPath)
+	duration(ParentReconnectInitialBackoffEnvironmentVariable, &cfg.Parent.ReconnectInitialBackoff)
+	duration(ParentReconnectMaxBackoffEnvironmentVariable, &cfg.Parent.ReconnectMaxBackoff)
+	boolean(ParentMultiplexedEnvironmentVariable, &cfg.Parent.Multiplexed)
+	if v := os.Getenv(ParentURLsEnvironmentVariable); v != "" {
+		cfg.Parent.URLs = splitList(v)
+	}
+	str(ParentModeEnvironmentVariable, &cfg.Parent.Mode)
+	duration(ParentHealthCheckIntervalEnvironmentVariable, &cfg.Parent.HealthCheckInterval)
+	str(RoutingModeEnvironmentVariable, &cfg.Routing.Mode)
+	integer(RoutingMaxHopsEnvironmentVariable, &cfg.Routing.MaxHops)
+	boolean(RoutingStrictEnvironmentVariable, &cfg.Routing.Strict)
+	str(NotificationBufferEnvironmentVariable, &cfg.Buffer.Type)
+	str(NotificationBufferDirEnvironmentVariable, &cfg.Buffer.Dir)
+	duration(NotificationBufferTTLEnvironmentVariable, &cfg.Buffer.TTL)
+	integer(NotificationBufferSizeEnvironmentVariable, &cfg.Buffer.Size)
+	duration(AckTimeoutEnvironmentVariable, &cfg.Acks.Timeout)
+	duration(AckRetryIntervalEnvironmentVariable, &cfg.Acks.RetryInterval)
+	integer(AckMaxRetriesEnvironmentVariable, &cfg.Acks.MaxRetries)
+	str(DeadLetterFileEnvironmentVariable, &cfg.Acks.DeadLetterFile)
+	duration(ReplyTimeoutEnvironmentVariable, &cfg.Requests.ReplyTimeout)
+	duration(DedupWindowEnvironmentVariable, &cfg.Dedup.Window)
+	integer(DedupSizeEnvironmentVariable, &cfg.Dedup.Size)
+	number(RateLimitSenderEnvironmentVariable, &cfg.RateLimits.Sender.Rate)
+	integer(RateLimitSenderBurstEnvironmentVariable, &cfg.RateLimits.Sender.Burst)
+	number(RateLimitTargetEnvironmentVariable, &cfg.RateLimits.Target.Rate)
+	integer(RateLimitTargetBurstEnvironmentVariable, &cfg.RateLimits.Target.Burst)
+	if v := os.Getenv(RateLimitTargetAttributesEnvironmentVariable); v != "" {
+		cfg.RateLimits.TargetAttributes = splitList(v)
+	}
+	integer(MaxBodySizeEnvironmentVariable, &cfg.Validation.MaxBodySize)
+	integer(MaxFrameSizeEnvironmentVariable, &cfg.Validation.MaxFrameSize)
+	if v := os.Getenv(TargetKeysEnvironmentVariable); v != "" {
+		cfg.Validation.TargetKeys = splitList(v)
+	}
+	str(SchedulerDirEnvironmentVariable, &cfg.Scheduler.Dir)
+	integer(SchedulerSizeEnvironmentVariable, &cfg.Scheduler.Size)
+	duration(SchedulerMaxDelayEnvironmentVariable, &cfg.Scheduler.MaxDelay)
+	integer(SendQueueSizeEnvironmentVariable, &cfg.SendQueue.Size)
+	duration(WriteTimeoutEnvironmentVariable, &cfg.SendQueue.WriteTimeout)
+	str(OverflowPolicyEnvironmentVariable, &cfg.SendQueue.OverflowPolicy)
+	str(AuthPolicyEnvironmentVariable, &cfg.Auth.PolicyFile)
+	str(AdminTokenEnvironmentVariable, &cfg.Admin.Token)
+	if v := os.Getenv(MetricsAttributeKeysEnvironmentVariable); v != "" {
+		cfg.Metrics.AttributeKeys = splitList(v)
+	}
+	duration(ShutdownTimeoutEnvironmentVariable, &cfg.ShutdownTimeout)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment variables: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping the empty items
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Validate checks the configuration is usable, and reports all the problems found
+func (cfg *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	validPort := func(port string) bool {
+		p, err := strconv.Atoi(port)
+		return err == nil && p > 0 && p < 65536
+	}
+
+	check(validPort(cfg.Listeners.WebsocketPort), "listeners.websocketPort '%s' is not a valid port", cfg.Listeners.WebsocketPort)
+	check(validPort(cfg.Listeners.RestAPIPort), "listeners.restAPIPort '%s' is not a valid port", cfg.Listeners.RestAPIPort)
+	check(cfg.Listeners.WebsocketPort != cfg.Listeners.RestAPIPort, "listeners.websocketPort and listeners.restAPIPort must differ")
+	tls := cfg.Listeners.TLS
+	check((tls.CertFile == "") == (tls.KeyFile == ""), "listeners.tls requires both certFile and keyFile")
+	check(tls.ClientCAFile == "" || tls.CertFile != "", "listeners.tls.clientCAFile requires certFile and keyFile")
+	check(len(tls.ClientSubjectAttributes) == 0 || tls.ClientCAFile != "", "listeners.tls.clientSubjectAttributes requires clientCAFile")
+	for field := range tls.ClientSubjectAttributes {
+		_, ok := subjectValue(pkix.Name{}, field)
+		check(ok, "listeners.tls.clientSubjectAttributes: unsupported subject field '%s'", field)
+	}
+
+	if cfg.Parent.URL != "" {
+		u, err := url.Parse(cfg.Parent.URL)
+		check(err == nil && u.Host != "", "parent.url '%s' is not a valid URL", cfg.Parent.URL)
+	}
+	seen := map[string]bool{}
+	for _, parentURL := range cfg.Parent.URLs {
+		u, err := url.Parse(parentURL)
+		check(err == nil && u.Host != "", "parent.urls: '%s' is not a valid URL", parentURL)
+		check(!seen[parentURL], "parent.urls: '%s' is listed twice", parentURL)
+		seen[parentURL] = true
+	}
+	check(cfg.Parent.Mode == ParentModeFailover || cfg.Parent.Mode == ParentModeActiveActive, "parent.mode '%s' must be '%s' or '%s'", cfg.Parent.Mode, ParentModeFailover, ParentModeActiveActive)
+	check(cfg.Parent.HealthCheckInterval > 0, "parent.healthCheckInterval must be positive")
+	check(cfg.Parent.ReconnectInitialBackoff > 0, "parent.reconnectInitialBackoff must be positive")
+	check(cfg.Parent.ReconnectMaxBackoff >= cfg.Parent.ReconnectInitialBackoff, "parent.reconnectMaxBackoff must not be shorter than parent.reconnectInitialBackoff")
+	check(len(cfg.Routing.ParentAttributes) > 0, "routing.parentAttributes must not be empty")
+	check(cfg.Routing.Mode == RoutingModeDownstream || cfg.Routing.Mode == RoutingModeBidirectional, "routing.mode '%s' must be '%s' or '%s'", cfg.Routing.Mode, RoutingModeDownstream, RoutingModeBidirectional)
+	check(cfg.Routing.MaxHops > 0, "routing.maxHops must be positive")
+
+	check(cfg.Buffer.Type == "" || cfg.Buffer.Type == NotificationBufferMemory || cfg.Buffer.Type == NotificationBufferDisk, "buffer.type '%s' must be '%s' or '%s'", cfg.Buffer.Type, NotificationBufferMemory, NotificationBufferDisk)
+	check(cfg.Buffer.Type != NotificationBufferDisk || cfg.Buffer.Dir != "", "buffer.dir is required by the disk buffer")
+	check(cfg.Buffer.TTL > 0, "buffer.ttl must be positive")
+	check(cfg.Buffer.Size > 0, "buffer.size must be positive")
+
+	check(cfg.Acks.Timeout > 0, "acks.timeout must be positive")
+	check(cfg.Acks.RetryInterval > 0, "acks.retryInterval must be positive")
+	check(cfg.Acks.MaxRetries >= 0, "acks.maxRetries must not be negative")
+	check(cfg.Requests.ReplyTimeout > 0, "requests.replyTimeout must be positive")
+	check(cfg.Dedup.Window >= 0, "dedup.window must not be negative")
+	check(cfg.Dedup.Size > 0, "dedup.size must be positive")
+	for name, limit := range map[string]RateLimit{"sender": cfg.RateLimits.Sender, "target": cfg.RateLimits.Target} {
+		check(limit.Rate >= 0, "rateLimits.%s.rate must not be negative", name)
+		check(limit.Burst >= 0, "rateLimits.%s.burst must not be negative", name)
+	}
+	check(cfg.RateLimits.Target.Rate == 0 || len(cfg.RateLimits.TargetAttributes) > 0, "rateLimits.target requires rateLimits.targetAttributes")
+	check(cfg.Validation.MaxBodySize >= 0, "validation.maxBodySize must not be negative")
+	check(cfg.Validation.MaxFrameSize >= 0, "validation.maxFrameSize must not be negative")
+	if _, err := NewEnvelopeValidator(cfg.Validation.TargetKeys, cfg.Validation.TargetFormats); err != nil {
+		check(false, "validation.targetFormats: %s", err.Error())
+	}
+	check(cfg.Scheduler.Size >= 0, "scheduler.size must not be negative")
+	check(cfg.Scheduler.MaxDelay >= 0, "scheduler.maxDelay must not be negative")
+
+	check(cfg.SendQueue.Size > 0, "sendQueue.size must be positive")
+	check(cfg.SendQueue.WriteTimeout > 0, "sendQueue.writeTimeout must be positive")
+	switch websocketactions.OverflowPolicy(cfg.SendQueue.OverflowPolicy) {
+	case websocketactions.OverflowDropOldest, websocketactions.OverflowDropNewest, websocketactions.OverflowDisconnect:
+	default:
+		check(false, "sendQueue.overflowPolicy '%s' must be '%s', '%s' or '%s'", cfg.SendQueue.OverflowPolicy, websocketactions.OverflowDropOldest, websocketactions.OverflowDropNewest, websocketactions.OverflowDisconnect)
+	}
+
+	check(cfg.ShutdownTimeout > 0, "shutdownTimeout must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unsetParentURLMock makes sure a PARENT_URL or PARENT_URLS set on the host does not override the tested configuration
+func unsetParentURLMock(t *testing.T) {
+	t.Setenv(ParentGatewayHostEnvironmentVariable, "")
+	t.Setenv(ParentURLsEnvironmentVariable, "")
+}
+
+func writeConfigMock(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	unsetParentURLMock(t)
+	path := writeConfigMock(t, "gateway.yaml", `
+listeners:
+  websocketPort: "9001"
+parent:
+  url: wss://parent:8001/v1/waitfornotification
+  reconnectMaxBackoff: 30s
+routing:
+  parentAttributes: [customerGUID, clusterName]
+buffer:
+  type: memory
+  size: 10
+acks:
+  maxRetries: 0
+`)
+	cfg, err := LoadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "9001", cfg.Listeners.WebsocketPort)
+	assert.Equal(t, PortRestAPI, cfg.Listeners.RestAPIPort, "unset settings keep their default")
+	assert.Equal(t, "wss://parent:8001/v1/waitfornotification", cfg.Parent.URL)
+	assert.Equal(t, Duration(30*time.Second), cfg.Parent.ReconnectMaxBackoff)
+	assert.Equal(t, Duration(time.Second), cfg.Parent.ReconnectInitialBackoff)
+	assert.Equal(t, []string{"customerGUID", "clusterName"}, cfg.Routing.ParentAttributes)
+	assert.Equal(t, NotificationBufferMemory, cfg.Buffer.Type)
+	assert.Equal(t, 10, cfg.Buffer.Size)
+	assert.Equal(t, 0, cfg.Acks.MaxRetries)
+}
+
+func TestLoadConfigClusterData(t *testing.T) {
+	unsetParentURLMock(t)
+	path := writeConfigMock(t, "clusterData.json", `{
+		"gatewayWebsocketURL": "127.0.0.1:8001",
+		"rootGatewayURL": "wss://ens.example.com/v1/waitfornotification",
+		"clusterName": "cluster"
+	}`)
+	cfg, err := LoadConfig(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "wss://ens.example.com/v1/waitfornotification", cfg.Parent.URL, "the parent URL of the shared cluster config is used")
+	}
+}
+
+func TestLoadConfigEnvironmentOverrides(t *testing.T) {
+	path := writeConfigMock(t, "gateway.json", `{"listeners": {"restAPIPort": "9002"}, "parent": {"url": "wss://file"}, "shutdownTimeout": "10s"}`)
+	t.Setenv(GatewayRestApiPortEnvironmentVariable, "9102")
+	t.Setenv(ParentGatewayHostEnvironmentVariable, "wss://env")
+	t.Setenv(MetricsAttributeKeysEnvironmentVariable, "customerGUID, cluster")
+	t.Setenv(AckTimeoutEnvironmentVariable, "3s")
+	t.Setenv(AdminTokenEnvironmentVariable, "secret")
+	t.Setenv(OverflowPolicyEnvironmentVariable, "drop-oldest")
+	t.Setenv(ParentMultiplexedEnvironmentVariable, "true")
+	t.Setenv(DedupWindowEnvironmentVariable, "1m")
+	t.Setenv(RateLimitSenderEnvironmentVariable, "2.5")
+	t.Setenv(RateLimitTargetAttributesEnvironmentVariable, "customerGUID,clusterName")
+	t.Setenv(MaxFrameSizeEnvironmentVariable, "0")
+	t.Setenv(RoutingStrictEnvironmentVariable, "true")
+	t.Setenv(SchedulerMaxDelayEnvironmentVariable, "24h")
+
+	cfg, err := LoadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "9102", cfg.Listeners.RestAPIPort, "environment variables take precedence over the file")
+	assert.Equal(t, "wss://env", cfg.Parent.URL)
+	assert.Equal(t, []string{"customerGUID", "cluster"}, cfg.Metrics.AttributeKeys)
+	assert.Equal(t, Duration(3*time.Second), cfg.Acks.Timeout)
+	assert.Equal(t, "secret", cfg.Admin.Token)
+	assert.Equal(t, "drop-oldest", cfg.SendQueue.OverflowPolicy)
+	assert.True(t, cfg.Parent.Multiplexed)
+	assert.Equal(t, Duration(time.Minute), cfg.Dedup.Window)
+	assert.Equal(t, RateLimit{Rate: 2.5}, cfg.RateLimits.Sender)
+	assert.Equal(t, []string{"customerGUID", "clusterName"}, cfg.RateLimits.TargetAttributes)
+	assert.Equal(t, 0, cfg.Validation.MaxFrameSize, "0 disables the limit")
+	assert.Equal(t, defaultMaxBodySize, cfg.Validation.MaxBodySize)
+	assert.True(t, cfg.Routing.Strict)
+	assert.Equal(t, Duration(24*time.Hour), cfg.Scheduler.MaxDelay)
+	assert.Equal(t, 0, cfg.Scheduler.Size, "scheduling is opt-in")
+	assert.Equal(t, Duration(10*time.Second), cfg.ShutdownTimeout, "the file takes precedence over the defaults")
+
+	t.Setenv(AckTimeoutEnvironmentVariable, "soon")
+	_, err = LoadConfig(path)
+	assert.Error(t, err, "malformed environment variables are reported")
+}
+
+func TestLoadConfigParentURLs(t *testing.T) {
+	unsetParentURLMock(t)
+	path := writeConfigMock(t, "gateway.yaml", `
+parent:
+  url: wss://single
+  urls: [wss://eu, wss://us]
+  mode: active-active
+`)
+	cfg, err := LoadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"wss://eu", "wss://us"}, cfg.Parent.parentURLs())
+	assert.Equal(t, "wss://eu", cfg.Parent.URL, "the list takes precedence")
+	assert.Equal(t, ParentModeActiveActive, cfg.Parent.Mode)
+
+	t.Setenv(ParentURLsEnvironmentVariable, "wss://us, wss://ap")
+	t.Setenv(ParentModeEnvironmentVariable, ParentModeFailover)
+	cfg, err = LoadConfig(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"wss://us", "wss://ap"}, cfg.Parent.parentURLs())
+		assert.Equal(t, ParentModeFailover, cfg.Parent.Mode)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	unsetParentURLMock(t)
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+
+	_, err = LoadConfig(writeConfigMock(t, "gateway.yaml", "acks:\n  timeout: 10\n"))
+	assert.Error(t, err, "durations are strings")
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr bool
+	}{
+		{name: "default", modify: func(cfg *Config) {}},
+		{name: "invalid port", modify: func(cfg *Config) { cfg.Listeners.WebsocketPort = "http" }, wantErr: true},
+		{name: "same ports", modify: func(cfg *Config) { cfg.Listeners.RestAPIPort = cfg.Listeners.WebsocketPort }, wantErr: true},
+		{name: "certificate without key", modify: func(cfg *Config) { cfg.Listeners.TLS.CertFile = "tls.crt" }, wantErr: true},
+		{
+			name: "subject attributes without client CA",
+			modify: func(cfg *Config) {
+				cfg.Listeners.TLS = TLSConfig{CertFile: "tls.crt", KeyFile: "tls.key", ClientSubjectAttributes: map[string]string{"CN": "cluster"}}
+			},
+			wantErr: true,
+		},
+		{name: "invalid parent URL", modify: func(cfg *Config) { cfg.Parent.URL = "::" }, wantErr: true},
+		{name: "parent URLs", modify: func(cfg *Config) { cfg.Parent.URLs = []string{"wss://eu", "wss://us"} }},
+		{name: "invalid parent URLs", modify: func(cfg *Config) { cfg.Parent.URLs = []string{"wss://eu", "::"} }, wantErr: true},
+		{name: "duplicate parent URLs", modify: func(cfg *Config) { cfg.Parent.URLs = []string{"wss://eu", "wss://eu"} }, wantErr: true},
+		{name: "unknown parent mode", modify: func(cfg *Config) { cfg.Parent.Mode = "round-robin" }, wantErr: true},
+		{name: "max backoff below initial", modify: func(cfg *Config) { cfg.Parent.ReconnectMaxBackoff = Duration(time.Millisecond) }, wantErr: true},
+		{name: "unknown buffer", modify: func(cfg *Config) { cfg.Buffer.Type = "redis" }, wantErr: true},
+		{name: "empty buffer", modify: func(cfg *Config) { cfg.Buffer.Size = 0 }, wantErr: true},
+		{name: "negative retries", modify: func(cfg *Config) { cfg.Acks.MaxRetries = -1 }, wantErr: true},
+		{name: "no reply timeout", modify: func(cfg *Config) { cfg.Requests.ReplyTimeout = 0 }, wantErr: true},
+		{name: "empty send queue", modify: func(cfg *Config) { cfg.SendQueue.Size = 0 }, wantErr: true},
+		{name: "unknown overflow policy", modify: func(cfg *Config) { cfg.SendQueue.OverflowPolicy = "block" }, wantErr: true},
+		{name: "negative dedup window", modify: func(cfg *Config) { cfg.Dedup.Window = Duration(-time.Second) }, wantErr: true},
+		{name: "empty dedup cache", modify: func(cfg *Config) { cfg.Dedup.Window, cfg.Dedup.Size = Duration(time.Minute), 0 }, wantErr: true},
+		{name: "rate limits", modify: func(cfg *Config) {
+			cfg.RateLimits.Sender, cfg.RateLimits.Target = RateLimit{Rate: 10}, RateLimit{Rate: 0.5, Burst: 5}
+		}},
+		{name: "negative rate", modify: func(cfg *Config) { cfg.RateLimits.Sender.Rate = -1 }, wantErr: true},
+		{name: "target rate without attributes", modify: func(cfg *Config) { cfg.RateLimits.Target.Rate, cfg.RateLimits.TargetAttributes = 1, nil }, wantErr: true},
+		{name: "negative body size", modify: func(cfg *Config) { cfg.Validation.MaxBodySize = -1 }, wantErr: true},
+		{name: "target formats", modify: func(cfg *Config) { cfg.Validation.TargetFormats = map[string]string{"customerGUID": "[0-9a-f-]{36}"} }},
+		{name: "invalid target format", modify: func(cfg *Config) { cfg.Validation.TargetFormats = map[string]string{"customerGUID": "[0-9"} }, wantErr: true},
+		{name: "scheduling disabled", modify: func(cfg *Config) { cfg.Scheduler.Size = 0 }},
+		{name: "negative scheduler delay", modify: func(cfg *Config) { cfg.Scheduler.MaxDelay = -1 }, wantErr: true},
+		{name: "unknown routing mode", modify: func(cfg *Config) { cfg.Routing.Mode = "upstream" }, wantErr: true},
+		{name: "no hops", modify: func(cfg *Config) { cfg.Routing.MaxHops = 0 }, wantErr: true},
+		{name: "no parent attributes", modify: func(cfg *Config) { cfg.Routing.ParentAttributes = nil }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			if tt.wantErr {
+				assert.Error(t, cfg.Validate())
+			} else {
+				assert.NoError(t, cfg.Validate())
+			}
+		})
+	}
+}
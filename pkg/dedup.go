@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+const (
+	defaultDedupSize = 10000
+	// DedupReasonConnection a subscriber already received a notification with the same message ID
+	DedupReasonConnection = "connection"
+	// DedupReasonParent another parent gateway already sent the same notification, in active-active mode
+	DedupReasonParent = "parent"
+)
+
+// DedupCache remembers keys for a time window, up to a number of keys, the oldest keys are forgotten first
+type DedupCache struct {
+	window time.Duration
+	size   int
+	mutex  sync.Mutex
+	// latest are the latest entries of the remembered keys
+	latest map[string]dedupEntry
+	// entries lists the entries, oldest first
+	entries []dedupEntry
+}
+
+// dedupEntry is a key remembered by a DedupCache, with the value it was remembered with
+type dedupEntry struct {
+	key   string
+	value string
+	at    time.Time
+}
+
+// NewDedupCache creates a new DedupCache remembering up to a given number of keys for a given window
+func NewDedupCache(window time.Duration, size int) *DedupCache {
+	return &DedupCache{
+		window: window,
+		size:   size,
+		latest: map[string]dedupEntry{},
+	}
+}
+
+// Remember remembers a key with a given value. Returns the value the key was remembered with before, if it was remembered within the window
+func (c *DedupCache) Remember(key, value string) (string, bool) {
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.entries) > 0 && (now.Sub(c.entries[0].at) > c.window || len(c.entries) >= c.size) {
+		oldest := c.entries[0]
+		c.entries = c.entries[1:]
+		if c.latest[oldest.key] == oldest {
+			delete(c.latest, oldest.key)
+		}
+	}
+	previous, ok := c.latest[key]
+	entry := dedupEntry{key: key, value: value, at: now}
+	c.latest[key] = entry
+	c.entries = append(c.entries, entry)
+	return previous.value, ok
+}
+
+// Forget forgets a key, so it is not taken for a duplicate when remembered again
+func (c *DedupCache) Forget(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.latest, key)
+}
+
+// Len returns the number of remembered keys
+func (c *DedupCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.latest)
+}
+
+// newDedupCache creates the DedupCache of the deliveries to the subscribers, nil if deduplication is disabled
+func newDedupCache(cfg DedupConfig) *DedupCache {
+	if cfg.Window <= 0 {
+		return nil
+	}
+	return NewDedupCache(time.Duration(cfg.Window), cfg.Size)
+}
+
+// withoutDuplicates returns the connections that did not receive a notification with a given message ID within the dedup window,
+// and records the others as duplicates in the result. All the connections are returned when deduplication is disabled
+func (nh *Gateway) withoutDuplicates(messageID string, connections []*websocketactions.Connection, result *SendResult) []*websocketactions.Connection {
+	if nh.dedup == nil || messageID == "" {
+		return connections
+	}
+	fresh := make([]*websocketactions.Connection, 0, len(connections))
+	for _, conn := range connections {
+		if _, seen := nh.dedup.Remember(deliveryKey(messageID, conn), ""); seen {
+			result.add(conn, DeliveryStatusDuplicate, nil)
+			result.Duplicates++
+			nh.metrics.notificationsDeduplicated.WithLabelValues(DedupReasonConnection).Inc()
+			continue
+		}
+		fresh = append(fresh, conn)
+	}
+	return fresh
+}
+
+// forgetDeliveries forgets a notification with a given message ID was delivered to given connections, e.g. when their writes failed or were dropped,
+// so the notification is not taken for a duplicate when sent again
+func (nh *Gateway) forgetDeliveries(messageID string, connections ...*websocketactions.Connection) {
+	if nh.dedup == nil || messageID == "" {
+		return
+	}
+	for _, conn := range connections {
+		nh.dedup.Forget(deliveryKey(messageID, conn))
+	}
+}
+
+// deliveryKey returns the key remembering a notification with a given message ID was delivered to a connection
+func deliveryKey(messageID string, conn *websocketactions.Connection) string {
+	return fmt.Sprintf("%s/%d", messageID, conn.ID)
+}
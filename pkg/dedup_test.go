@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupCache(t *testing.T) {
+	c := NewDedupCache(50*time.Millisecond, 3)
+	_, seen := c.Remember("one", "a")
+	assert.False(t, seen)
+	previous, seen := c.Remember("one", "b")
+	assert.True(t, seen)
+	assert.Equal(t, "a", previous)
+	previous, _ = c.Remember("one", "b")
+	assert.Equal(t, "b", previous, "the latest value is remembered")
+
+	time.Sleep(60 * time.Millisecond)
+	_, seen = c.Remember("one", "a")
+	assert.False(t, seen, "forgotten after the window")
+
+	for _, key := range []string{"two", "three", "four"} {
+		c.Remember(key, "a")
+	}
+	_, seen = c.Remember("one", "a")
+	assert.False(t, seen, "forgotten once more keys were remembered")
+	assert.LessOrEqual(t, c.Len(), 3)
+}
+
+func TestSendNotificationDedup(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.dedup = NewDedupCache(time.Minute, 100)
+	_, first := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "a"}, &websocket.Conn{}, nil)
+
+	result, err := ns.SendNotification(NotificationMock(map[string]string{"customer": "test"}, true), []byte(`{"target":{"customer":"test"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Duplicates)
+	messageID := result.NotificationID
+
+	// the same notification reaches the gateway again, e.g. through another path, and a new subscriber connected meanwhile
+	_, second := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "b"}, &websocket.Conn{}, nil)
+	n := NotificationMock(map[string]string{"customer": "test"}, true)
+	n.MessageID = messageID
+	result, err = ns.SendNotification(n, []byte(`{"target":{"customer":"test"},"messageID":"`+messageID+`"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, messageID, result.NotificationID, "the message ID is honored")
+	assert.Equal(t, 1, result.Duplicates)
+	statuses := map[int]DeliveryStatus{}
+	for _, delivery := range result.Connections {
+		statuses[delivery.ID] = delivery.Status
+	}
+	assert.Equal(t, map[int]DeliveryStatus{first: DeliveryStatusDuplicate, second: DeliveryStatusSent}, statuses)
+	assert.Contains(t, scrapeMock(t, ns.metrics), `gateway_notifications_deduplicated_total{reason="connection"} 1`)
+
+	// once every subscriber received it, a repeat is neither buffered nor forwarded
+	ns.notificationBuffer = NewMemoryNotificationBuffer(time.Minute, 10)
+	w := httptest.NewRecorder()
+	ns.RestAPINotificationHandler(w, httptest.NewRequest(http.MethodPost, "/v1/sendnotification", bytes.NewBufferString(`{"target":{"customer":"test"},"messageID":"`+messageID+`","sendSynchronicity":true}`)))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	result = &SendResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+	assert.Equal(t, 2, result.Duplicates)
+	assert.False(t, result.Queued)
+}
+
+// blockingRecorder is a stream response writer whose writes block until released
+type blockingRecorder struct {
+	*httptest.ResponseRecorder
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRecorder) Write(b []byte) (int, error) {
+	select {
+	case r.entered <- struct{}{}:
+	default:
+	}
+	<-r.release
+	return r.ResponseRecorder.Write(b)
+}
+
+func TestSendNotificationDedupDropped(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.dedup = NewDedupCache(time.Minute, 100)
+	ns.config.SendQueue = SendQueueConfig{Size: 1, WriteTimeout: Duration(time.Minute), OverflowPolicy: string(websocketactions.OverflowDropNewest)}
+	w := &blockingRecorder{ResponseRecorder: httptest.NewRecorder(), entered: make(chan struct{}, 1), release: make(chan struct{})}
+	stream, _ := websocketactions.NewSSEStream(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ns.incomingConnections.AppendStream(ATTRIBUTES_MOCK, stream, ns.setupIncomingConnection)
+
+	send := func(messageID string, synchronous bool) *SendResult {
+		n := NotificationMock(ATTRIBUTES_MOCK, synchronous)
+		n.MessageID = messageID
+		result, _ := ns.SendNotification(n, []byte(`{"messageID":"`+messageID+`"}`))
+		return result
+	}
+	// the writer is busy and the queue is full, the next notification is dropped
+	send("a", false)
+	<-w.entered
+	send("b", false)
+	result := send("m", true)
+	if assert.Equal(t, 1, len(result.Connections)) {
+		assert.Equal(t, DeliveryStatusFailed, result.Connections[0].Status)
+	}
+
+	close(w.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, ns.pendingWrites.wait(ctx))
+	result = send("m", true)
+	assert.Equal(t, 0, result.Duplicates, "a dropped notification is delivered when sent again")
+	if assert.Equal(t, 1, len(result.Connections)) {
+		assert.Equal(t, DeliveryStatusSent, result.Connections[0].Status)
+	}
+	assert.Contains(t, w.Body.String(), `"messageID":"m"`)
+}
@@ -5,5 +5,91 @@ const (
 	GatewayWebsocketPortEnvironmentVariable = "WEBSOCKET_PORT"
 	GatewayRestApiPortEnvironmentVariable   = "HTTP_PORT"
 	ParentGatewayHostEnvironmentVariable    = "PARENT_URL"
-	ReleaseBuildTagEnvironmentVariable      = "RELEASE"
+	// CredentialsPathEnvironmentVariable is the credentials file the access key to the parent gateway is read from
+	CredentialsPathEnvironmentVariable = "CREDENTIALS_PATH"
+	ReleaseBuildTagEnvironmentVariable = "RELEASE"
+	// ParentReconnectInitialBackoffEnvironmentVariable is the delay before the first reconnection to the parent (Go duration, default 1s)
+	ParentReconnectInitialBackoffEnvironmentVariable = "PARENT_RECONNECT_INITIAL_BACKOFF"
+	// ParentReconnectMaxBackoffEnvironmentVariable caps the delay between reconnections to the parent (Go duration, default 2m)
+	ParentReconnectMaxBackoffEnvironmentVariable = "PARENT_RECONNECT_MAX_BACKOFF"
+	// ParentMultiplexedEnvironmentVariable advertises the subscriptions of the local subscribers to the parent, so it only routes what they need (default false)
+	ParentMultiplexedEnvironmentVariable = "PARENT_MULTIPLEXED"
+	// ParentURLsEnvironmentVariable is a comma separated list of parent gateway URLs in order of preference, taking precedence over PARENT_URL
+	ParentURLsEnvironmentVariable = "PARENT_URLS"
+	// ParentModeEnvironmentVariable is either "failover" (default), linking to the first reachable parent, or "active-active", linking to all of them
+	ParentModeEnvironmentVariable = "PARENT_MODE"
+	// ParentHealthCheckIntervalEnvironmentVariable is how often a link that failed over probes the parents it prefers (Go duration, default 30s)
+	ParentHealthCheckIntervalEnvironmentVariable = "PARENT_HEALTH_CHECK_INTERVAL"
+	// RoutingModeEnvironmentVariable is either "downstream" (default) or "bidirectional", which forwards the notifications without local subscribers to the parent
+	RoutingModeEnvironmentVariable = "ROUTING_MODE"
+	// RoutingMaxHopsEnvironmentVariable is the number of gateways a notification may be forwarded to the parent by (default 8)
+	RoutingMaxHopsEnvironmentVariable = "ROUTING_MAX_HOPS"
+	// RoutingStrictEnvironmentVariable responds 404 to the REST API senders of notifications nobody subscribed to (default false)
+	RoutingStrictEnvironmentVariable = "ROUTING_STRICT"
+	// NotificationBufferEnvironmentVariable enables buffering notifications for routes without subscribers: "memory" or "disk"
+	NotificationBufferEnvironmentVariable = "NOTIFICATION_BUFFER"
+	// NotificationBufferDirEnvironmentVariable is the directory of the "disk" notification buffer
+	NotificationBufferDirEnvironmentVariable = "NOTIFICATION_BUFFER_DIR"
+	// NotificationBufferTTLEnvironmentVariable is how long a notification is buffered (Go duration, default 5m)
+	NotificationBufferTTLEnvironmentVariable = "NOTIFICATION_BUFFER_TTL"
+	// NotificationBufferSizeEnvironmentVariable is the maximal number of notifications buffered per route (default 100)
+	NotificationBufferSizeEnvironmentVariable = "NOTIFICATION_BUFFER_SIZE"
+	// AckTimeoutEnvironmentVariable is how long synchronous senders wait for acknowledgements by default (Go duration, default 10s)
+	AckTimeoutEnvironmentVariable = "ACK_TIMEOUT"
+	// AckRetryIntervalEnvironmentVariable is the time to wait for an acknowledgement before sending again (Go duration, default 5s)
+	AckRetryIntervalEnvironmentVariable = "ACK_RETRY_INTERVAL"
+	// AckMaxRetriesEnvironmentVariable is the number of times an unacknowledged notification is sent again (default 3)
+	AckMaxRetriesEnvironmentVariable = "ACK_MAX_RETRIES"
+	// DeadLetterFileEnvironmentVariable is a file the unacknowledged notifications are appended to, they are only logged if not set
+	DeadLetterFileEnvironmentVariable = "DEAD_LETTER_FILE"
+	// ReplyTimeoutEnvironmentVariable is how long to wait for the replies to a request by default (Go duration, default 10s)
+	ReplyTimeoutEnvironmentVariable = "REPLY_TIMEOUT"
+	// DedupWindowEnvironmentVariable is how long the message IDs of the notifications are remembered to drop the repeats (Go duration, default 0, disabled)
+	DedupWindowEnvironmentVariable = "DEDUP_WINDOW"
+	// DedupSizeEnvironmentVariable caps the number of remembered deliveries (default 10000)
+	DedupSizeEnvironmentVariable = "DEDUP_SIZE"
+	// RateLimitSenderEnvironmentVariable is the number of notifications per second every sender may send (default 0, unlimited)
+	RateLimitSenderEnvironmentVariable = "RATE_LIMIT_SENDER"
+	// RateLimitSenderBurstEnvironmentVariable is the number of notifications a sender may send at once (default the sender rate rounded up)
+	RateLimitSenderBurstEnvironmentVariable = "RATE_LIMIT_SENDER_BURST"
+	// RateLimitTargetEnvironmentVariable is the number of notifications per second that may be sent to every value of the target attributes (default 0, unlimited)
+	RateLimitTargetEnvironmentVariable = "RATE_LIMIT_TARGET"
+	// RateLimitTargetBurstEnvironmentVariable is the number of notifications that may be sent at once to a target attribute value (default the target rate rounded up)
+	RateLimitTargetBurstEnvironmentVariable = "RATE_LIMIT_TARGET_BURST"
+	// RateLimitTargetAttributesEnvironmentVariable is a comma separated list of the target attribute keys the target rate limit applies to (default customerGUID)
+	RateLimitTargetAttributesEnvironmentVariable = "RATE_LIMIT_TARGET_ATTRIBUTES"
+	// MaxBodySizeEnvironmentVariable is the size in bytes of the largest REST API request body, 0 for no limit (default 4MiB)
+	MaxBodySizeEnvironmentVariable = "MAX_BODY_SIZE"
+	// MaxFrameSizeEnvironmentVariable is the size in bytes of the largest websocket message a subscriber may send, 0 for no limit (default 4MiB)
+	MaxFrameSizeEnvironmentVariable = "MAX_FRAME_SIZE"
+	// TargetKeysEnvironmentVariable is a comma separated list of the attribute keys the notifications may select their subscribers by, any key if not set
+	TargetKeysEnvironmentVariable = "TARGET_KEYS"
+	// SchedulerDirEnvironmentVariable is the directory the scheduled notifications are persisted to, they are kept in memory only if not set
+	SchedulerDirEnvironmentVariable = "SCHEDULER_DIR"
+	// SchedulerSizeEnvironmentVariable caps the number of pending scheduled notifications, 0 disables scheduling (default 1000)
+	SchedulerSizeEnvironmentVariable = "SCHEDULER_SIZE"
+	// SchedulerMaxDelayEnvironmentVariable is how far in the future a notification may be scheduled, 0 for no limit (Go duration, default 168h)
+	SchedulerMaxDelayEnvironmentVariable = "SCHEDULER_MAX_DELAY"
+	// SendQueueSizeEnvironmentVariable is the number of notifications a subscriber connection queues before the overflow policy applies (default 256)
+	SendQueueSizeEnvironmentVariable = "SEND_QUEUE_SIZE"
+	// WriteTimeoutEnvironmentVariable is the time a single write to a subscriber may take before it is disconnected (Go duration, default 10s)
+	WriteTimeoutEnvironmentVariable = "WRITE_TIMEOUT"
+	// OverflowPolicyEnvironmentVariable is what happens when the send queue of a subscriber is full: "drop-oldest", "drop-newest" or "disconnect" (default)
+	OverflowPolicyEnvironmentVariable = "OVERFLOW_POLICY"
+	// AuthPolicyEnvironmentVariable is a JSON auth policy file. Subscribers are not authenticated if not set
+	AuthPolicyEnvironmentVariable = "AUTH_POLICY"
+	// AdminTokenEnvironmentVariable is the bearer token of the admin API. The admin API is disabled if not set
+	AdminTokenEnvironmentVariable = "ADMIN_TOKEN"
+	// TLSCertFileEnvironmentVariable is the PEM certificate both listeners serve TLS with. TLS is disabled if not set
+	TLSCertFileEnvironmentVariable = "TLS_CERT_FILE"
+	// TLSKeyFileEnvironmentVariable is the PEM private key of the TLS certificate
+	TLSKeyFileEnvironmentVariable = "TLS_KEY_FILE"
+	// TLSClientCAFileEnvironmentVariable is a PEM CA bundle. When set, clients must present a certificate it signed (mTLS)
+	TLSClientCAFileEnvironmentVariable = "TLS_CLIENT_CA_FILE"
+	// TLSClientSubjectAttributesEnvironmentVariable maps client certificate subject fields to connection attributes, e.g. "CN=cluster,O=customerGUID"
+	TLSClientSubjectAttributesEnvironmentVariable = "TLS_CLIENT_SUBJECT_ATTRIBUTES"
+	// MetricsAttributeKeysEnvironmentVariable is a comma separated list of attribute keys the connection gauges are broken down by
+	MetricsAttributeKeysEnvironmentVariable = "METRICS_ATTRIBUTE_KEYS"
+	// ShutdownTimeoutEnvironmentVariable is the time a graceful shutdown may take (Go duration, default 25s)
+	ShutdownTimeoutEnvironmentVariable = "SHUTDOWN_TIMEOUT"
 )
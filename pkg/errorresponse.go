@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID of a REST API request. The ID of the sender is kept, one is generated if it did not set any
+const RequestIDHeader = "X-Request-ID"
+
+// Error codes of the REST API, stable identifiers of the errors
+const (
+	// ErrorCodeInvalidRequest the request is malformed
+	ErrorCodeInvalidRequest = "invalid_request"
+	// ErrorCodeInvalidNotification the notification is malformed, or breaks the configured attribute keys and value formats
+	ErrorCodeInvalidNotification = "invalid_notification"
+	// ErrorCodeBodyTooLarge the request body exceeds the maximal body size
+	ErrorCodeBodyTooLarge = "body_too_large"
+	// ErrorCodeRateLimited the sender, or a target attribute value, exceeded its rate limit
+	ErrorCodeRateLimited = "rate_limited"
+	// ErrorCodeNoSubscribers nobody subscribed to the target, in strict mode
+	ErrorCodeNoSubscribers = "no_subscribers"
+	// ErrorCodeRequestPending a request with the same correlation ID is waiting for its replies
+	ErrorCodeRequestPending = "request_pending"
+	// ErrorCodeDeliveryFailed the notification could not be written to some of the connections
+	ErrorCodeDeliveryFailed = "delivery_failed"
+	// ErrorCodeSendFailed the notification could not be sent, e.g. buffering it failed
+	ErrorCodeSendFailed = "send_failed"
+	// ErrorCodeSchedulerFull the scheduler holds as many notifications as it may
+	ErrorCodeSchedulerFull = "scheduler_full"
+	// ErrorCodeAlreadyScheduled a notification with the same message ID is scheduled
+	ErrorCodeAlreadyScheduled = "already_scheduled"
+	// ErrorCodeShuttingDown the gateway is draining its connections
+	ErrorCodeShuttingDown = "shutting_down"
+	// ErrorCodeMethodNotAllowed the method is not supported by the path
+	ErrorCodeMethodNotAllowed = "method_not_allowed"
+	// ErrorCodeNotFound there is no such resource
+	ErrorCodeNotFound = "not_found"
+	// ErrorCodeUnauthorized the credentials are missing or invalid
+	ErrorCodeUnauthorized = "unauthorized"
+	// ErrorCodeForbidden the credentials do not allow subscribing to the attributes
+	ErrorCodeForbidden = "forbidden"
+	// ErrorCodeStreamingUnsupported the connection cannot stream Server-Sent Events
+	ErrorCodeStreamingUnsupported = "streaming_unsupported"
+)
+
+// ErrorResponse is the body of a failed REST API request
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID identifies the request in the logs, it is sent back in the RequestIDHeader as well
+	RequestID string `json:"requestID"`
+	// NotificationID is the ID of a notification that was routed
+	NotificationID string `json:"notificationID,omitempty"`
+	// Violations are the problems found in an invalid notification
+	Violations []Violation `json:"violations,omitempty"`
+	// Failures are the connections the notification could not be delivered to
+	Failures []ConnectionDelivery `json:"failures,omitempty"`
+}
+
+// restError is a failure of a notification received over the REST API
+type restError struct {
+	status int
+	// retryAfter is the time a rate limited sender should wait before sending again
+	retryAfter time.Duration
+	response   *ErrorResponse
+}
+
+// requestID returns the ID of a REST API request, and sends it back in the response headers
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		id = uuid.NewString()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// writeError responds to a REST API request with a given status and an ErrorResponse
+func writeError(w http.ResponseWriter, r *http.Request, status int, e *ErrorResponse) {
+	if e.RequestID == "" {
+		if e.RequestID = w.Header().Get(RequestIDHeader); e.RequestID == "" {
+			e.RequestID = requestID(w, r)
+		}
+	}
+	body, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// unrouted reports whether nobody subscribed to the target of a notification, which was then neither buffered, forwarded nor scheduled
+func (sr *SendResult) unrouted() bool {
+	return len(sr.Connections) == 0 && !sr.Queued && !sr.Forwarded && sr.DeliverAt == nil
+}
+
+// failures returns the deliveries of a result that failed
+func (sr *SendResult) failures() []ConnectionDelivery {
+	failures := []ConnectionDelivery{}
+	for _, delivery := range sr.Connections {
+		if delivery.Status == DeliveryStatusFailed {
+			failures = append(failures, delivery)
+		}
+	}
+	return failures
+}
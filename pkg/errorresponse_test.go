@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingWritesMock fails to write every notification
+type failingWritesMock struct {
+	websocketactions.WebsocketActionsMock
+}
+
+// WritePreparedMessage -
+func (fw *failingWritesMock) WritePreparedMessage(conn *websocketactions.Connection, preparedMessage *websocketactions.PreparedMessage) error {
+	return fmt.Errorf("broken pipe")
+}
+
+// sendRequestMock sends a notification to the REST API of a given gateway, and parses the error response if it failed
+func sendRequestMock(t *testing.T, ns *Gateway, method, body string) (*httptest.ResponseRecorder, *ErrorResponse) {
+	r := httptest.NewRequest(method, "/v1/sendnotification", strings.NewReader(body))
+	r.Header.Set(RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	ns.RestAPINotificationHandler(w, r)
+	assert.Equal(t, "req-1", w.Header().Get(RequestIDHeader), "the request ID of the sender is kept")
+	if w.Code == http.StatusOK {
+		return w, nil
+	}
+	e := &ErrorResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), e), w.Body.String())
+	assert.Equal(t, "req-1", e.RequestID)
+	return w, e
+}
+
+func TestRestAPIErrorResponses(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	body := `{"target":{"customer":"test"},"sendSynchronicity":true}`
+
+	w, e := sendRequestMock(t, ns, http.MethodGet, body)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, ErrorCodeMethodNotAllowed, e.Code)
+
+	w, e = sendRequestMock(t, ns, http.MethodPost, `{"target":{}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrorCodeInvalidNotification, e.Code)
+
+	w, _ = sendRequestMock(t, ns, http.MethodPost, body)
+	assert.Equal(t, http.StatusOK, w.Code, "nobody subscribed is not an error by default")
+	ns.config.Routing.Strict = true
+	w, e = sendRequestMock(t, ns, http.MethodPost, body)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, ErrorCodeNoSubscribers, e.Code)
+	assert.NotEmpty(t, e.NotificationID)
+
+	ns.shuttingDown.Store(true)
+	w, e = sendRequestMock(t, ns, http.MethodPost, body)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, ErrorCodeShuttingDown, e.Code)
+}
+
+func TestRestAPIDeliveryFailure(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = &failingWritesMock{}
+	_, id := ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+
+	w, e := sendRequestMock(t, ns, http.MethodPost, `{"target":{"customer":"test"},"sendSynchronicity":true}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, ErrorCodeDeliveryFailed, e.Code)
+	assert.NotEmpty(t, e.NotificationID)
+	if assert.Equal(t, 1, len(e.Failures)) {
+		assert.Equal(t, id, e.Failures[0].ID)
+		assert.Equal(t, DeliveryStatusFailed, e.Failures[0].Status)
+		assert.NotEmpty(t, e.Failures[0].Error)
+	}
+}
+
+func TestRestAPIRequestPending(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	_, err := ns.replies.Expect("scan-1", []int{1})
+	assert.NoError(t, err)
+
+	w, e := sendRequestMock(t, ns, http.MethodPost, `{"target":{"customer":"test"},"correlationID":"scan-1"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrorCodeRequestPending, e.Code)
+}
+
+func TestSubscriptionErrorResponses(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	for name, handler := range map[string]http.HandlerFunc{"websocket": ns.WebsocketNotificationHandler, "sse": ns.SSENotificationHandler} {
+		subscribe := func(method, query string) (*httptest.ResponseRecorder, *ErrorResponse) {
+			r := httptest.NewRequest(method, "/"+query, nil)
+			r.Header.Set(RequestIDHeader, "req-1")
+			w := httptest.NewRecorder()
+			handler(w, r)
+			e := &ErrorResponse{}
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"), name)
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), e), name)
+			assert.Equal(t, "req-1", e.RequestID, name)
+			return w, e
+		}
+
+		w, e := subscribe(http.MethodPost, "?customer=test")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, name)
+		assert.Equal(t, ErrorCodeMethodNotAllowed, e.Code, name)
+
+		w, e = subscribe(http.MethodGet, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.Equal(t, ErrorCodeInvalidRequest, e.Code, name)
+
+		ns.shuttingDown.Store(true)
+		w, e = subscribe(http.MethodGet, "?customer=test")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, name)
+		assert.Equal(t, ErrorCodeShuttingDown, e.Code, name)
+		ns.shuttingDown.Store(false)
+	}
+}
@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileChangeDebounce is the quiet period after the last change of a watched file before the change is handled,
+// so a file written in several steps, or a secret rotated by swapping a symlink, is handled once
+const fileChangeDebounce = 200 * time.Millisecond
+
+// watchFiles calls onChange whenever any of the given files change, until stop is closed.
+// The directories are watched rather than the files, since mounted secrets and config maps are updated by replacing a symlink.
+// Empty paths and missing directories are skipped
+func watchFiles(files []string, stop <-chan struct{}, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	dirs := map[string]bool{}
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if file == "" || dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if _, err := os.Stat(dir); err != nil {
+			logger.L().Warning("not watching missing directory", helpers.String("directory", dir))
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch '%s', reason: %s", dir, err.Error())
+		}
+	}
+
+	debounce := time.NewTimer(fileChangeDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			debounce.Reset(fileChangeDebounce)
+		case <-debounce.C:
+			onChange()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.L().Warning("file watcher error", helpers.Error(err))
+		}
+	}
+}
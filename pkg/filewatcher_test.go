@@ -0,0 +1,35 @@
+package gateway
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeFileMock(t, path, []byte("a"))
+
+	var changes atomic.Int32
+	stop := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- watchFiles([]string{path, "", filepath.Join(t.TempDir(), "missing", "file")}, stop, func() { changes.Add(1) })
+	}()
+	time.Sleep(50 * time.Millisecond) // let the watcher start
+
+	// a file written in several steps is handled once
+	for _, content := range []string{"b", "c", "d"} {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	assert.Eventually(t, func() bool { return changes.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(2 * fileChangeDebounce)
+	assert.Equal(t, int32(1), changes.Load())
+
+	close(stop)
+	assert.NoError(t, <-stopped)
+}
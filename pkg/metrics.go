@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PathMetrics is the REST API path exposing the Prometheus metrics
+const PathMetrics = "/metrics"
+
+const (
+	// NotificationSourceREST a notification received over the REST API
+	NotificationSourceREST = "rest"
+	// NotificationSourceWebsocket a notification received over a websocket, from a parent or a peer
+	NotificationSourceWebsocket = "websocket"
+)
+
+// Metrics are the Prometheus metrics of a Gateway
+type Metrics struct {
+	registry *prometheus.Registry
+
+	notificationsReceived     *prometheus.CounterVec
+	fanOut                    prometheus.Histogram
+	deliveryLatency           prometheus.Histogram
+	writeErrors               prometheus.Counter
+	panicsRecovered           prometheus.Counter
+	parentReconnects          prometheus.Counter
+	notificationsDropped      prometheus.Counter
+	slowConsumersEvicted      *prometheus.CounterVec
+	notificationsUpstream     prometheus.Counter
+	notificationsDeduplicated *prometheus.CounterVec
+	notificationsRateLimited  *prometheus.CounterVec
+}
+
+// connectionsCollector reports the connection gauges from the routing tables when scraped,
+// so they never drift from the registered connections
+type connectionsCollector struct {
+	incoming      Router
+	outgoing      Router
+	attributeKeys []string
+	incomingDesc  *prometheus.Desc
+	outgoingDesc  *prometheus.Desc
+}
+
+// NewMetrics creates the metrics of a Gateway. The connection gauges are broken down by the given attribute keys
+func NewMetrics(incoming, outgoing Router, attributeKeys []string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		notificationsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_notifications_received_total",
+			Help: "Number of notifications received, by source",
+		}, []string{"source"}),
+		fanOut: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gateway_notification_fanout",
+			Help:    "Number of connections a notification was routed to",
+			Buckets: []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000},
+		}),
+		deliveryLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gateway_delivery_duration_seconds",
+			Help:    "Time it took to write a notification to a single connection",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+		writeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gateway_write_errors_total",
+			Help: "Number of notifications that failed to be written to a connection",
+		}),
+		panicsRecovered: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gateway_panics_recovered_total",
+			Help: "Number of panics recovered while writing to a connection",
+		}),
+		parentReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gateway_parent_reconnects_total",
+			Help: "Number of attempts to connect again to the parent gateway",
+		}),
+		notificationsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gateway_notifications_dropped_total",
+			Help: "Number of notifications dropped because the send queue of a connection was full",
+		}),
+		slowConsumersEvicted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_slow_consumers_evicted_total",
+			Help: "Number of subscribers disconnected for not keeping up, by reason",
+		}, []string{"reason"}),
+		notificationsUpstream: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gateway_notifications_forwarded_upstream_total",
+			Help: "Number of notifications without local subscribers forwarded to the parent gateway",
+		}),
+		notificationsDeduplicated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_notifications_deduplicated_total",
+			Help: "Number of repeated notifications dropped, by reason: a subscriber already received it, or another parent gateway already sent it",
+		}, []string{"reason"}),
+		notificationsRateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_notifications_rate_limited_total",
+			Help: "Number of notifications rejected for exceeding a rate limit, by source and limit",
+		}, []string{"source", "limit"}),
+	}
+	m.registry.MustRegister(
+		m.notificationsReceived,
+		m.fanOut,
+		m.deliveryLatency,
+		m.writeErrors,
+		m.panicsRecovered,
+		m.parentReconnects,
+		m.notificationsDropped,
+		m.slowConsumersEvicted,
+		m.notificationsUpstream,
+		m.notificationsDeduplicated,
+		m.notificationsRateLimited,
+		&connectionsCollector{
+			incoming:      incoming,
+			outgoing:      outgoing,
+			attributeKeys: attributeKeys,
+			incomingDesc:  prometheus.NewDesc("gateway_incoming_connections", "Number of subscribers connected to the gateway", attributeKeys, nil),
+			outgoingDesc:  prometheus.NewDesc("gateway_outgoing_connections", "Number of connections to the parent gateway", attributeKeys, nil),
+		},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Describe implements prometheus.Collector
+func (cc *connectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.incomingDesc
+	ch <- cc.outgoingDesc
+}
+
+// Collect implements prometheus.Collector
+func (cc *connectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.collect(ch, cc.incomingDesc, cc.incoming)
+	cc.collect(ch, cc.outgoingDesc, cc.outgoing)
+}
+
+// collect reports the number of connections of a router per combination of the attribute key values.
+// Connections missing a key are reported with an empty value
+func (cc *connectionsCollector) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc, router Router) {
+	type group struct {
+		values []string
+		count  int
+	}
+	groups := map[string]*group{}
+	for _, conn := range router.List() {
+		attributes := conn.GetAttributes()
+		values := make([]string, len(cc.attributeKeys))
+		for i, key := range cc.attributeKeys {
+			values[i] = attributes[key]
+		}
+		// label values cannot contain a NUL, so it safely separates them
+		key := strings.Join(values, "\x00")
+		if _, ok := groups[key]; !ok {
+			groups[key] = &group{values: values}
+		}
+		groups[key].count++
+	}
+	if len(groups) == 0 && len(cc.attributeKeys) == 0 {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 0)
+		return
+	}
+	for _, g := range groups {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(g.count), g.values...)
+	}
+}
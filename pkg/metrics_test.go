@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrapeMock(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", PathMetrics, nil))
+	b, err := io.ReadAll(w.Body)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestMetrics(t *testing.T) {
+	ns := NewNotificationServerMasterMock()
+	ns.incomingConnections.Append(map[string]string{"customer": "a", "cluster": "1"}, nil, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "a", "cluster": "2"}, nil, nil)
+	_, id := ns.incomingConnections.Append(map[string]string{"cluster": "3"}, nil, nil)
+	ns.outgoingConnections.Append(map[string]string{"customer": "a"}, nil, nil)
+
+	_, err := ns.SendNotification(NotificationMock(map[string]string{"customer": "a"}, true), []byte("{}"))
+	assert.NoError(t, err)
+	ns.metrics.notificationsReceived.WithLabelValues(NotificationSourceREST).Inc()
+
+	metrics := scrapeMock(t, ns.metrics)
+	assert.Contains(t, metrics, `gateway_incoming_connections{customer="a"} 2`)
+	assert.Contains(t, metrics, `gateway_incoming_connections{customer=""} 1`, "connections without the key are reported with an empty value")
+	assert.Contains(t, metrics, `gateway_outgoing_connections{customer="a"} 1`)
+	assert.Contains(t, metrics, `gateway_notification_fanout_sum 2`)
+	assert.Contains(t, metrics, `gateway_delivery_duration_seconds_count 2`)
+	assert.Contains(t, metrics, `gateway_notifications_received_total{source="rest"} 1`)
+
+	ns.incomingConnections.RemoveID(id)
+	assert.NotContains(t, scrapeMock(t, ns.metrics), `gateway_incoming_connections{customer=""}`, "gauges follow the routing table")
+}
+
+func TestMetricsWithoutAttributeKeys(t *testing.T) {
+	m := NewMetrics(NewRouter(), NewRouter(), []string{})
+	assert.Contains(t, scrapeMock(t, m), "gateway_incoming_connections 0")
+}
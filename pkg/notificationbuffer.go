@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+const (
+	// NotificationBufferMemory keeps the buffered notifications in memory
+	NotificationBufferMemory = "memory"
+	// NotificationBufferDisk keeps the buffered notifications on the local disk, so they survive restarts
+	NotificationBufferDisk = "disk"
+
+	defaultNotificationBufferTTL  = 5 * time.Minute
+	defaultNotificationBufferSize = 100
+	defaultNotificationBufferDir  = "/tmp/gateway-buffer"
+)
+
+// BufferedNotification is a notification that was sent to a route without subscribers
+type BufferedNotification struct {
+	Route        map[string]string `json:"route"`
+	Notification []byte            `json:"notification"`
+	Enqueued     time.Time         `json:"enqueued"`
+}
+
+// NotificationBuffer stores notifications sent to routes without subscribers until a matching subscriber connects
+type NotificationBuffer interface {
+	// Push buffers a notification sent to a given route
+	Push(route map[string]string, notification []byte) error
+	// Pop removes and returns the buffered notifications whose route matches the given connection attributes, oldest first
+	Pop(attributes map[string]string) ([]BufferedNotification, error)
+}
+
+// routeQueue holds the notifications buffered for a single route
+type routeQueue struct {
+	Route         map[string]string      `json:"route"`
+	Notifications []BufferedNotification `json:"notifications"`
+}
+
+// MemoryNotificationBuffer is a NotificationBuffer that keeps a bounded queue per route in memory.
+// Notifications older than the TTL are dropped, and when a queue is full the oldest notification is dropped
+type MemoryNotificationBuffer struct {
+	queues map[string]*routeQueue
+	ttl    time.Duration
+	size   int
+	mutex  *sync.Mutex
+	// persist is called with the key of a route queue after it changed
+	persist func(key string, queue *routeQueue)
+}
+
+// NewMemoryNotificationBuffer creates a new MemoryNotificationBuffer
+func NewMemoryNotificationBuffer(ttl time.Duration, size int) *MemoryNotificationBuffer {
+	return &MemoryNotificationBuffer{
+		queues:  map[string]*routeQueue{},
+		ttl:     ttl,
+		size:    size,
+		mutex:   &sync.Mutex{},
+		persist: func(string, *routeQueue) {},
+	}
+}
+
+// routeKey returns a key identifying a route
+func routeKey(route map[string]string) string {
+	return strutils.ObjectToString(route)
+}
+
+// Push buffers a notification sent to a given route
+func (mb *MemoryNotificationBuffer) Push(route map[string]string, notification []byte) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	key := routeKey(route)
+	queue, ok := mb.queues[key]
+	if !ok {
+		queue = &routeQueue{Route: route}
+		mb.queues[key] = queue
+	}
+	mb.expire(queue)
+	if len(queue.Notifications) >= mb.size {
+		logger.L().Warning("notification buffer is full, dropping the oldest notification", helpers.String("route", key), helpers.Int("size", mb.size))
+		queue.Notifications = queue.Notifications[len(queue.Notifications)-mb.size+1:]
+	}
+	queue.Notifications = append(queue.Notifications, BufferedNotification{
+		Route:        route,
+		Notification: notification,
+		Enqueued:     time.Now(),
+	})
+	mb.persist(key, queue)
+	return nil
+}
+
+// Pop removes and returns the buffered notifications whose route matches the given connection attributes, oldest first
+func (mb *MemoryNotificationBuffer) Pop(attributes map[string]string) ([]BufferedNotification, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	notifications := []BufferedNotification{}
+	for key, queue := range mb.queues {
+		mb.expire(queue)
+		if len(queue.Notifications) == 0 {
+			delete(mb.queues, key)
+			mb.persist(key, nil)
+			continue
+		}
+		if !websocketactions.AttributesContained(attributes, queue.Route) {
+			continue
+		}
+		notifications = append(notifications, queue.Notifications...)
+		delete(mb.queues, key)
+		mb.persist(key, nil)
+	}
+	sort.SliceStable(notifications, func(i, j int) bool { return notifications[i].Enqueued.Before(notifications[j].Enqueued) })
+	return notifications, nil
+}
+
+// expire drops the notifications that outlived the TTL. The caller must hold the lock
+func (mb *MemoryNotificationBuffer) expire(queue *routeQueue) {
+	i := 0
+	for i < len(queue.Notifications) && time.Since(queue.Notifications[i].Enqueued) > mb.ttl {
+		i++
+	}
+	if i > 0 {
+		logger.L().Info("dropping expired buffered notifications", helpers.String("route", routeKey(queue.Route)), helpers.Int("count", i))
+		queue.Notifications = queue.Notifications[i:]
+	}
+}
+
+// NewDiskNotificationBuffer creates a MemoryNotificationBuffer that mirrors every route queue to a file in a given directory.
+// Queues found in the directory are loaded, so buffered notifications survive restarts
+func NewDiskNotificationBuffer(dir string, ttl time.Duration, size int) (*MemoryNotificationBuffer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create notification buffer directory '%s', reason: %s", dir, err.Error())
+	}
+	mb := NewMemoryNotificationBuffer(ttl, size)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			logger.L().Warning("failed to read buffered notifications", helpers.String("file", file), helpers.Error(err))
+			continue
+		}
+		queue := &routeQueue{}
+		if err := json.Unmarshal(b, queue); err != nil {
+			logger.L().Warning("failed to parse buffered notifications", helpers.String("file", file), helpers.Error(err))
+			continue
+		}
+		mb.queues[routeKey(queue.Route)] = queue
+	}
+	logger.L().Info("loaded buffered notifications", helpers.String("dir", dir), helpers.Int("routes", len(mb.queues)))
+
+	mb.persist = func(key string, queue *routeQueue) {
+		sum := sha256.Sum256([]byte(key))
+		file := filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+		if queue == nil || len(queue.Notifications) == 0 {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				logger.L().Warning("failed to remove buffered notifications", helpers.String("file", file), helpers.Error(err))
+			}
+			return
+		}
+		b, _ := json.Marshal(queue)
+		// write to a temporary file first so a crash never leaves a partial queue behind
+		if err := os.WriteFile(file+".tmp", b, 0o600); err != nil {
+			logger.L().Warning("failed to persist buffered notifications", helpers.String("file", file), helpers.Error(err))
+			return
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			logger.L().Warning("failed to persist buffered notifications", helpers.String("file", file), helpers.Error(err))
+		}
+	}
+	return mb, nil
+}
+
+// newNotificationBuffer creates the configured NotificationBuffer.
+// Returns nil when buffering is disabled
+func newNotificationBuffer(cfg BufferConfig) NotificationBuffer {
+	ttl := time.Duration(cfg.TTL)
+	switch cfg.Type {
+	case NotificationBufferMemory:
+		return NewMemoryNotificationBuffer(ttl, cfg.Size)
+	case NotificationBufferDisk:
+		buffer, err := NewDiskNotificationBuffer(cfg.Dir, ttl, cfg.Size)
+		if err != nil {
+			logger.L().Error("failed to create disk notification buffer, buffering in memory", helpers.Error(err))
+			return NewMemoryNotificationBuffer(ttl, cfg.Size)
+		}
+		return buffer
+	default:
+		return nil
+	}
+}
+
+// deliverBufferedNotifications queues the notifications that were buffered for a new connection, in order.
+// A notification that is not written, because the write failed or the send queue dropped it, is buffered again
+func (nh *Gateway) deliverBufferedNotifications(conn *websocketactions.Connection) {
+	if nh.notificationBuffer == nil {
+		return
+	}
+	notifications, err := nh.notificationBuffer.Pop(conn.GetAttributes())
+	if err != nil {
+		logger.L().Error("failed to read buffered notifications", helpers.Int("id", conn.ID), helpers.Error(err))
+		return
+	}
+	if len(notifications) > 0 {
+		logger.L().Info("delivering buffered notifications", helpers.Int("id", conn.ID), helpers.Int("count", len(notifications)))
+	}
+	// the writes complete in any order once one fails, so the failed notifications are buffered again once all of them completed
+	mutex := sync.Mutex{}
+	failed := make([]error, len(notifications))
+	pending := len(notifications)
+	done := func(i int, err error) {
+		mutex.Lock()
+		failed[i] = err
+		pending--
+		last := pending == 0
+		mutex.Unlock()
+		if last {
+			nh.bufferAgain(conn, notifications, failed)
+		}
+	}
+	for i := range notifications {
+		prepared, err := websocketactions.NewPreparedMessage(notifications[i].Notification)
+		if err != nil {
+			logger.L().Error("failed to prepare buffered notification", helpers.Int("id", conn.ID), helpers.Error(err))
+			done(i, nil)
+			continue
+		}
+		nh.enqueueNotification(conn, &outboundNotification{raw: notifications[i].Notification, prepared: prepared}, func(err error) { done(i, err) })
+	}
+}
+
+// bufferAgain buffers the notifications that failed to be written to a connection again, in order
+func (nh *Gateway) bufferAgain(conn *websocketactions.Connection, notifications []BufferedNotification, failed []error) {
+	for i := range notifications {
+		if failed[i] == nil {
+			continue
+		}
+		logger.L().Warning("failed to deliver buffered notification, buffering it again", helpers.Int("id", conn.ID), helpers.Error(failed[i]))
+		if err := nh.notificationBuffer.Push(notifications[i].Route, notifications[i].Notification); err != nil {
+			logger.L().Error("failed to buffer notification again", helpers.Int("id", conn.ID), helpers.Error(err))
+		}
+	}
+}
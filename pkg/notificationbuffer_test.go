@@ -0,0 +1,127 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryNotificationBuffer(t *testing.T) {
+	mb := NewMemoryNotificationBuffer(time.Minute, 2)
+	assert.NoError(t, mb.Push(map[string]string{"customer": "test"}, []byte("1")))
+	assert.NoError(t, mb.Push(map[string]string{"customer": "test", "cluster": "yay"}, []byte("2")))
+	assert.NoError(t, mb.Push(map[string]string{"customer": "other"}, []byte("3")))
+
+	notifications, err := mb.Pop(ATTRIBUTES_MOCK)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(notifications)) {
+		assert.Equal(t, "1", string(notifications[0].Notification), "notifications are delivered in order")
+		assert.Equal(t, "2", string(notifications[1].Notification))
+	}
+
+	// notifications are delivered once
+	notifications, _ = mb.Pop(ATTRIBUTES_MOCK)
+	assert.Equal(t, 0, len(notifications))
+
+	// the oldest notification is dropped when the route queue is full
+	mb.Push(map[string]string{"customer": "other"}, []byte("4"))
+	mb.Push(map[string]string{"customer": "other"}, []byte("5"))
+	notifications, _ = mb.Pop(map[string]string{"customer": "other"})
+	if assert.Equal(t, 2, len(notifications)) {
+		assert.Equal(t, "4", string(notifications[0].Notification))
+		assert.Equal(t, "5", string(notifications[1].Notification))
+	}
+}
+
+func TestMemoryNotificationBufferTTL(t *testing.T) {
+	mb := NewMemoryNotificationBuffer(time.Millisecond, 10)
+	mb.Push(ATTRIBUTES_MOCK, []byte("1"))
+	time.Sleep(5 * time.Millisecond)
+	notifications, err := mb.Pop(ATTRIBUTES_MOCK)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(notifications))
+	assert.Equal(t, 0, len(mb.queues))
+}
+
+func TestDiskNotificationBuffer(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDiskNotificationBuffer(dir, time.Minute, 10)
+	assert.NoError(t, err)
+	db.Push(ATTRIBUTES_MOCK, []byte("1"))
+	db.Push(ATTRIBUTES_MOCK, []byte("2"))
+
+	// a restarted gateway loads the buffered notifications
+	restarted, err := NewDiskNotificationBuffer(dir, time.Minute, 10)
+	assert.NoError(t, err)
+	notifications, err := restarted.Pop(map[string]string{"customer": "test"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(notifications))
+
+	restarted, _ = NewDiskNotificationBuffer(dir, time.Minute, 10)
+	notifications, _ = restarted.Pop(map[string]string{"customer": "test"})
+	assert.Equal(t, 0, len(notifications))
+}
+
+func TestSendNotificationQueued(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	result, err := ns.SendNotification(NotificationMock(ATTRIBUTES_MOCK, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.False(t, result.Queued, "buffering is disabled by default")
+
+	buffer := NewMemoryNotificationBuffer(time.Minute, 10)
+	ns.notificationBuffer = buffer
+	result, err = ns.SendNotification(NotificationMock(ATTRIBUTES_MOCK, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.True(t, result.Queued)
+	assert.Equal(t, 0, len(result.Connections))
+
+	conn, _ := ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	ns.deliverBufferedNotifications(conn)
+	assert.Equal(t, 0, len(buffer.queues))
+}
+
+func TestDeliverBufferedNotifications(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.notificationBuffer = NewMemoryNotificationBuffer(time.Minute, 10)
+	ns.notificationBuffer.Push(ATTRIBUTES_MOCK, []byte(`{"n":1}`))
+	ns.notificationBuffer.Push(ATTRIBUTES_MOCK, []byte(`{"n":2}`))
+	flushed := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		assert.NoError(t, ns.pendingWrites.wait(ctx))
+	}
+
+	w := httptest.NewRecorder()
+	stream, _ := websocketactions.NewSSEStream(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ns.incomingConnections.AppendStream(ATTRIBUTES_MOCK, stream, func(conn *websocketactions.Connection) {
+		ns.setupIncomingConnection(conn)
+		assert.Equal(t, 0, ns.incomingConnections.Len(), "the buffered notifications are queued before the connection is routed")
+	})
+	_, err := ns.SendNotification(NotificationMock(ATTRIBUTES_MOCK, false), []byte(`{"n":3}`))
+	assert.NoError(t, err)
+	flushed()
+	body := w.Body.String()
+	first, second, live := strings.Index(body, `{"n":1}`), strings.Index(body, `{"n":2}`), strings.Index(body, `{"n":3}`)
+	assert.True(t, first >= 0 && first < second && second < live, "the buffered notifications are written first, in order: %q", body)
+
+	// a subscriber that goes away before reading them leaves them buffered
+	ns.notificationBuffer.Push(ATTRIBUTES_MOCK, []byte(`{"n":4}`))
+	ns.notificationBuffer.Push(ATTRIBUTES_MOCK, []byte(`{"n":5}`))
+	stream, _ = websocketactions.NewSSEStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	stream.Close()
+	ns.incomingConnections.AppendStream(ATTRIBUTES_MOCK, stream, ns.setupIncomingConnection)
+	flushed()
+	notifications, _ := ns.notificationBuffer.Pop(ATTRIBUTES_MOCK)
+	if assert.Equal(t, 2, len(notifications), "failed writes are buffered again") {
+		assert.Equal(t, `{"n":4}`, string(notifications[0].Notification))
+		assert.Equal(t, `{"n":5}`, string(notifications[1].Notification))
+	}
+}
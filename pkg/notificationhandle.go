@@ -2,14 +2,15 @@ package gateway
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	strutils "github.com/armosec/utils-go/str"
@@ -20,41 +21,108 @@ import (
 	"github.com/gorilla/websocket"
 	beClientV1 "github.com/kubescape/backend/pkg/client/v1"
 	beServerV1 "github.com/kubescape/backend/pkg/server/v1"
-	"github.com/kubescape/backend/pkg/servicediscovery"
-	v2 "github.com/kubescape/backend/pkg/servicediscovery/v2"
 	"github.com/kubescape/backend/pkg/utils"
 	"github.com/kubescape/gateway/pkg/websocketactions"
 	"gopkg.in/mgo.v2/bson"
 )
 
-const serviceDiscoveryConfigPath = "/etc/config/services.json"
-
 // Gateway is the main Gateway service object.
 // It acts as a facade that manages incoming and outgoing connections, routes
 // messages to recipients etc.
 type Gateway struct {
 	wa                       websocketactions.IWebsocketActions
-	outgoingConnections      Connections
-	incomingConnections      Connections
+	outgoingConnections      Router
+	incomingConnections      Router
 	outgoingConnectionsMutex *sync.Mutex
-	rootGatewayURL           string
+	// upstreamLinks are the supervised links to the master, keyed by their attributes. Guarded by outgoingConnectionsMutex
+	upstreamLinks    map[string]*upstreamLink
+	reconnectBackoff Backoff
+	acks             *AckTracker
+	replies          *ReplyTracker
+	// authenticator authenticates the subscribers, nil if authentication is disabled
+	authenticator *Authenticator
+	// serverTLS serves the listeners over TLS, nil if TLS is disabled
+	serverTLS *ServerTLS
+	metrics   *Metrics
+	// restAPIServer and websocketServer are the listeners, set by SetupAndServe
+	restAPIServer   *http.Server
+	websocketServer *http.Server
+	shuttingDown    atomic.Bool
+	pendingWrites   pendingWrites
+	// restRequests counts the REST requests being handled, so the notifications they send are flushed while shutting down
+	restRequests pendingWrites
+	// notificationBuffer stores notifications for routes without subscribers, nil if buffering is disabled
+	notificationBuffer NotificationBuffer
+	// configMutex guards config, parents and parentAccessKey, which change when the configuration is reloaded
+	configMutex sync.RWMutex
+	config      *Config
+	// parents are the URLs of the masters in order of preference
+	parents []string
+	// duplicates drops the notifications the masters send more than once, in active-active mode
+	duplicates *DedupCache
+	// dedup drops the notifications a subscriber already received, nil if deduplication is disabled
+	dedup *DedupCache
+	// rateLimiter limits the notifications of the senders, nil if no limit is set
+	rateLimiter *RateLimiter
+	// validator checks the attribute keys and values of the notifications, nil if any are accepted
+	validator *EnvelopeValidator
+	// scheduler holds the notifications delivered at a later time, nil if scheduling is disabled
+	scheduler *Scheduler
+	// parentAccessKey is the access key of the latest connection to the master
+	parentAccessKey string
 }
 
-// NewGateway creates a new Gateway
-func NewGateway() *Gateway {
-
-	rootGatewayUrl := getRootGwUrl()
-
+// NewGateway creates a new Gateway with a given configuration
+func NewGateway(cfg *Config) *Gateway {
+	wa := websocketactions.NewWebsocketActions()
+	outgoingConnections := NewRouter()
+	incomingConnections := NewRouter()
 	return &Gateway{
-		wa:                       websocketactions.NewWebsocketActions(),
-		outgoingConnections:      *NewConnectionsObj(),
-		incomingConnections:      *NewConnectionsObj(),
+		wa:                       wa,
+		outgoingConnections:      outgoingConnections,
+		incomingConnections:      incomingConnections,
 		outgoingConnectionsMutex: &sync.Mutex{},
-		rootGatewayURL:           rootGatewayUrl,
+		upstreamLinks:            map[string]*upstreamLink{},
+		reconnectBackoff:         newBackoff(cfg.Parent),
+		notificationBuffer:       newNotificationBuffer(cfg.Buffer),
+		acks:                     newAckTracker(wa, cfg.Acks),
+		replies:                  NewReplyTracker(time.Duration(cfg.Requests.ReplyTimeout)),
+		authenticator:            newAuthenticator(cfg.Auth),
+		serverTLS:                newServerTLS(cfg.Listeners.TLS),
+		metrics:                  NewMetrics(incomingConnections, outgoingConnections, cfg.Metrics.AttributeKeys),
+		config:                   cfg,
+		parents:                  cfg.Parent.parentURLs(),
+		duplicates:               NewDedupCache(parentDuplicatesWindow, cfg.Dedup.Size),
+		dedup:                    newDedupCache(cfg.Dedup),
+		rateLimiter:              newRateLimiter(cfg.RateLimits),
+		validator:                newEnvelopeValidator(cfg.Validation),
+		scheduler:                newScheduler(cfg.Scheduler),
 	}
 }
 
-type Notification notifier.Notification
+// Notification is a notification passed between the Gateway servers.
+// It extends the notifier envelope with the gateway delivery options
+type Notification struct {
+	notifier.Notification `bson:",inline"`
+	// MessageID identifies the notification. The gateway stamps it on notifications that require an acknowledgement
+	MessageID string `json:"messageID,omitempty" bson:"messageID,omitempty"`
+	// RequireAck requests every subscriber to acknowledge the notification with an AckFrame
+	RequireAck bool `json:"requireAck,omitempty" bson:"requireAck,omitempty"`
+	// AckTimeoutMs is how long a synchronous sender waits for the acknowledgements, the gateway default if 0
+	AckTimeoutMs int `json:"ackTimeoutMs,omitempty" bson:"ackTimeoutMs,omitempty"`
+	// Hops counts the gateways that forwarded the notification to their parent
+	Hops int `json:"hops,omitempty" bson:"hops,omitempty"`
+	// CorrelationID makes the notification a request, the subscribers reply to it with a ReplyFrame
+	CorrelationID string `json:"correlationID,omitempty" bson:"correlationID,omitempty"`
+	// ReplyTimeoutMs is how long to wait for the replies to a request, the gateway default if 0
+	ReplyTimeoutMs int `json:"replyTimeoutMs,omitempty" bson:"replyTimeoutMs,omitempty"`
+	// Match selects the subscribers by attribute expressions on top of the target, which is then optional
+	Match *websocketactions.Match `json:"match,omitempty" bson:"match,omitempty"`
+	// DeliverAt schedules the notification, it is held by the gateway it was sent to until then
+	DeliverAt *time.Time `json:"deliverAt,omitempty" bson:"deliverAt,omitempty"`
+	// DelayMs schedules the notification a number of milliseconds after it was received
+	DelayMs int `json:"delayMs,omitempty" bson:"delayMs,omitempty"`
+}
 
 // WebsocketNotificationHandler establishes a websocket connection and handles incoming notifications
 func (nh *Gateway) WebsocketNotificationHandler(w http.ResponseWriter, r *http.Request) {
@@ -62,27 +130,42 @@ func (nh *Gateway) WebsocketNotificationHandler(w http.ResponseWriter, r *http.R
 	// receive websocket connection from client
 	if r.Method != http.MethodGet {
 		logger.L().Error("Method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
 		return
 
 	}
+	if nh.rejectWhileShuttingDown(w, r) {
+		return
+	}
+	if e := nh.authorizeSubscription(r); e != nil {
+		logger.L().Error("in WebsocketNotificationHandler", helpers.String("error", e.response.Message))
+		writeError(w, r, e.status, e.response)
+		return
+	}
 
-	conn, notificationAtt, err := nh.AcceptWebsocketConnection(w, r)
+	notificationAtt, err := nh.subscriptionAttributes(r)
 	if err != nil {
 		logger.L().Error(err.Error())
-		http.Error(w, err.Error(), 400)
+		writeError(w, r, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: err.Error()})
+		return
+	}
+	conn, err := nh.upgradeWebsocket(w, r)
+	if err != nil {
+		// the upgrader already answered the request
+		logger.L().Error("in WebsocketNotificationHandler, failed to upgrade", helpers.Error(err))
 		return
 	}
 
 	// ----------------------------------------------------- 2
 	// append new route
-	newConn, id := nh.incomingConnections.Append(notificationAtt, conn)
+	newConn, id := nh.incomingConnections.Append(notificationAtt, conn, nh.setupIncomingConnection)
+	if nh.rateLimiter != nil {
+		newConn.SetSender(nh.senderOf(r))
+	}
 	logger.L().Info("accepting websocket connection", helpers.String("url query", r.URL.RawQuery), helpers.Int("id", id), helpers.Int("number of incoming websockets", nh.incomingConnections.Len()))
 
 	// ----------------------------------------------------- 3
-	// register route in master if master configured
-	// create websocket with master
-	go nh.connectToMaster(notificationAtt, 0)
+	nh.registerIncomingConnection(newConn)
 
 	// ----------------------------------------------------- 4
 	// Websocket read messages
@@ -91,34 +174,160 @@ func (nh *Gateway) WebsocketNotificationHandler(w http.ResponseWriter, r *http.R
 	nh.wa.Close(newConn)
 }
 
+// setupIncomingConnection readies a new incoming connection, websocket or stream alike, before it is routed
+func (nh *Gateway) setupIncomingConnection(newConn *websocketactions.Connection) {
+	nh.startSendQueue(newConn)
+
+	// queue the notifications sent while nobody was subscribed, ahead of any notification routed to the connection
+	nh.deliverBufferedNotifications(newConn)
+}
+
+// registerIncomingConnection completes the registration of a newly appended incoming connection, websocket or stream alike
+func (nh *Gateway) registerIncomingConnection(newConn *websocketactions.Connection) {
+	// register route in master if master configured
+	// create websocket with master
+	go nh.connectToMaster(newConn.GetAttributes())
+}
+
 func getRequestHeaders(accessKey string) http.Header {
 	headers := http.Header{}
 	headers.Set(beServerV1.AccessKeyHeader, accessKey)
 	return headers
 }
 
-// ConnectToMaster registers an incoming connection with given attributes with the Master Gateway
-func (nh *Gateway) connectToMaster(notificationAtt map[string]string, retry int) {
+// connectToMaster registers an incoming connection with given attributes with the Master Gateway.
+// A single supervised link is kept per set of attributes, and per master in active-active mode.
+// In multiplexed mode a single link per master carries the subscriptions of all the local subscribers,
+// unless they share no parent attributes, then the subscribers the link is not authorized for get links of their own
+func (nh *Gateway) connectToMaster(notificationAtt map[string]string) {
 	if nh.hasParent() { // only edge connects to master
 		return
 	}
+	if nh.shuttingDown.Load() {
+		return
+	}
 
-	att := strutils.MergeSliceAndMap([]string{notifier.TargetCustomer}, notificationAtt)
+	att := nh.linkAttributes(notificationAtt)
+	if nh.currentConfig().Parent.Multiplexed {
+		// the link registers with the attributes all the local subscribers share
+		if shared := nh.sharedLinkAttributes(); len(shared) > 0 {
+			att = shared
+		}
+	}
+
+	// a failover link picks its master when dialing, an active-active link is pinned to one
+	pinned := []string{""}
+	if nh.currentConfig().Parent.Mode == ParentModeActiveActive {
+		pinned = nh.parentURLs()
+	}
+	wg := sync.WaitGroup{}
+	for _, parent := range pinned {
+		wg.Add(1)
+		go func(parent string) {
+			defer wg.Done()
+			nh.linkToMaster(parent, att)
+		}(parent)
+	}
+	wg.Wait()
+}
+
+// linkAttributes returns the attributes a link to the master registers with for a subscriber with given attributes
+func (nh *Gateway) linkAttributes(notificationAtt map[string]string) map[string]string {
+	att := strutils.MergeSliceAndMap(nh.currentConfig().Routing.ParentAttributes, notificationAtt)
 	if len(att) == 0 {
 		att = notificationAtt
 	}
+	return att
+}
+
+// sharedLinkAttributes returns the link attributes all the local subscribers have in common, empty if they share none
+func (nh *Gateway) sharedLinkAttributes() map[string]string {
+	var shared map[string]string
+	for _, conn := range nh.incomingConnections.List() {
+		att := nh.linkAttributes(conn.GetAttributes())
+		if shared == nil {
+			shared = make(map[string]string, len(att))
+			for k, v := range att {
+				shared[k] = v
+			}
+			continue
+		}
+		for k, v := range shared {
+			if att[k] != v {
+				delete(shared, k)
+			}
+		}
+	}
+	return shared
+}
+
+// linkToMaster supervises a link with given attributes, pinned to a given master unless empty, if there is none yet
+func (nh *Gateway) linkToMaster(pinned string, att map[string]string) {
+	multiplexed := nh.currentConfig().Parent.Multiplexed
 	nh.outgoingConnectionsMutex.Lock() // lock connecting to master to prevent many connections
 
-	// if connected
-	if cons := nh.outgoingConnections.Get(att); len(cons) > 0 {
-		nh.outgoingConnectionsMutex.Unlock()
-		logger.L().Info("edge already connected to master, not creating new connection")
-		return
+	// if connected or connecting
+	for _, link := range nh.upstreamLinks {
+		if link.pinned != pinned {
+			continue
+		}
+		if multiplexed && subscriptionAuthorized(link.registration(), att) || !multiplexed && websocketactions.AttributesContained(link.registration(), att) {
+			nh.outgoingConnectionsMutex.Unlock()
+			logger.L().Info("edge already connected to master, not creating new connection")
+			// the subscriber may need traffic the link does not carry yet
+			nh.advertiseSubscriptions(link, false)
+			return
+		}
+	}
+	key := pinned
+	if multiplexed {
+		if link, ok := nh.upstreamLinks[key]; ok {
+			if shared := nh.sharedLinkAttributes(); len(shared) > 0 {
+				nh.reregisterUpstreamLink(link, shared)
+				return
+			}
+			// the master does not accept the subscriptions of the subscriber over the link, it gets a link of its own
+			logger.L().Warning("local subscribers share no parent attributes, linking them to master separately", helpers.String("attributes", strutils.ObjectToString(att)))
+			key += strutils.ObjectToString(att)
+		}
+	} else {
+		key += strutils.ObjectToString(att)
 	}
-	parentURL, err := beClientV1.GetRootGatewayUrl(nh.rootGatewayURL)
+	link := newUpstreamLink(att, pinned)
+	nh.upstreamLinks[key] = link
+	nh.outgoingConnectionsMutex.Unlock()
+
+	nh.superviseUpstreamLink(link, key)
+}
+
+// reregisterUpstreamLink redials a multiplexed link so it registers with the attributes all the local subscribers share,
+// and the master accepts all their subscriptions. The links of the subscribers it did not carry before are stopped.
+// The caller must hold outgoingConnectionsMutex, it is released
+func (nh *Gateway) reregisterUpstreamLink(link *upstreamLink, shared map[string]string) {
+	link.setRegistration(shared)
+	stopped := []*upstreamLink{}
+	for key, other := range nh.upstreamLinks {
+		if other != link && other.pinned == link.pinned {
+			// the link stops once disconnected, it is not in the links anymore
+			delete(nh.upstreamLinks, key)
+			stopped = append(stopped, other)
+		}
+	}
+	nh.outgoingConnectionsMutex.Unlock()
+
+	nh.redialUpstreamLink(link)
+	for _, other := range stopped {
+		if conn, _ := other.connection(); conn != nil {
+			nh.wa.Close(conn)
+		}
+	}
+}
+
+// dialParent opens a websocket to a given master for given attributes, with the current credentials
+func (nh *Gateway) dialParent(parent string, att map[string]string) (*websocket.Conn, error) {
+	parentURL, err := beClientV1.GetRootGatewayUrl(parent)
 	if err != nil {
-		logger.L().Error(err.Error())
-		return
+		return nil, err
 	}
 
 	q := parentURL.Query()
@@ -128,149 +337,354 @@ func (nh *Gateway) connectToMaster(notificationAtt map[string]string, retry int)
 	parentURL.RawQuery = q.Encode()
 	logger.L().Info("connecting to master", helpers.String("url", parentURL.String()))
 
-	var accessKey string
-	if credentials, err := utils.LoadCredentialsFromFile("/etc/credentials"); err != nil {
-		logger.L().Error("failed to load credentials", helpers.Error(err))
-	} else {
-		accessKey = credentials.AccessKey
-		logger.L().Info("loaded credentials")
-		logger.L().Debug("access key length", helpers.Int("length", len(accessKey)))
-	}
+	accessKey := loadAccessKey(nh.currentConfig().Parent.CredentialsPath)
+	nh.configMutex.Lock()
+	nh.parentAccessKey = accessKey
+	nh.configMutex.Unlock()
 
 	// connect to master
 	conn, _, err := nh.wa.DefaultDialer(parentURL.String(), getRequestHeaders(accessKey))
-	if err != nil {
-		logger.L().Fatal("failed to connect to master", helpers.String("url", parentURL.String()), helpers.Error(err))
-	}
-	connObj, _ := nh.outgoingConnections.Append(att, conn)
-	nh.outgoingConnectionsMutex.Unlock()
-
-	logger.L().Info("successfully contented to master", helpers.Int("number of outgoing websockets", nh.outgoingConnections.Len()))
-
-	// read/write for keeping websocket connection alive
-	go func(pconnObj *websocketactions.Connection) {
-		for {
-			time.Sleep(10 * time.Second)
-			if err := nh.wa.WritePingMessage(pconnObj); err != nil {
-				logger.L().Warning("in WritePingMessage", helpers.Interface("attributes", att), helpers.Error(err))
-				pconnObj.Close()
-				return
-			}
-		}
-	}(connObj)
-
-	if err := nh.WebsocketReceiveNotification(connObj); err != nil {
-		logger.L().Warning("in connectToMaster", helpers.String("attributes", strutils.ObjectToString(att)), helpers.Error(err))
-	}
-
-	nh.wa.Close(connObj)
-	if retry < 2 {
-		logger.L().Warning("disconnected from master with connection", helpers.String("attributes", strutils.ObjectToString(att)), helpers.Int("retrying", retry+1))
-		nh.outgoingConnectionsMutex.Lock()
-		nh.outgoingConnections.Remove(notificationAtt)
-		nh.outgoingConnectionsMutex.Unlock()
-		nh.connectToMaster(notificationAtt, retry+1)
-	} else {
-		logger.L().Warning("disconnected from master with connection, removing connection from list", helpers.String("attributes", strutils.ObjectToString(att)))
-		nh.outgoingConnectionsMutex.Lock()
-		defer nh.outgoingConnectionsMutex.Unlock()
+	return conn, err
+}
 
-		nh.CleanupOutgoingConnection(att)
-		if nh.outgoingConnections.Len() == 0 && nh.incomingConnections.Len() > 0 {
-			logger.L().Fatal(fmt.Sprintf("failed to connect to parent: '%s'", strutils.ObjectToString(att)))
-		}
+// loadAccessKey loads the access key of the master from the credentials directory, empty if it cannot be loaded
+func loadAccessKey(credentialsPath string) string {
+	credentials, err := utils.LoadCredentialsFromFile(credentialsPath)
+	if err != nil {
+		logger.L().Error("failed to load credentials", helpers.Error(err))
+		return ""
 	}
+	logger.L().Info("loaded credentials")
+	logger.L().Debug("access key length", helpers.Int("length", len(credentials.AccessKey)))
+	return credentials.AccessKey
 }
 
-// RestAPINotificationHandler handles the notifications received over the REST API
+// RestAPINotificationHandler handles the notifications received over the REST API.
+// Failures are reported as an ErrorResponse: 400 for a malformed notification, 404 when nobody subscribed to the target in strict mode,
+// 502 when the notification could not be written to some of the connections, and 503 while shutting down
 func (nh *Gateway) RestAPINotificationHandler(w http.ResponseWriter, r *http.Request) {
+	id := requestID(w, r)
 	if r.Method != http.MethodPost {
-		logger.L().Error("Method not allowed. returning 405")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		logger.L().Error("Method not allowed. returning 405", helpers.String("requestID", id))
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+	if nh.shuttingDown.Load() {
+		writeError(w, r, http.StatusServiceUnavailable, &ErrorResponse{Code: ErrorCodeShuttingDown, Message: "gateway is shutting down"})
 		return
 	}
 
+	if maxBodySize := nh.currentConfig().Validation.MaxBodySize; maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxBodySize))
+	}
 	readBuffer, err := io.ReadAll(r.Body)
 	if err != nil {
-		logger.L().Error("In RestAPINotificationHandler ReadAll", helpers.Error(err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.L().Error("In RestAPINotificationHandler ReadAll", helpers.String("requestID", id), helpers.Error(err))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, &ErrorResponse{Code: ErrorCodeBodyTooLarge, Message: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)})
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: err.Error()})
 		return
 	}
 	defer r.Body.Close()
 
+	sender := ""
+	if nh.rateLimiter != nil {
+		sender = nh.senderOf(r)
+	}
+	result, e := nh.sendRestNotification(id, sender, readBuffer, nil)
+	if e != nil {
+		if e.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter(e.retryAfter)))
+		}
+		writeError(w, r, e.status, e.response)
+		return
+	}
+	byteResult, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(byteResult)
+}
+
+// sendRestNotification parses, validates and rate limits a notification received over the REST API in a given request, and sends it.
+// The prepared messages of a batch are reused by its identical notifications, nil prepares every notification.
+// Returns the result, or the failure to respond with
+func (nh *Gateway) sendRestNotification(id, sender string, body []byte, prepared *preparedMessages) (*SendResult, *restError) {
+	nh.metrics.notificationsReceived.WithLabelValues(NotificationSourceREST).Inc()
+
 	// get notificationID from message
-	notificationAtt, err := nh.UnmarshalMessage(readBuffer)
+	notificationAtt, err := nh.UnmarshalMessage(body)
 	if err != nil {
-		logger.L().Error("in RestAPINotificationHandler UnmarshalMessage", helpers.Error(err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		logger.L().Error("in sendRestNotification UnmarshalMessage", helpers.String("requestID", id), helpers.Error(err))
+		return nil, &restError{status: http.StatusBadRequest, response: &ErrorResponse{Code: ErrorCodeInvalidNotification, Message: "malformed notification", Violations: malformedNotification(err).Violations}}
 	}
-	logger.L().Info("in RestAPINotificationHandler", helpers.String("attributes", strutils.ObjectToString(notificationAtt.Target)))
-	if notificationAtt.Target == nil || len(notificationAtt.Target) == 0 {
-		logger.L().Error("in RestAPINotificationHandler received empty notificationAtt.Target")
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	logger.L().Info("in sendRestNotification", helpers.String("requestID", id), helpers.String("attributes", strutils.ObjectToString(notificationAtt.Target)))
+	if e := nh.validateNotification(notificationAtt); e != nil {
+		logger.L().Error("in sendRestNotification", helpers.String("requestID", id), helpers.Error(e))
+		return nil, &restError{status: http.StatusBadRequest, response: &ErrorResponse{Code: ErrorCodeInvalidNotification, Message: "invalid notification", Violations: e.Violations}}
+	}
+	if nh.rateLimiter != nil {
+		if limit, delay, ok := nh.rateLimiter.Allow(sender, notificationAtt.Target); !ok {
+			nh.metrics.notificationsRateLimited.WithLabelValues(NotificationSourceREST, limit).Inc()
+			logger.L().Warning("in sendRestNotification, rate limit exceeded", helpers.String("requestID", id), helpers.String("limit", limit), helpers.String("target", strutils.ObjectToString(notificationAtt.Target)))
+			return nil, &restError{status: http.StatusTooManyRequests, retryAfter: delay, response: &ErrorResponse{Code: ErrorCodeRateLimited, Message: fmt.Sprintf("%s rate limit exceeded", limit)}}
+		}
 	}
-	ids, err := nh.SendNotification(notificationAtt.Target, readBuffer, notificationAtt.SendSynchronicity)
+	if notificationAtt.scheduled() {
+		result, err := nh.scheduleNotification(notificationAtt, body)
+		if err != nil {
+			logger.L().Error("in sendRestNotification scheduleNotification", helpers.String("requestID", id), helpers.String("notificationID", result.NotificationID), helpers.Error(err))
+			if errors.Is(err, errAlreadyScheduled) {
+				return result, &restError{status: http.StatusConflict, response: &ErrorResponse{Code: ErrorCodeAlreadyScheduled, Message: err.Error(), NotificationID: result.NotificationID}}
+			}
+			return result, &restError{status: http.StatusServiceUnavailable, response: &ErrorResponse{Code: ErrorCodeSchedulerFull, Message: err.Error(), NotificationID: result.NotificationID}}
+		}
+		return result, nil
+	}
+	result, err := nh.routeNotification(notificationAtt, body, nil, false, prepared)
 	if err != nil {
-		logger.L().Error("in RestAPINotificationHandler SendNotification", helpers.String("target", strutils.ObjectToString(notificationAtt.Target)), helpers.Error(err))
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		logger.L().Error("in sendRestNotification SendNotification", helpers.String("requestID", id), helpers.String("notificationID", result.NotificationID), helpers.String("target", strutils.ObjectToString(notificationAtt.Target)), helpers.Error(err))
+		switch failures := result.failures(); {
+		case len(failures) > 0:
+			return result, &restError{status: http.StatusBadGateway, response: &ErrorResponse{Code: ErrorCodeDeliveryFailed, Message: fmt.Sprintf("failed to deliver the notification to %d of %d connections", len(failures), len(result.Connections)), NotificationID: result.NotificationID, Failures: failures}}
+		case errors.Is(err, errRequestPending):
+			return result, &restError{status: http.StatusConflict, response: &ErrorResponse{Code: ErrorCodeRequestPending, Message: err.Error(), NotificationID: result.NotificationID}}
+		default:
+			return result, &restError{status: http.StatusInternalServerError, response: &ErrorResponse{Code: ErrorCodeSendFailed, Message: err.Error(), NotificationID: result.NotificationID}}
+		}
 	}
-	byteIDs, _ := json.Marshal(ids)
-	w.Write(byteIDs)
-
+	if nh.currentConfig().Routing.Strict && result.unrouted() {
+		return result, &restError{status: http.StatusNotFound, response: &ErrorResponse{Code: ErrorCodeNoSubscribers, Message: "nobody subscribed to the target", NotificationID: result.NotificationID}}
+	}
+	return result, nil
 }
 
-// SendNotification sends a notification to its intended recipient
-func (nh *Gateway) SendNotification(route map[string]string, notification []byte, sendSynchronicity bool) ([]int, error) {
+// SendNotification sends a notification to its intended recipient.
+// The returned SendResult lists every matching connection and the outcome of the delivery to it
+func (nh *Gateway) SendNotification(n *Notification, notification []byte) (*SendResult, error) {
+	return nh.routeNotification(n, notification, nil, false, nil)
+}
 
-	ids := []int{}
+// routeNotification sends a notification received from a given connection, nil if it was received over the REST API.
+// In bidirectional routing mode the notification is not echoed to the connection it came from,
+// and a notification without local subscribers is forwarded to the parent gateway, unless it came from the parent.
+// A request, a notification with a correlation ID, is sent synchronously and the result collects the replies of the subscribers.
+// The notification is prepared by the given prepared messages, if any, so identical notifications share their websocket frames
+func (nh *Gateway) routeNotification(n *Notification, notification []byte, source *websocketactions.Connection, fromParent bool, prepared *preparedMessages) (*SendResult, error) {
+	route := n.Target
+	result := newSendResult()
+	if n.MessageID != "" {
+		result.NotificationID = n.MessageID
+	}
+	result.CorrelationID = n.CorrelationID
+	if nh.dedup != nil && n.MessageID == "" {
+		// stamp the message ID, so the copies of the notification are recognized all the way to the subscribers
+		stamped, err := stampMessage(notification, map[string]interface{}{"messageID": result.NotificationID})
+		if err != nil {
+			return result, fmt.Errorf("failed to stamp message ID, reason: %s", err.Error())
+		}
+		notification = stamped
+		n.MessageID = result.NotificationID
+	}
+	synchronous := n.SendSynchronicity || n.CorrelationID != ""
 	errMsgs := []string{}
-	connections := nh.incomingConnections.Get(route)
-	logger.L().Info("sending notification", helpers.Interface("target", strutils.ObjectToString(route)), helpers.Int("number of connections", len(connections)))
+	connections := nh.matchingConnections(route, n.Match)
+	if source != nil && nh.currentConfig().Routing.Mode == RoutingModeBidirectional {
+		connections = withoutConnection(connections, source)
+	}
+	connections = nh.withoutDuplicates(n.MessageID, connections, result)
+	logger.L().Info("sending notification", helpers.String("notificationID", result.NotificationID), helpers.Interface("target", strutils.ObjectToString(route)), helpers.Int("number of connections", len(connections)))
+	nh.metrics.fanOut.Observe(float64(len(connections)))
 	if len(connections) == 0 {
-		return ids, nil
+		if n.CorrelationID != "" || result.Duplicates > 0 {
+			// nobody would reply to a buffered or forwarded request, and the subscribers already received a repeated notification
+			return result, nil
+		}
+		if !fromParent && nh.routesUpstream() {
+			err := nh.forwardUpstream(n, notification)
+			if err == nil {
+				result.Forwarded = true
+				return result, nil
+			}
+			logger.L().Warning("failed to forward notification to the parent gateway", helpers.String("target", strutils.ObjectToString(route)), helpers.Error(err))
+		}
+		if nh.notificationBuffer != nil && n.Match == nil { // the buffer only matches exact targets
+			if err := nh.notificationBuffer.Push(route, notification); err != nil {
+				return result, fmt.Errorf("failed to buffer notification, reason: %s", err.Error())
+			}
+			result.Queued = true
+		}
+		return result, nil
 	}
-	preparedMessage, err := websocket.NewPreparedMessage(websocket.BinaryMessage, notification)
+	if n.RequireAck && n.MessageID == "" {
+		// stamp the message ID so the subscribers can acknowledge the notification
+		stamped, err := stampMessage(notification, map[string]interface{}{"messageID": result.NotificationID})
+		if err != nil {
+			return result, fmt.Errorf("failed to stamp message ID, reason: %s", err.Error())
+		}
+		notification = stamped
+	}
+	preparedMessage, err := prepared.prepare(notification)
 	if err != nil {
-		return ids, fmt.Errorf("failed to prepare message, reason: %s", err.Error())
+		nh.forgetDeliveries(n.MessageID, connections...)
+		return result, fmt.Errorf("failed to prepare message, reason: %s", err.Error())
 	}
-	for _, conn := range connections {
-		if sendSynchronicity {
-			if err := nh.sendSingleNotification(conn, preparedMessage, 0); err != nil {
-				errMsgs = append(errMsgs, err.Error())
+	message := &outboundNotification{raw: notification, prepared: preparedMessage}
+	var req *pendingRequest
+	if n.CorrelationID != "" {
+		ids := []int{}
+		for _, conn := range connections {
+			if !conn.IsStream() { // streams cannot reply
+				ids = append(ids, conn.ID)
+			}
+		}
+		// expect the replies before writing, a subscriber may reply right away
+		if req, err = nh.replies.Expect(n.CorrelationID, ids); err != nil {
+			nh.forgetDeliveries(n.MessageID, connections...)
+			return result, err
+		}
+	}
+	pending := []*pendingAck{}
+	tracked := make([]*pendingAck, len(connections))
+	written := make([]chan error, len(connections))
+	for i, conn := range connections {
+		if n.RequireAck && !conn.IsStream() { // streams cannot send acknowledgements
+			// track before writing, the subscriber may acknowledge right away
+			tracked[i] = nh.acks.Track(result.NotificationID, conn, notification, preparedMessage)
+		}
+		// a notification that was not written is not a duplicate when sent again
+		forgetFailed := func(err error) {
+			if err != nil {
+				nh.forgetDeliveries(n.MessageID, conn)
 			}
+		}
+		if synchronous {
+			ch := make(chan error, 1)
+			written[i] = ch
+			nh.enqueueNotification(conn, message, func(err error) {
+				forgetFailed(err)
+				ch <- err
+			})
 		} else {
-			go nh.sendSingleNotification(conn, preparedMessage, 0)
+			nh.enqueueNotification(conn, message, forgetFailed)
+			result.add(conn, DeliveryStatusAsync, nil)
+		}
+	}
+	if synchronous {
+		// the connections are written in parallel by their own writers
+		for i, conn := range connections {
+			if err := <-written[i]; err != nil {
+				errMsgs = append(errMsgs, err.Error())
+				result.add(conn, DeliveryStatusFailed, err)
+				if req != nil {
+					nh.replies.Drop(req, conn.ID)
+				}
+			} else {
+				result.add(conn, DeliveryStatusSent, nil)
+				if tracked[i] != nil {
+					pending = append(pending, tracked[i])
+				}
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		acked := nh.acks.Wait(pending, n.ackTimeout())
+		for i := range result.Connections {
+			if result.Connections[i].Status != DeliveryStatusSent {
+				continue
+			}
+			if acked[result.Connections[i].ID] {
+				result.Connections[i].Status = DeliveryStatusAcked
+			} else {
+				// the tracker keeps retrying in the background
+				result.Connections[i].Status = DeliveryStatusUnacked
+			}
+		}
+	}
+
+	if req != nil {
+		timeout := n.replyTimeout()
+		if fromParent {
+			if timeout <= 0 {
+				timeout = nh.replies.timeout
+			}
+			timeout = time.Duration(float64(timeout) * upstreamReplyShare)
 		}
+		nh.collectReplies(req, timeout, result)
 	}
 
 	if len(errMsgs) > 0 {
-		return ids, fmt.Errorf("%s", strings.Join(errMsgs, ";\n"))
+		return result, fmt.Errorf("%s", strings.Join(errMsgs, ";\n"))
 	}
-	return ids, nil
+	return result, nil
 }
 
-func (nh *Gateway) sendSingleNotification(conn *websocketactions.Connection, preparedMessage *websocket.PreparedMessage, retry int) error {
-	defer func() {
-		if err := recover(); err != nil {
-			if retry < 2 && strings.Contains(fmt.Sprintf("%v", err), "concurrent write to websocket connection") {
-				timeWait := time.Duration(rand.Intn(120)) * time.Millisecond
+// matchingConnections returns the subscribers matching a target and attribute expressions, nil for the exact target only
+func (nh *Gateway) matchingConnections(target map[string]string, match *websocketactions.Match) []*websocketactions.Connection {
+	if match == nil {
+		return nh.incomingConnections.Get(target)
+	}
+	// expressions cannot be looked up in the index, every connection is visited
+	connections := []*websocketactions.Connection{}
+	for _, conn := range nh.incomingConnections.List() {
+		if conn.Matches(target, match) {
+			connections = append(connections, conn)
+		}
+	}
+	return connections
+}
 
-				logger.L().Error("recover sendSingleNotification, connection is not alive", helpers.Int("id", conn.ID), helpers.Interface("reason", err), helpers.Int("retry", retry+1), helpers.String("retrying in", timeWait.String()))
-				time.Sleep(timeWait)
-				nh.sendSingleNotification(conn, preparedMessage, retry+1)
-			} else {
-				logger.L().Error("recover sendSingleNotification, connection is not alive", helpers.Int("id", conn.ID), helpers.Interface("reason", err))
-				nh.wa.Close(conn)
-			}
+// withoutConnection returns the given connections except a given one
+func withoutConnection(connections []*websocketactions.Connection, conn *websocketactions.Connection) []*websocketactions.Connection {
+	filtered := make([]*websocketactions.Connection, 0, len(connections))
+	for i := range connections {
+		if connections[i] != conn {
+			filtered = append(filtered, connections[i])
+		}
+	}
+	return filtered
+}
+
+// outboundNotification is a notification ready to be written to both websocket and stream connections
+type outboundNotification struct {
+	raw      []byte
+	prepared *websocketactions.PreparedMessage
+}
+
+// writeNotification writes a notification to a connection according to its transport
+func (nh *Gateway) writeNotification(conn *websocketactions.Connection, message *outboundNotification) error {
+	if conn.IsStream() {
+		return nh.wa.WriteBinaryMessage(conn, message.raw)
+	}
+	return nh.wa.WritePreparedMessage(conn, message.prepared)
+}
+
+// sendSingleNotification writes a notification to a connection. It is called by the writer of the connection's send queue.
+// A connection that fails a write, or does not read it within the write timeout, is closed
+func (nh *Gateway) sendSingleNotification(conn *websocketactions.Connection, message *outboundNotification) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			nh.metrics.panicsRecovered.Inc()
+			logger.L().Error("recover sendSingleNotification, connection is not alive", helpers.Int("id", conn.ID), helpers.Interface("reason", r))
+			nh.CleanupIncomingConnection(conn.ID)
+			nh.wa.Close(conn)
+			err = fmt.Errorf("in sendSingleNotification, connection %d is not alive, reason: %v", conn.ID, r)
 		}
 	}()
 	logger.L().Info("sending notification", helpers.String("attributes", strutils.ObjectToString(conn.GetAttributes())), helpers.Int("id", conn.ID))
-	err := nh.wa.WritePreparedMessage(conn, preparedMessage)
+	start := time.Now()
+	err = nh.writeNotification(conn, message)
+	nh.metrics.deliveryLatency.Observe(time.Since(start).Seconds())
 	if err != nil {
+		nh.metrics.writeErrors.Inc()
+		if isTimeout(err) {
+			nh.metrics.slowConsumersEvicted.WithLabelValues(evictionReasonWriteTimeout).Inc()
+		}
 		nh.CleanupIncomingConnection(conn.ID)
+		// a failed or timed out write leaves the connection unusable, closing it ends its reader
+		nh.wa.Close(conn)
 		e := fmt.Errorf("in sendSingleNotification %s, connection %d is not alive, error: %v", strutils.ObjectToString(conn.GetAttributes()), conn.ID, err)
 		logger.L().Error(e.Error())
 		return e
@@ -281,42 +695,53 @@ func (nh *Gateway) sendSingleNotification(conn *websocketactions.Connection, pre
 // AcceptWebsocketConnection accepts an incoming websocket connection
 func (nh *Gateway) AcceptWebsocketConnection(w http.ResponseWriter, r *http.Request) (*websocket.Conn, map[string]string, error) {
 
-	notificationAtt, err := nh.parseURLPath(r.URL)
+	notificationAtt, err := nh.subscriptionAttributes(r)
 	if err != nil {
 		return nil, notificationAtt, err
 	}
 
+	conn, err := nh.upgradeWebsocket(w, r)
+	return conn, notificationAtt, err
+}
+
+// upgradeWebsocket upgrades a request to a websocket, limited to the maximal frame size
+func (nh *Gateway) upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
 	conn, err := nh.wa.ConnectWebsocket(w, r)
 	if err != nil {
-		return conn, notificationAtt, err
+		return conn, err
 	}
-
-	return conn, notificationAtt, nil
-
+	if maxFrameSize := nh.currentConfig().Validation.MaxFrameSize; maxFrameSize > 0 {
+		conn.SetReadLimit(int64(maxFrameSize))
+	}
+	return conn, nil
 }
 
 // CleanupIncomingConnection cleans up an incoming connection with a given ID
 func (nh *Gateway) CleanupIncomingConnection(id int) {
 	// remove connection from list
 	nh.incomingConnections.RemoveID(id)
-}
-
-// CleanupOutgoingConnection cleans up an incoming connection with given notification attributes
-func (nh *Gateway) CleanupOutgoingConnection(notificationAtt map[string]string) {
-	// remove outgoing connection from list
-	nh.outgoingConnections.Remove(notificationAtt)
-
-	// close all incoming connections related to this attributes
-	logger.L().Info("Removing master connection. Removing all incoming connections", helpers.String("attributes", strutils.ObjectToString(notificationAtt)))
-	nh.incomingConnections.CloseConnections(nh.wa, notificationAtt)
+	// the notifications it did not acknowledge are not retried on a dead connection
+	nh.acks.DropConnection(id)
+	go nh.syncSubscriptions()
 }
 
 // WebsocketReceiveNotification maintains the websocket connection and receives notifications sent over it
 func (nh *Gateway) WebsocketReceiveNotification(connObj *websocketactions.Connection) error {
+	return nh.receiveNotifications(connObj, "")
+}
+
+// receiveNotifications maintains a websocket connection and routes the notifications sent over it.
+// parent is the URL of the master of a connection to the master, whose notifications are never forwarded back to it, empty otherwise
+func (nh *Gateway) receiveNotifications(connObj *websocketactions.Connection, parent string) error {
+	fromParent := parent != ""
 	// Websocket ping pong
 	for {
 		msgType, message, err := nh.wa.ReadMessage(connObj)
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// the connection already sent a 1009 (message too big) close frame
+				logger.L().Warning("websocket message exceeds the frame size limit, closing the connection", helpers.Int("id", connObj.ID))
+			}
 			return err
 		}
 		switch msgType {
@@ -335,18 +760,88 @@ func (nh *Gateway) WebsocketReceiveNotification(connObj *websocketactions.Connec
 			logger.L().Warning("unknown message type")
 			return nil
 		}
+		if messageID, ok := parseAckFrame(message); ok {
+			if !nh.acks.Ack(messageID, connObj.ID) {
+				logger.L().Debug("received acknowledgement of an unknown notification", helpers.String("messageID", messageID), helpers.Int("id", connObj.ID))
+			}
+			continue
+		}
+		if correlationID, reply, ok := parseReplyFrame(message); ok {
+			if !nh.replies.Reply(correlationID, connObj.ID, reply) {
+				logger.L().Debug("received reply to an unknown request", helpers.String("correlationID", correlationID), helpers.Int("id", connObj.ID))
+			}
+			continue
+		}
+		if !fromParent {
+			if frame, ok := parseSubscriptionFrame(message); ok {
+				nh.applySubscriptionFrame(connObj, frame)
+				continue
+			}
+		}
+		nh.metrics.notificationsReceived.WithLabelValues(NotificationSourceWebsocket).Inc()
 		// get notificationID from message
 		n, err := nh.UnmarshalMessage(message)
 		if err != nil {
 			logger.L().Error("in WebsocketReceiveNotification UnmarshalMessage", helpers.Error(err))
+			nh.wa.WriteCloseMessage(connObj, websocket.CloseInvalidFramePayloadData, closeReason(malformedNotification(err).Error()))
 			return fmt.Errorf("in WebsocketReceiveNotification UnmarshalMessage error: %v", err)
 		}
-		if n.Target == nil || len(n.Target) == 0 {
-			logger.L().Error("In WebsocketReceiveNotification received empty notification.Target")
-			return fmt.Errorf("in WebsocketReceiveNotification received empty notification.Target")
+		if e := nh.validateNotification(n); e != nil {
+			logger.L().Error("In WebsocketReceiveNotification", helpers.Error(e))
+			if fromParent {
+				// the master sends what its own senders sent, keep the link
+				continue
+			}
+			nh.wa.WriteCloseMessage(connObj, websocket.ClosePolicyViolation, closeReason(e.Error()))
+			return fmt.Errorf("in WebsocketReceiveNotification %s", e.Error())
+		}
+		if !fromParent {
+			// the master limits its own senders
+			if limit, _, ok := nh.rateLimiter.Allow(connObj.Sender(), n.Target); !ok {
+				nh.metrics.notificationsRateLimited.WithLabelValues(NotificationSourceWebsocket, limit).Inc()
+				logger.L().Warning("dropping notification, rate limit exceeded", helpers.String("limit", limit), helpers.Int("id", connObj.ID))
+				continue
+			}
+		}
+		if !fromParent && n.scheduled() {
+			if _, err := nh.scheduleNotification(n, message); err != nil {
+				logger.L().Warning("dropping scheduled notification", helpers.Int("id", connObj.ID), helpers.Error(err))
+			}
+			continue
+		}
+		if fromParent && nh.currentConfig().Parent.Mode == ParentModeActiveActive && nh.duplicateFromParent(parent, n, message) {
+			logger.L().Debug("dropping notification already received from another master", helpers.String("parent", parent), helpers.Int("id", connObj.ID))
+			continue
+		}
+		if n.CorrelationID != "" {
+			// wait for the replies in the background, so the connection keeps being read
+			nh.pendingWrites.add()
+			go func(n *Notification, message []byte) {
+				defer nh.pendingWrites.done()
+				result, err := nh.routeNotification(n, message, connObj, fromParent, nil)
+				if err != nil {
+					logger.L().Error("In WebsocketReceiveNotification SendNotification", helpers.Error(err))
+				}
+				nh.replyToRequester(connObj, n.CorrelationID, result)
+			}(n, message)
+			continue
+		}
+		if n.RequireAck {
+			// wait for the subscribers to acknowledge in the background, so the connection keeps being read
+			nh.pendingWrites.add()
+			go func(n *Notification, message []byte) {
+				defer nh.pendingWrites.done()
+				n.SendSynchronicity = true
+				result, err := nh.routeNotification(n, message, connObj, fromParent, nil)
+				if err != nil {
+					logger.L().Error("In WebsocketReceiveNotification SendNotification", helpers.Error(err))
+				}
+				nh.ackUpstream(connObj, result)
+			}(n, message)
+			continue
 		}
 		// send message
-		if _, err := nh.SendNotification(n.Target, message, n.SendSynchronicity); err != nil {
+		if _, err := nh.routeNotification(n, message, connObj, fromParent, nil); err != nil {
 			logger.L().Error("In WebsocketReceiveNotification SendNotification", helpers.Error(err))
 			return fmt.Errorf("in WebsocketReceiveNotification SendNotification error: %v", err)
 		}
@@ -369,6 +864,16 @@ func (nh *Gateway) parseURLPath(u *url.URL) (map[string]string, error) {
 	return att, nil
 }
 
+// subscriptionAttributes returns the attributes a subscription request subscribes to,
+// the query attributes completed by the attributes of its client certificate
+func (nh *Gateway) subscriptionAttributes(r *http.Request) (map[string]string, error) {
+	att, err := nh.parseURLPath(r.URL)
+	if err != nil {
+		return att, err
+	}
+	return nh.serverTLS.applyClientAttributes(r, att)
+}
+
 // UnmarshalMessage attempts to unmarshal a given message into either a JSON or BSON format
 func (nh *Gateway) UnmarshalMessage(message []byte) (*Notification, error) {
 	n := &Notification{}
@@ -384,22 +889,19 @@ func (nh *Gateway) UnmarshalMessage(message []byte) (*Notification, error) {
 
 // hasParent does the parent host is set
 func (nh *Gateway) hasParent() bool {
-	return nh.rootGatewayURL == ""
+	return len(nh.parentURLs()) == 0
 }
 
-// getRootGwUrl return the parent host URL. According to the following order: env var, service discovery, config file
-func getRootGwUrl() string {
-	if envVarValue := os.Getenv(ParentGatewayHostEnvironmentVariable); envVarValue != "" {
-		logger.L().Info("loaded gw url from env var", helpers.String("url", envVarValue))
-		return envVarValue
-	}
-
-	services, err := servicediscovery.GetServices(v2.NewServiceDiscoveryFileV2(serviceDiscoveryConfigPath))
-	if err != nil {
-		logger.L().Warning(err.Error())
-	}
-	url := services.GetGatewayUrl()
-	logger.L().Info("loaded gw url (service discovery)", helpers.String("url", url))
+// parentURLs returns the URLs of the masters in order of preference, none if there is none
+func (nh *Gateway) parentURLs() []string {
+	nh.configMutex.RLock()
+	defer nh.configMutex.RUnlock()
+	return nh.parents
+}
 
-	return url
+// currentConfig returns the configuration the gateway currently runs with
+func (nh *Gateway) currentConfig() *Config {
+	nh.configMutex.RLock()
+	defer nh.configMutex.RUnlock()
+	return nh.config
 }
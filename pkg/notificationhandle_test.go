@@ -4,32 +4,62 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	notifier "github.com/armosec/cluster-notifier-api-go/notificationserver"
+	"github.com/gorilla/websocket"
 	"github.com/kubescape/gateway/pkg/websocketactions"
 	"github.com/stretchr/testify/assert"
 )
 
 // NewNotificationServerMasterMock -
 func NewNotificationServerMasterMock() *Gateway {
+	outgoingConnections := NewRouter()
+	incomingConnections := NewRouter()
 	return &Gateway{
-		wa:                  &websocketactions.WebsocketActionsMock{},
-		outgoingConnections: *NewConnectionsObj(),
-		incomingConnections: *NewConnectionsObj(),
+		wa:                       &websocketactions.WebsocketActionsMock{},
+		outgoingConnections:      outgoingConnections,
+		incomingConnections:      incomingConnections,
+		outgoingConnectionsMutex: &sync.Mutex{},
+		upstreamLinks:            map[string]*upstreamLink{},
+		reconnectBackoff:         NewBackoff(),
+		acks:                     NewAckTracker(&websocketactions.WebsocketActionsMock{}, time.Second, time.Second, 0, LogDeadLetterSink{}),
+		replies:                  NewReplyTracker(time.Second),
+		duplicates:               NewDedupCache(parentDuplicatesWindow, defaultDedupSize),
+		metrics:                  NewMetrics(incomingConnections, outgoingConnections, []string{"customer"}),
+		config:                   DefaultConfig(),
 	}
 }
 
 // NewNotificationServerEdgeMock -
 func NewNotificationServerEdgeMock() *Gateway {
+	outgoingConnections := NewRouter()
+	incomingConnections := NewRouter()
 	return &Gateway{
-		wa:                  &websocketactions.WebsocketActionsMock{},
-		outgoingConnections: *NewConnectionsObj(),
-		incomingConnections: *NewConnectionsObj(),
+		wa:                       &websocketactions.WebsocketActionsMock{},
+		outgoingConnections:      outgoingConnections,
+		incomingConnections:      incomingConnections,
+		outgoingConnectionsMutex: &sync.Mutex{},
+		upstreamLinks:            map[string]*upstreamLink{},
+		reconnectBackoff:         NewBackoff(),
+		acks:                     NewAckTracker(&websocketactions.WebsocketActionsMock{}, time.Second, time.Second, 0, LogDeadLetterSink{}),
+		replies:                  NewReplyTracker(time.Second),
+		duplicates:               NewDedupCache(parentDuplicatesWindow, defaultDedupSize),
+		metrics:                  NewMetrics(incomingConnections, outgoingConnections, []string{"customer"}),
+		config:                   DefaultConfig(),
 	}
 }
 
+// NotificationMock -
+func NotificationMock(target map[string]string, sendSynchronicity bool) *Notification {
+	return &Notification{Notification: notifier.Notification{Target: target, SendSynchronicity: sendSynchronicity}}
+}
+
 func HTTPRequestMock() *http.Request {
 	r := &http.Request{}
 	r.Method = http.MethodGet
@@ -47,3 +77,73 @@ func TestParseURLQuery(t *testing.T) {
 	assert.Equal(t, att[notifier.TargetCustomer], "test")
 	assert.Equal(t, att["cluster"], "kube")
 }
+
+func TestSendNotification(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	_, id := ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+
+	result, err := ns.SendNotification(NotificationMock(map[string]string{"customer": "test"}, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.NotificationID)
+	assert.Equal(t, 1, len(result.Connections))
+	assert.Equal(t, id, result.Connections[0].ID)
+	assert.Equal(t, ATTRIBUTES_MOCK, result.Connections[0].Attributes)
+	assert.Equal(t, DeliveryStatusSent, result.Connections[0].Status)
+
+	result, err = ns.SendNotification(NotificationMock(map[string]string{"customer": "test"}, false), []byte("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Connections))
+	assert.Equal(t, DeliveryStatusAsync, result.Connections[0].Status)
+
+	result, err = ns.SendNotification(NotificationMock(map[string]string{"customer": "other"}, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.NotificationID)
+	assert.Equal(t, 0, len(result.Connections))
+}
+
+func TestSendNotificationMatch(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.notificationBuffer = NewMemoryNotificationBuffer(time.Minute, 10)
+	_, prodEU := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "prod-eu"}, &websocket.Conn{}, nil)
+	_, prodUS := ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "prod-us"}, &websocket.Conn{}, nil)
+	ns.incomingConnections.Append(map[string]string{"customer": "test", "cluster": "dev"}, &websocket.Conn{}, nil)
+
+	n := NotificationMock(map[string]string{"customer": "test"}, true)
+	n.Match = &websocketactions.Match{Expressions: []websocketactions.Expression{{Key: "cluster", Operator: websocketactions.OperatorEquals, Values: []string{"prod-*"}}}}
+	result, err := ns.SendNotification(n, []byte("{}"))
+	assert.NoError(t, err)
+	ids := []int{}
+	for _, delivery := range result.Connections {
+		ids = append(ids, delivery.ID)
+	}
+	assert.ElementsMatch(t, []int{prodEU, prodUS}, ids)
+
+	n = NotificationMock(nil, true)
+	n.Match = &websocketactions.Match{Expressions: []websocketactions.Expression{{Key: "cluster", Operator: websocketactions.OperatorIn, Values: []string{"staging"}}}}
+	result, err = ns.SendNotification(n, []byte("{}"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(result.Connections))
+	assert.False(t, result.Queued, "the buffer only matches exact targets")
+}
+
+func TestRestAPINotificationHandlerTarget(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "target", body: `{"target":{"customer":"test"}}`, want: http.StatusOK},
+		{name: "empty target", body: `{"target":{}}`, want: http.StatusBadRequest},
+		{name: "match without target", body: `{"match":{"expressions":[{"key":"cluster","operator":"!=","values":["dev"]}]}}`, want: http.StatusOK},
+		{name: "invalid match", body: `{"target":{"customer":"test"},"match":{"expressions":[{"key":"cluster","operator":"~","values":["dev"]}]}}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ns.RestAPINotificationHandler(w, httptest.NewRequest(http.MethodPost, "/v1/sendnotification", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+}
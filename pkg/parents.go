@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+const (
+	// ParentModeFailover links to the first reachable parent in order of preference, and fails back once a preferred parent is reachable again
+	ParentModeFailover = "failover"
+	// ParentModeActiveActive links to all the parents at once, and drops the notifications received from more than one of them
+	ParentModeActiveActive = "active-active"
+
+	defaultHealthCheckInterval = 30 * time.Second
+	// parentDuplicatesWindow is how long a notification received from a parent is remembered, in active-active mode
+	parentDuplicatesWindow = time.Minute
+)
+
+// isParent reports whether a given URL is one of the masters. An empty URL stands for any of them
+func (nh *Gateway) isParent(parent string) bool {
+	if parent == "" {
+		return true
+	}
+	for _, p := range nh.parentURLs() {
+		if p == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// isPreferredParent reports whether a given URL is the first master in order of preference
+func (nh *Gateway) isPreferredParent(parent string) bool {
+	parents := nh.parentURLs()
+	return len(parents) > 0 && parents[0] == parent
+}
+
+// dialLinkParent opens a websocket for a link, to its pinned master or else to the first master that accepts it, in order of preference.
+// Returns the URL of the master
+func (nh *Gateway) dialLinkParent(link *upstreamLink) (*websocket.Conn, string, error) {
+	parents := []string{link.pinned}
+	if link.pinned == "" {
+		parents = nh.parentURLs()
+	}
+	errs := []string{}
+	for _, parent := range parents {
+		link.setParent(parent)
+		conn, err := nh.dialParent(parent, link.registration())
+		if err == nil {
+			return conn, parent, nil
+		}
+		if len(parents) > 1 {
+			logger.L().Warning("failed to connect to master, trying the next one", helpers.String("parent", parent), helpers.Error(err))
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", parent, err.Error()))
+	}
+	return nil, "", fmt.Errorf("failed to connect to any master, reason: %s", strings.Join(errs, ", "))
+}
+
+// failBack probes the masters a failed over link prefers to the master it is connected to, every health check interval,
+// and moves the link to the first of them that accepts it. It stops once the connection is closed
+func (nh *Gateway) failBack(link *upstreamLink, current *websocketactions.Connection) {
+	for {
+		time.Sleep(time.Duration(nh.currentConfig().Parent.HealthCheckInterval))
+		conn, parent := link.connection()
+		if conn != current || nh.shuttingDown.Load() {
+			return
+		}
+		for _, preferred := range nh.parentURLs() {
+			if preferred == parent {
+				break
+			}
+			ws, err := nh.dialParent(preferred, link.registration())
+			if err != nil {
+				logger.L().Debug("preferred master is still unreachable", helpers.String("parent", preferred), helpers.Error(err))
+				continue
+			}
+			if nh.replaceUpstreamConnection(link, current, ws, preferred) {
+				logger.L().Info("failed back to preferred master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.String("parent", preferred))
+			}
+			return
+		}
+	}
+}
+
+// ParentStatus summarizes the links to one of the parent gateways
+type ParentStatus struct {
+	URL string `json:"url"`
+	// Links is the number of links connected or trying to connect to the parent
+	Links int `json:"links"`
+	// Connected is the number of links connected to the parent
+	Connected int `json:"connected"`
+}
+
+// parentStatuses summarizes given link statuses by parent, in order of preference
+func (nh *Gateway) parentStatuses(statuses []UpstreamLinkStatus) []ParentStatus {
+	parents := nh.parentURLs()
+	summaries := make([]ParentStatus, len(parents))
+	for i, parent := range parents {
+		summaries[i].URL = parent
+		for j := range statuses {
+			if statuses[j].Parent != parent {
+				continue
+			}
+			summaries[i].Links++
+			if statuses[j].State == LinkStateConnected {
+				summaries[i].Connected++
+			}
+		}
+	}
+	return summaries
+}
+
+// connectionParent returns the URL of the master of an outgoing connection with a given ID, empty if it is not the connection of a link
+func (nh *Gateway) connectionParent(id int) string {
+	nh.outgoingConnectionsMutex.Lock()
+	defer nh.outgoingConnectionsMutex.Unlock()
+	for _, link := range nh.upstreamLinks {
+		if conn, parent := link.connection(); conn != nil && conn.ID == id {
+			return parent
+		}
+	}
+	return ""
+}
+
+// duplicateFromParent reports whether a notification was received from another master within the window, in active-active mode.
+// Notifications are identified by their message ID, or by their content if they have none.
+// A notification sent again by the same master is delivered again, as it is not a copy
+func (nh *Gateway) duplicateFromParent(parent string, n *Notification, message []byte) bool {
+	key := n.MessageID
+	if key == "" {
+		digest := sha256.Sum256(message)
+		key = string(digest[:])
+	}
+	previous, seen := nh.duplicates.Remember(key, parent)
+	if seen && previous != parent {
+		nh.metrics.notificationsDeduplicated.WithLabelValues(DedupReasonParent).Inc()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+// parentGatewayMock serves a root gateway, refusing the websockets while down is set
+func parentGatewayMock(t *testing.T, down *atomic.Bool) (*Gateway, string) {
+	ns := NewNotificationServerMasterMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down != nil && down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		ns.WebsocketNotificationHandler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return ns, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// edgeGatewayMock serves an edge gateway linked to given parents in a given mode
+func edgeGatewayMock(t *testing.T, mode string, parents ...string) (*Gateway, string) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.config.Parent.Mode = mode
+	ns.config.Parent.HealthCheckInterval = Duration(10 * time.Millisecond)
+	ns.config.Routing.ParentAttributes = []string{"customer"}
+	ns.parents = parents
+	ns.reconnectBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	t.Cleanup(server.Close)
+	return ns, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// connectedParents returns the parents of the connected links of a gateway
+func connectedParents(ns *Gateway) []string {
+	parents := []string{}
+	for _, status := range ns.UpstreamStatus() {
+		if status.State == LinkStateConnected {
+			parents = append(parents, status.Parent)
+		}
+	}
+	return parents
+}
+
+func TestParentFailover(t *testing.T) {
+	unsetParentURLMock(t)
+	down := &atomic.Bool{}
+	down.Store(true)
+	preferred, preferredURL := parentGatewayMock(t, down)
+	fallback, fallbackURL := parentGatewayMock(t, nil)
+	edge, edgeURL := edgeGatewayMock(t, ParentModeFailover, preferredURL, fallbackURL)
+
+	subscriber, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer=test&cluster=a", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriber.Close()
+	assert.Eventually(t, func() bool {
+		parents := connectedParents(edge)
+		return len(parents) == 1 && parents[0] == fallbackURL
+	}, 5*time.Second, time.Millisecond, "the link fails over to the next parent")
+	assert.Equal(t, []ParentStatus{{URL: preferredURL}, {URL: fallbackURL, Links: 1, Connected: 1}}, edge.parentStatuses(edge.UpstreamStatus()))
+	conns := edge.outgoingConnections.List()
+	if assert.Equal(t, 1, len(conns)) {
+		assert.Equal(t, fallbackURL, edge.newConnectionInfo(conns[0], ConnectionDirectionOutgoing).Parent)
+	}
+
+	down.Store(false)
+	assert.Eventually(t, func() bool {
+		parents := connectedParents(edge)
+		return len(parents) == 1 && parents[0] == preferredURL && preferred.incomingConnections.Len() == 1 && fallback.incomingConnections.Len() == 0
+	}, 5*time.Second, time.Millisecond, "the link fails back once the preferred parent is healthy")
+}
+
+func TestParentActiveActive(t *testing.T) {
+	unsetParentURLMock(t)
+	first, firstURL := parentGatewayMock(t, nil)
+	second, secondURL := parentGatewayMock(t, nil)
+	edge, edgeURL := edgeGatewayMock(t, ParentModeActiveActive, firstURL, secondURL)
+
+	subscriber, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer=test&cluster=a", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriber.Close()
+	assert.Eventually(t, func() bool {
+		return len(connectedParents(edge)) == 2 && first.incomingConnections.Len() == 1 && second.incomingConnections.Len() == 1
+	}, 5*time.Second, time.Millisecond, "the edge links to every parent")
+
+	w := httptest.NewRecorder()
+	edge.HealthHandler(w, httptest.NewRequest(http.MethodGet, PathHealthV1, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	health := struct {
+		Parents []ParentStatus `json:"parents"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, []ParentStatus{{URL: firstURL, Links: 1, Connected: 1}, {URL: secondURL, Links: 1, Connected: 1}}, health.Parents)
+
+	// both parents send the same notification, the subscriber receives it once
+	message := []byte(`{"target":{"customer":"test"},"notification":"scan"}`)
+	for _, parent := range []*Gateway{first, second} {
+		result, err := parent.SendNotification(NotificationMock(map[string]string{"customer": "test"}, true), message)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(result.Connections))
+	}
+	_, err = second.SendNotification(NotificationMock(map[string]string{"customer": "test"}, true), []byte(`{"target":{"customer":"test"},"notification":"other"}`))
+	assert.NoError(t, err)
+
+	subscriber.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, want := range []string{`"notification":"scan"`, `"notification":"other"`} {
+		_, received, err := subscriber.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Contains(t, string(received), want)
+	}
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RateLimitSender the limit of every sender, identified by its credential or else its address
+	RateLimitSender = "sender"
+	// RateLimitTarget the limit of every value of the target attributes, e.g. of every customer
+	RateLimitTarget = "target"
+	// rateLimiterSweepInterval is how often the buckets that filled up again are forgotten
+	rateLimiterSweepInterval = time.Minute
+)
+
+// RateLimiter limits the notifications of every sender and to every target attribute value with token buckets
+type RateLimiter struct {
+	cfg   RateLimitsConfig
+	mutex sync.Mutex
+	// buckets are keyed by the limit and the sender or the target attribute value
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a RateLimiter enforcing the given limits, a limit whose rate is 0 is disabled
+func NewRateLimiter(cfg RateLimitsConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:       cfg,
+		buckets:   map[string]*rate.Limiter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// newRateLimiter creates the configured RateLimiter, nil if no limit is set
+func newRateLimiter(cfg RateLimitsConfig) *RateLimiter {
+	if cfg.Sender.Rate <= 0 && cfg.Target.Rate <= 0 {
+		return nil
+	}
+	return NewRateLimiter(cfg)
+}
+
+// Allow takes a token from the bucket of the sender and from the bucket of every target attribute value.
+// When one of them is empty, no token is taken, and Allow returns the limit that was hit and how long to wait before sending again.
+// A nil RateLimiter allows everything
+func (l *RateLimiter) Allow(sender string, target map[string]string) (string, time.Duration, bool) {
+	if l == nil {
+		return "", 0, true
+	}
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	reservations := make([]*rate.Reservation, 0, 1+len(l.cfg.TargetAttributes))
+	// reserve takes a token from a bucket, or returns how long to wait for it
+	reserve := func(key string, cfg RateLimit) time.Duration {
+		reservation := l.bucket(key, cfg).ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			return delay
+		}
+		reservations = append(reservations, reservation)
+		return 0
+	}
+
+	if l.cfg.Sender.Rate > 0 {
+		if delay := reserve(RateLimitSender+"/"+sender, l.cfg.Sender); delay > 0 {
+			return RateLimitSender, delay, false
+		}
+	}
+	if l.cfg.Target.Rate > 0 {
+		for _, key := range l.cfg.TargetAttributes {
+			value, ok := target[key]
+			if !ok || value == "" {
+				continue
+			}
+			if delay := reserve(RateLimitTarget+"/"+key+"="+value, l.cfg.Target); delay > 0 {
+				// give back the tokens taken from the other buckets
+				for _, reservation := range reservations {
+					reservation.CancelAt(now)
+				}
+				return RateLimitTarget, delay, false
+			}
+		}
+	}
+	return "", 0, true
+}
+
+// bucket returns the bucket of a given key, creating it full if there is none. Must be called with the mutex locked
+func (l *RateLimiter) bucket(key string, cfg RateLimit) *rate.Limiter {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = rate.NewLimiter(rate.Limit(cfg.Rate), cfg.burst())
+		l.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// sweep forgets the buckets that filled up again, which behave the same as new ones. Must be called with the mutex locked
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.TokensAt(now) >= float64(bucket.Burst()) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of buckets
+func (l *RateLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
+
+// burst returns the size of the buckets of a limit, the rate rounded up if not set
+func (cfg RateLimit) burst() int {
+	if cfg.Burst > 0 {
+		return cfg.Burst
+	}
+	return int(math.Max(1, math.Ceil(cfg.Rate)))
+}
+
+// retryAfter converts the wait before sending again to the seconds of a Retry-After header, at least a second
+func retryAfter(delay time.Duration) int {
+	return int(math.Max(1, math.Ceil(delay.Seconds())))
+}
+
+// senderOf identifies the sender of a request by the credential it carries if it is valid, by its address otherwise
+func (nh *Gateway) senderOf(r *http.Request) string {
+	if nh.authenticator != nil {
+		if principal, err := nh.authenticator.Authenticate(r); err == nil {
+			return "credential:" + principal.Name
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "address:" + host
+}
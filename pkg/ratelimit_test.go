@@ -0,0 +1,110 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(RateLimitsConfig{
+		Sender:           RateLimit{Rate: 1, Burst: 2},
+		Target:           RateLimit{Rate: 0.5},
+		TargetAttributes: []string{"customer"},
+	})
+	_, _, ok := l.Allow("a", map[string]string{"customer": "x"})
+	assert.True(t, ok)
+	limit, delay, ok := l.Allow("a", map[string]string{"customer": "x"})
+	assert.False(t, ok)
+	assert.Equal(t, RateLimitTarget, limit, "the burst of the target defaults to its rate rounded up")
+	assert.InDelta(t, 2*time.Second, delay, float64(100*time.Millisecond))
+
+	// the sender token of the rejected notification was given back
+	_, _, ok = l.Allow("a", map[string]string{"customer": "y"})
+	assert.True(t, ok)
+	limit, delay, ok = l.Allow("a", map[string]string{"customer": "z"})
+	assert.False(t, ok)
+	assert.Equal(t, RateLimitSender, limit)
+	assert.InDelta(t, time.Second, delay, float64(100*time.Millisecond))
+
+	_, _, ok = l.Allow("b", map[string]string{"cluster": "x"})
+	assert.True(t, ok, "other senders and target keys are not limited")
+
+	var disabled *RateLimiter
+	_, _, ok = disabled.Allow("a", nil)
+	assert.True(t, ok)
+	assert.Nil(t, newRateLimiter(RateLimitsConfig{TargetAttributes: []string{"customer"}}))
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(RateLimitsConfig{Sender: RateLimit{Rate: 1000}})
+	l.Allow("a", nil)
+	l.Allow("b", nil)
+	assert.Equal(t, 2, l.Len())
+	time.Sleep(10 * time.Millisecond)
+	l.lastSweep = time.Now().Add(-rateLimiterSweepInterval)
+	l.Allow("c", nil)
+	assert.Equal(t, 1, l.Len(), "the buckets that filled up again are forgotten")
+}
+
+func TestRestAPIRateLimit(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.rateLimiter = NewRateLimiter(RateLimitsConfig{Sender: RateLimit{Rate: 0.1}})
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/sendnotification", bytes.NewBufferString(`{"target":{"customer":"test"}}`))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		ns.RestAPINotificationHandler(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1:1234").Code)
+	w := send("10.0.0.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, send("10.0.0.2:1234").Code, "senders are limited separately")
+	assert.Contains(t, scrapeMock(t, ns.metrics), `gateway_notifications_rate_limited_total{limit="sender",source="rest"} 1`)
+}
+
+func TestWebsocketRateLimit(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.rateLimiter = NewRateLimiter(RateLimitsConfig{Target: RateLimit{Rate: 0.1}, TargetAttributes: []string{"customer"}})
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	subscriber, _, err := websocket.DefaultDialer.Dial(url+"?customer=test", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriber.Close()
+	sender, _, err := websocket.DefaultDialer.Dial(url+"?customer=sender", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sender.Close()
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 2 }, time.Second, time.Millisecond)
+
+	for _, message := range []string{"first", "second"} {
+		assert.NoError(t, sender.WriteMessage(websocket.TextMessage, []byte(`{"target":{"customer":"test"},"notification":"`+message+`"}`)))
+	}
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrapeMock(t, ns.metrics), `gateway_notifications_rate_limited_total{limit="target",source="websocket"} 1`)
+	}, time.Second, 10*time.Millisecond, "the second notification is dropped")
+	subscriber.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := subscriber.ReadMessage()
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(message), "first")
+	}
+	subscriber.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = subscriber.ReadMessage()
+	assert.Error(t, err, "the sender connection is kept open, the notification is dropped")
+}
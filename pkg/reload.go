@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/kubescape/backend/pkg/utils"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+// Reload loads the configuration again, and applies changed URLs, credentials path or access key of the masters without dropping the local subscribers:
+// the links to the master connect again and swap their connections, the incoming connections are left untouched.
+// The other settings are read once on startup, changing them requires a restart
+func (nh *Gateway) Reload() error {
+	current := nh.currentConfig()
+	cfg, err := LoadConfig(current.path)
+	if err != nil {
+		return err
+	}
+
+	restartRequired := *cfg
+	restartRequired.Parent.URL = current.Parent.URL
+	restartRequired.Parent.URLs = current.Parent.URLs
+	restartRequired.RootGatewayURL = current.RootGatewayURL
+	restartRequired.Parent.CredentialsPath = current.Parent.CredentialsPath
+	if !reflect.DeepEqual(&restartRequired, current) {
+		logger.L().Warning("config changed, only the parent URLs and credentials are reloaded, the other settings require a restart")
+	}
+	applied := *current
+	applied.Parent.URL = cfg.Parent.URL
+	applied.Parent.URLs = cfg.Parent.URLs
+	applied.RootGatewayURL = cfg.RootGatewayURL
+	applied.Parent.CredentialsPath = cfg.Parent.CredentialsPath
+	parents := cfg.Parent.parentURLs()
+
+	var accessKey string
+	if len(parents) > 0 {
+		accessKey = loadAccessKey(cfg.Parent.CredentialsPath)
+	}
+
+	nh.configMutex.Lock()
+	previous := nh.parents
+	accessKeyChanged := accessKey != nh.parentAccessKey
+	nh.config = &applied
+	nh.parents = parents
+	nh.configMutex.Unlock()
+
+	switch {
+	case len(parents) == 0 && len(previous) > 0:
+		logger.L().Info("master was removed from the config, closing the connections to master")
+		nh.closeUpstreamLinks()
+	case len(parents) > 0 && len(previous) == 0:
+		logger.L().Info("master was added to the config, connecting the subscribers to master", helpers.String("urls", strings.Join(parents, ",")))
+		nh.connectSubscribersToMaster()
+	case !reflect.DeepEqual(parents, previous):
+		logger.L().Info("master URLs changed, reconnecting to master", helpers.String("urls", strings.Join(parents, ",")))
+		nh.redialUpstreamLinks()
+		if applied.Parent.Mode == ParentModeActiveActive {
+			// links to the added masters
+			nh.connectSubscribersToMaster()
+		}
+	case accessKeyChanged:
+		logger.L().Info("credentials changed, reconnecting to master")
+		nh.redialUpstreamLinks()
+	}
+	return nil
+}
+
+// connectSubscribersToMaster links the current subscribers to the master, the links that exist already are kept
+func (nh *Gateway) connectSubscribersToMaster() {
+	for _, conn := range nh.incomingConnections.List() {
+		go nh.connectToMaster(conn.GetAttributes())
+	}
+}
+
+// WatchConfig reloads the configuration whenever the config file, the credentials or the service discovery file change, until stop is closed.
+// The credentials are watched again at their new path when it was changed
+func (nh *Gateway) WatchConfig(stop <-chan struct{}) error {
+	for {
+		files := nh.configFiles()
+		// rearm stops watching the current files once the credentials moved, so the new ones are watched
+		rearm := false
+		moved := make(chan struct{})
+		stopWatching := sync.OnceFunc(func() { close(moved) })
+		watching := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+			case <-moved:
+			}
+			close(watching)
+		}()
+		err := watchFiles(files, watching, func() {
+			if err := nh.Reload(); err != nil {
+				logger.L().Warning("failed to reload config, keeping the current one", helpers.Error(err))
+				return
+			}
+			if !reflect.DeepEqual(nh.configFiles(), files) {
+				rearm = true
+				stopWatching()
+			}
+		})
+		stopWatching()
+		<-watching
+		if err != nil || !rearm {
+			return err
+		}
+		logger.L().Info("credentials path changed, watching the new credentials", helpers.String("path", nh.currentConfig().Parent.CredentialsPath))
+	}
+}
+
+// configFiles returns the files the configuration is loaded from
+func (nh *Gateway) configFiles() []string {
+	cfg := nh.currentConfig()
+	files := []string{cfg.path, cfg.Parent.ServiceDiscoveryPath}
+	if cfg.Parent.CredentialsPath != "" {
+		// the credentials path is a directory holding a file per key
+		files = append(files, filepath.Join(cfg.Parent.CredentialsPath, utils.AccessKeySecretKey))
+	}
+	return files
+}
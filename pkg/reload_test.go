@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	beServerV1 "github.com/kubescape/backend/pkg/server/v1"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+// parentMock is a master that records the access keys of the edges connecting to it
+type parentMock struct {
+	mutex      sync.Mutex
+	accessKeys []string
+	open       int
+}
+
+func (pm *parentMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	pm.mutex.Lock()
+	pm.accessKeys = append(pm.accessKeys, r.Header.Get(beServerV1.AccessKeyHeader))
+	pm.open++
+	pm.mutex.Unlock()
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	pm.mutex.Lock()
+	pm.open--
+	pm.mutex.Unlock()
+}
+
+func (pm *parentMock) connections() ([]string, int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return append([]string{}, pm.accessKeys...), pm.open
+}
+
+func writeParentConfigMock(t *testing.T, path, parentURL, credentialsPath string) {
+	content := fmt.Sprintf("parent:\n  url: %q\n  credentialsPath: %s\n  serviceDiscoveryPath: %s\n", parentURL, credentialsPath, filepath.Join(credentialsPath, "missing.json"))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestReload(t *testing.T) {
+	unsetParentURLMock(t)
+	parent1, parent2 := &parentMock{}, &parentMock{}
+	server1, server2 := httptest.NewServer(parent1), httptest.NewServer(parent2)
+	defer server1.Close()
+	defer server2.Close()
+
+	credentialsPath := t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeFileMock(t, filepath.Join(credentialsPath, "accessKey"), []byte("key1"))
+	writeParentConfigMock(t, configPath, "ws"+strings.TrimPrefix(server1.URL, "http"), credentialsPath)
+	cfg, err := LoadConfig(configPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.reconnectBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	ns.config = cfg
+	ns.parents = cfg.Parent.parentURLs()
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	done := make(chan struct{})
+	go func() {
+		ns.connectToMaster(ATTRIBUTES_MOCK)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { _, open := parent1.connections(); return open == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return ns.outgoingConnections.Len() == 1 }, time.Second, time.Millisecond)
+	first := ns.outgoingConnections.List()[0]
+
+	// a new master URL
+	writeParentConfigMock(t, configPath, "ws"+strings.TrimPrefix(server2.URL, "http"), credentialsPath)
+	assert.NoError(t, ns.Reload())
+	assert.Eventually(t, func() bool {
+		_, open1 := parent1.connections()
+		_, open2 := parent2.connections()
+		return open1 == 0 && open2 == 1
+	}, time.Second, time.Millisecond, "the connection moves to the new master")
+	if assert.Equal(t, 1, ns.outgoingConnections.Len()) {
+		swapped := ns.outgoingConnections.List()[0]
+		assert.NotSame(t, first, swapped)
+		assert.Equal(t, first.ID, swapped.ID, "the connection is swapped in place")
+	}
+	assert.Equal(t, 1, ns.incomingConnections.Len(), "subscribers stay connected")
+
+	// a rotated access key
+	writeFileMock(t, filepath.Join(credentialsPath, "accessKey"), []byte("key2"))
+	assert.NoError(t, ns.Reload())
+	assert.Eventually(t, func() bool {
+		keys, open := parent2.connections()
+		return len(keys) == 2 && keys[1] == "key2" && open == 1
+	}, time.Second, time.Millisecond, "the link connects again with the new access key")
+	assert.Eventually(t, func() bool {
+		statuses := ns.UpstreamStatus()
+		return len(statuses) == 1 && statuses[0].State == LinkStateConnected
+	}, time.Second, time.Millisecond)
+
+	// moved credentials
+	movedPath := t.TempDir()
+	writeFileMock(t, filepath.Join(movedPath, "accessKey"), []byte("key3"))
+	writeParentConfigMock(t, configPath, "ws"+strings.TrimPrefix(server2.URL, "http"), movedPath)
+	assert.NoError(t, ns.Reload())
+	assert.Equal(t, movedPath, ns.currentConfig().Parent.CredentialsPath)
+	assert.Eventually(t, func() bool {
+		keys, open := parent2.connections()
+		return len(keys) == 3 && keys[2] == "key3" && open == 1
+	}, time.Second, time.Millisecond, "the link connects again with the access key of the new path")
+
+	// unchanged config
+	assert.NoError(t, ns.Reload())
+	keys, _ := parent2.connections()
+	assert.Equal(t, 3, len(keys), "nothing changed, the link is kept")
+
+	// no master anymore
+	writeParentConfigMock(t, configPath, "", movedPath)
+	assert.NoError(t, ns.Reload())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("upstream link was not stopped")
+	}
+	assert.Equal(t, 0, ns.outgoingConnections.Len())
+	assert.Equal(t, 1, ns.incomingConnections.Len(), "subscribers stay connected")
+}
+
+func TestWatchConfigMovedCredentials(t *testing.T) {
+	unsetParentURLMock(t)
+	parent := &parentMock{}
+	server := httptest.NewServer(parent)
+	defer server.Close()
+	parentURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	credentialsPath, movedPath := t.TempDir(), t.TempDir()
+	configPath := filepath.Join(t.TempDir(), "gateway.yaml")
+	writeFileMock(t, filepath.Join(credentialsPath, "accessKey"), []byte("key1"))
+	writeFileMock(t, filepath.Join(movedPath, "accessKey"), []byte("key2"))
+	writeParentConfigMock(t, configPath, parentURL, credentialsPath)
+	cfg, err := LoadConfig(configPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.reconnectBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	ns.config = cfg
+	ns.parents = cfg.Parent.parentURLs()
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	go ns.connectToMaster(ATTRIBUTES_MOCK)
+	defer ns.closeUpstreamLinks()
+	assert.Eventually(t, func() bool { _, open := parent.connections(); return open == 1 }, time.Second, time.Millisecond)
+
+	stop := make(chan struct{})
+	stopped := make(chan error, 1)
+	go func() { stopped <- ns.WatchConfig(stop) }()
+	// the watcher is set up asynchronously
+	time.Sleep(100 * time.Millisecond)
+
+	writeParentConfigMock(t, configPath, parentURL, movedPath)
+	assert.Eventually(t, func() bool {
+		keys, open := parent.connections()
+		return len(keys) == 2 && keys[1] == "key2" && open == 1
+	}, 2*time.Second, 10*time.Millisecond, "the access key is loaded from the new path")
+
+	// the new credentials are watched
+	time.Sleep(100 * time.Millisecond)
+	writeFileMock(t, filepath.Join(movedPath, "accessKey"), []byte("key3"))
+	assert.Eventually(t, func() bool {
+		keys, open := parent.connections()
+		return len(keys) == 3 && keys[2] == "key3" && open == 1
+	}, 2*time.Second, 10*time.Millisecond, "a rotated access key at the new path is picked up")
+
+	close(stop)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the watcher did not stop")
+	}
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	unsetParentURLMock(t)
+	configPath := writeConfigMock(t, "gateway.yaml", "parent:\n  url: wss://parent\n")
+	cfg, err := LoadConfig(configPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ns := NewNotificationServerEdgeMock()
+	ns.config = cfg
+	ns.parents = cfg.Parent.parentURLs()
+
+	assert.NoError(t, os.WriteFile(configPath, []byte("parent:\n  url: '::'\n"), 0o600))
+	assert.Error(t, ns.Reload())
+	assert.Equal(t, []string{"wss://parent"}, ns.parentURLs(), "an invalid config is not applied")
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultReplyTimeout = 10 * time.Second
+	// upstreamReplyShare is the share of the reply timeout a gateway waits for its subscribers when answering a request of its parent,
+	// so its reply reaches the parent before the parent stops waiting
+	upstreamReplyShare = 0.8
+)
+
+// errRequestPending is returned when a request with the same correlation ID is waiting for its replies
+var errRequestPending = errors.New("already pending")
+
+// ReplyFrame is the frame a subscriber sends back to reply to a request, a notification with a correlation ID
+type ReplyFrame struct {
+	ReplyTo string          `json:"replyTo"`
+	Reply   json.RawMessage `json:"reply,omitempty"`
+}
+
+// parseReplyFrame returns the correlation ID and the reply if a given message is a ReplyFrame.
+// The reply of a BSON frame is converted to JSON
+func parseReplyFrame(message []byte) (string, json.RawMessage, bool) {
+	frame := ReplyFrame{}
+	if err := json.Unmarshal(message, &frame); err == nil && frame.ReplyTo != "" {
+		return frame.ReplyTo, frame.Reply, true
+	}
+	bsonFrame := struct {
+		ReplyTo string      `bson:"replyTo"`
+		Reply   interface{} `bson:"reply"`
+	}{}
+	if err := bson.Unmarshal(message, &bsonFrame); err == nil && bsonFrame.ReplyTo != "" {
+		reply, err := json.Marshal(bsonFrame.Reply)
+		if err != nil {
+			return "", nil, false
+		}
+		return bsonFrame.ReplyTo, reply, true
+	}
+	return "", nil, false
+}
+
+// pendingRequest is a request routed to subscribers that did not all reply yet
+type pendingRequest struct {
+	correlationID string
+	// requested are the IDs of all the connections the request expects a reply from
+	requested map[int]bool
+	// expected are the IDs of the connections that did not reply yet
+	expected map[int]bool
+	replies  map[int]json.RawMessage
+	// replied is closed once none of the connections is expected to reply
+	replied chan struct{}
+}
+
+// ReplyTracker collects the replies of the subscribers to the requests routed to them
+type ReplyTracker struct {
+	pending map[string]*pendingRequest
+	mutex   *sync.Mutex
+	timeout time.Duration
+}
+
+// NewReplyTracker creates a new ReplyTracker waiting up to a given default timeout
+func NewReplyTracker(timeout time.Duration) *ReplyTracker {
+	return &ReplyTracker{
+		pending: map[string]*pendingRequest{},
+		mutex:   &sync.Mutex{},
+		timeout: timeout,
+	}
+}
+
+// Expect starts waiting for the replies of the given connections to a request. Call it before writing the request.
+// Fails if a request with the same correlation ID is pending
+func (rt *ReplyTracker) Expect(correlationID string, connIDs []int) (*pendingRequest, error) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if _, ok := rt.pending[correlationID]; ok {
+		return nil, fmt.Errorf("a request with correlation ID '%s' is %w", correlationID, errRequestPending)
+	}
+	req := &pendingRequest{
+		correlationID: correlationID,
+		requested:     map[int]bool{},
+		expected:      map[int]bool{},
+		replies:       map[int]json.RawMessage{},
+		replied:       make(chan struct{}),
+	}
+	for _, id := range connIDs {
+		req.requested[id] = true
+		req.expected[id] = true
+	}
+	if len(req.expected) == 0 {
+		close(req.replied)
+	}
+	rt.pending[correlationID] = req
+	return req, nil
+}
+
+// Reply records the reply of a connection to a request. Returns false if the connection was not expected to reply
+func (rt *ReplyTracker) Reply(correlationID string, connID int, reply json.RawMessage) bool {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	req, ok := rt.pending[correlationID]
+	if !ok || !req.expected[connID] {
+		return false
+	}
+	req.replies[connID] = reply
+	rt.settle(req, connID)
+	return true
+}
+
+// Drop stops expecting a connection to reply, e.g. because the request could not be written to it
+func (rt *ReplyTracker) Drop(req *pendingRequest, connID int) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if req.expected[connID] {
+		rt.settle(req, connID)
+	}
+}
+
+// settle marks an expected connection as done with. The caller must hold the lock
+func (rt *ReplyTracker) settle(req *pendingRequest, connID int) {
+	delete(req.expected, connID)
+	if len(req.expected) == 0 {
+		close(req.replied)
+	}
+}
+
+// Wait waits for the connections to reply to a request, up to a given timeout (the default timeout if 0),
+// and stops tracking it. Returns the replies by connection ID
+func (rt *ReplyTracker) Wait(req *pendingRequest, timeout time.Duration) map[int]json.RawMessage {
+	if timeout <= 0 {
+		timeout = rt.timeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case <-req.replied:
+	case <-deadline.C:
+	}
+
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	delete(rt.pending, req.correlationID)
+	replies := make(map[int]json.RawMessage, len(req.replies))
+	for id, reply := range req.replies {
+		replies[id] = reply
+	}
+	return replies
+}
+
+// collectReplies waits for the replies to a request and records them in the result of the request
+func (nh *Gateway) collectReplies(req *pendingRequest, timeout time.Duration, result *SendResult) {
+	replies := nh.replies.Wait(req, timeout)
+	for i := range result.Connections {
+		delivery := &result.Connections[i]
+		if !req.requested[delivery.ID] || delivery.Status == DeliveryStatusFailed {
+			continue
+		}
+		if reply, ok := replies[delivery.ID]; ok {
+			delivery.Status = DeliveryStatusReplied
+			delivery.Reply = reply
+		} else {
+			delivery.Status = DeliveryStatusNoReply
+		}
+	}
+}
+
+// replyToRequester sends the outcome of a request, with the replies of the subscribers, back to the websocket it came from
+func (nh *Gateway) replyToRequester(connObj *websocketactions.Connection, correlationID string, result *SendResult) {
+	reply, _ := json.Marshal(result)
+	b, _ := json.Marshal(ReplyFrame{ReplyTo: correlationID, Reply: reply})
+	connObj.Enqueue(&websocketactions.QueuedWrite{
+		Write: func() error { return nh.wa.WriteBinaryMessage(connObj, b) },
+		Done: func(err error) {
+			if err != nil {
+				logger.L().Error("failed to reply to request", helpers.String("correlationID", correlationID), helpers.Int("id", connObj.ID), helpers.Error(err))
+			}
+		},
+	})
+}
+
+// replyTimeout returns the time to wait for the replies to a request
+func (n *Notification) replyTimeout() time.Duration {
+	return time.Duration(n.ReplyTimeoutMs) * time.Millisecond
+}
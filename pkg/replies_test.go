@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseReplyFrame(t *testing.T) {
+	id, reply, ok := parseReplyFrame([]byte(`{"replyTo":"abc","reply":{"status":"done"}}`))
+	assert.True(t, ok)
+	assert.Equal(t, "abc", id)
+	assert.JSONEq(t, `{"status":"done"}`, string(reply))
+
+	b, _ := bson.Marshal(bson.M{"replyTo": "def", "reply": bson.M{"status": "done"}})
+	id, reply, ok = parseReplyFrame(b)
+	assert.True(t, ok)
+	assert.Equal(t, "def", id)
+	assert.JSONEq(t, `{"status":"done"}`, string(reply))
+
+	_, _, ok = parseReplyFrame([]byte(`{"target":{"customer":"test"},"correlationID":"abc"}`))
+	assert.False(t, ok)
+}
+
+func TestReplyTracker(t *testing.T) {
+	rt := NewReplyTracker(time.Second)
+	req, err := rt.Expect("abc", []int{1, 2, 3})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = rt.Expect("abc", []int{4})
+	assert.Error(t, err, "correlation IDs of pending requests are unique")
+
+	assert.True(t, rt.Reply("abc", 1, json.RawMessage(`"one"`)))
+	assert.False(t, rt.Reply("abc", 1, json.RawMessage(`"again"`)), "a connection replies once")
+	assert.False(t, rt.Reply("abc", 4, json.RawMessage(`"four"`)), "the request was not sent to the connection")
+	assert.False(t, rt.Reply("other", 2, json.RawMessage(`"two"`)))
+	rt.Drop(req, 3)
+	go rt.Reply("abc", 2, json.RawMessage(`"two"`))
+
+	start := time.Now()
+	replies := rt.Wait(req, 0)
+	assert.Less(t, time.Since(start), time.Second, "all the connections replied before the timeout")
+	assert.Equal(t, map[int]json.RawMessage{1: json.RawMessage(`"one"`), 2: json.RawMessage(`"two"`)}, replies)
+	assert.False(t, rt.Reply("abc", 3, json.RawMessage(`"late"`)), "the request is not tracked once waited for")
+
+	req, _ = rt.Expect("def", []int{1})
+	assert.Equal(t, 0, len(rt.Wait(req, 10*time.Millisecond)))
+}
+
+// replyingSubscriberMock subscribes with given attributes and replies to every request it receives with a given reply
+func replyingSubscriberMock(t *testing.T, url string, reply string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			n := &Notification{}
+			if json.Unmarshal(message, n) == nil && n.CorrelationID != "" {
+				conn.WriteMessage(websocket.TextMessage, []byte(`{"replyTo":"`+n.CorrelationID+`","reply":`+reply+`}`))
+			}
+		}
+	}()
+	return conn
+}
+
+func TestRequestReply(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	replying := replyingSubscriberMock(t, url+"?customer=test&cluster=a", `{"scanned":true}`)
+	defer replying.Close()
+	silent, _, err := websocket.DefaultDialer.Dial(url+"?customer=test&cluster=b", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer silent.Close()
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 2 }, time.Second, time.Millisecond)
+
+	// a REST caller receives the replies in the response
+	w := httptest.NewRecorder()
+	ns.RestAPINotificationHandler(w, httptest.NewRequest(http.MethodPost, "/v1/sendnotification", bytes.NewBufferString(`{"target":{"customer":"test"},"correlationID":"scan-1","replyTimeoutMs":200}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	result := SendResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, "scan-1", result.CorrelationID)
+	statuses := map[string]DeliveryStatus{}
+	for _, delivery := range result.Connections {
+		statuses[delivery.Attributes["cluster"]] = delivery.Status
+		if delivery.Status == DeliveryStatusReplied {
+			assert.JSONEq(t, `{"scanned":true}`, string(delivery.Reply))
+		}
+	}
+	assert.Equal(t, map[string]DeliveryStatus{"a": DeliveryStatusReplied, "b": DeliveryStatusNoReply}, statuses)
+
+	// a websocket requester receives the replies as a reply frame
+	requester, _, err := websocket.DefaultDialer.Dial(url+"?customer=requester", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer requester.Close()
+	assert.NoError(t, requester.WriteMessage(websocket.TextMessage, []byte(`{"target":{"cluster":"a"},"correlationID":"scan-2"}`)))
+	requester.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := requester.ReadMessage()
+	if !assert.NoError(t, err) {
+		return
+	}
+	correlationID, reply, ok := parseReplyFrame(message)
+	assert.True(t, ok)
+	assert.Equal(t, "scan-2", correlationID)
+	result = SendResult{}
+	assert.NoError(t, json.Unmarshal(reply, &result))
+	if assert.Equal(t, 1, len(result.Connections)) {
+		assert.Equal(t, DeliveryStatusReplied, result.Connections[0].Status)
+	}
+}
+
+func TestRequestWithoutSubscribers(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.notificationBuffer = NewMemoryNotificationBuffer(time.Minute, 10)
+	n := NotificationMock(ATTRIBUTES_MOCK, false)
+	n.CorrelationID = "abc"
+	result, err := ns.SendNotification(n, []byte("{}"))
+	assert.NoError(t, err)
+	assert.False(t, result.Queued, "nobody would reply to a buffered request")
+	assert.Equal(t, 0, len(result.Connections))
+
+	w := httptest.NewRecorder()
+	ns.RestAPINotificationHandler(w, httptest.NewRequest(http.MethodPost, "/v1/sendnotification", bytes.NewBufferString(`{"target":{"customer":"test"},"correlationID":"abc"}`)))
+	body, _ := io.ReadAll(w.Body)
+	assert.Equal(t, http.StatusOK, w.Code, string(body))
+}
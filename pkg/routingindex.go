@@ -0,0 +1,305 @@
+package gateway
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/gorilla/websocket"
+)
+
+// indexedConnection is a connection managed by IndexedConnections
+type indexedConnection struct {
+	connection *websocketactions.Connection
+	// sequence keeps the order in which connections were appended
+	sequence uint64
+	// sets are the routing attributes the connection is indexed by
+	sets []map[string]string
+}
+
+// IndexedConnections is a Router that keeps an inverted index of the
+// connections by attribute key and value.
+// A lookup only visits connections that share at least one attribute key and
+// value with the requested attributes, instead of scanning all connections
+type IndexedConnections struct {
+	connections map[int]*indexedConnection
+	// index maps attribute key -> attribute value -> connection IDs
+	index map[string]map[string]map[int]struct{}
+	// keyCount counts the indexed attribute sets that have an attribute key
+	keyCount map[string]int
+	// setCount counts the indexed attribute sets, one per connection unless it advertised subscriptions
+	setCount int
+	// reserved are the IDs of the appended connections that are set up before they are indexed
+	reserved map[int]struct{}
+	sequence uint64
+	mutex    *sync.RWMutex
+}
+
+// NewIndexedConnections creates a new IndexedConnections object
+func NewIndexedConnections() *IndexedConnections {
+	return &IndexedConnections{
+		connections: map[int]*indexedConnection{},
+		index:       map[string]map[string]map[int]struct{}{},
+		keyCount:    map[string]int{},
+		reserved:    map[int]struct{}{},
+		mutex:       &sync.RWMutex{},
+	}
+}
+
+// Append appends a given connection with provided attributes to the current connections
+func (ic *IndexedConnections) Append(attributes map[string]string, conn *websocket.Conn, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int) {
+	return ic.appendConnection(func(id int) *websocketactions.Connection {
+		return websocketactions.NewConnection(conn, id, attributes)
+	}, setup)
+}
+
+// AppendStream appends a given Server-Sent Events stream with provided attributes to the current connections
+func (ic *IndexedConnections) AppendStream(attributes map[string]string, stream *websocketactions.SSEStream, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int) {
+	return ic.appendConnection(func(id int) *websocketactions.Connection {
+		return websocketactions.NewStreamConnection(stream, id, attributes)
+	}, setup)
+}
+
+// appendConnection indexes the connection created for a new unique ID, once it was set up
+func (ic *IndexedConnections) appendConnection(newConnection func(id int) *websocketactions.Connection, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int) {
+	ic.mutex.Lock()
+	id := rand.Int()
+	for ic.taken(id) {
+		id = rand.Int()
+	}
+	connection := newConnection(id)
+	if setup == nil {
+		ic.add(connection)
+		ic.mutex.Unlock()
+		return connection, id
+	}
+	ic.reserved[id] = struct{}{}
+	ic.mutex.Unlock()
+
+	// the setup may route notifications or remove connections, it runs without the lock
+	setup(connection)
+
+	ic.mutex.Lock()
+	delete(ic.reserved, id)
+	ic.add(connection)
+	ic.mutex.Unlock()
+	return connection, id
+}
+
+// taken reports whether an ID belongs to a connection, indexed or set up. The caller must hold the lock
+func (ic *IndexedConnections) taken(id int) bool {
+	_, indexed := ic.connections[id]
+	_, reserved := ic.reserved[id]
+	return indexed || reserved
+}
+
+// add indexes a given connection. The caller must hold the write lock
+func (ic *IndexedConnections) add(connection *websocketactions.Connection) {
+	ic.sequence++
+	entry := &indexedConnection{connection: connection, sequence: ic.sequence}
+	ic.connections[connection.ID] = entry
+	ic.indexSets(entry)
+}
+
+// indexSets indexes the routing attributes of a connection. The caller must hold the write lock
+func (ic *IndexedConnections) indexSets(entry *indexedConnection) {
+	entry.sets = entry.connection.RoutingAttributes()
+	for _, set := range entry.sets {
+		for k, v := range set {
+			values, ok := ic.index[k]
+			if !ok {
+				values = map[string]map[int]struct{}{}
+				ic.index[k] = values
+			}
+			ids, ok := values[v]
+			if !ok {
+				ids = map[int]struct{}{}
+				values[v] = ids
+			}
+			ids[entry.connection.ID] = struct{}{}
+			ic.keyCount[k]++
+		}
+	}
+	ic.setCount += len(entry.sets)
+}
+
+// unindexSets removes the routing attributes of a connection from the index. The caller must hold the write lock
+func (ic *IndexedConnections) unindexSets(entry *indexedConnection) {
+	for _, set := range entry.sets {
+		for k, v := range set {
+			values := ic.index[k]
+			delete(values[v], entry.connection.ID)
+			ic.keyCount[k]--
+			if len(values[v]) == 0 {
+				delete(values, v)
+			}
+			if len(values) == 0 {
+				delete(ic.index, k)
+				delete(ic.keyCount, k)
+			}
+		}
+	}
+	ic.setCount -= len(entry.sets)
+	entry.sets = nil
+}
+
+// remove removes a connection with a given ID from the index. The caller must hold the write lock
+func (ic *IndexedConnections) remove(id int) {
+	entry, ok := ic.connections[id]
+	if !ok {
+		return
+	}
+	delete(ic.connections, id)
+	ic.unindexSets(entry)
+	logger.L().Info("removing connection from list", helpers.String("attributes", strutils.ObjectToString(entry.connection.GetAttributes())), helpers.Int("id", id), helpers.Int("list len", len(ic.connections)))
+}
+
+// get retrieves the connections matching the given attributes. The caller must hold the read lock.
+//
+// A matching connection that has the most selective requested key must hold its
+// requested value, so the lookup starts from that (usually small) set of
+// connections. Only when some connections lack that key, the rest of the
+// requested keys are visited as well
+func (ic *IndexedConnections) get(attributes map[string]string) []*indexedConnection {
+	selectiveKey := ""
+	selectiveLen := -1
+	for k, v := range attributes {
+		if _, ok := ic.index[k]; !ok {
+			// no connection has this key, it can neither match nor conflict
+			continue
+		}
+		if l := len(ic.index[k][v]); selectiveLen == -1 || l < selectiveLen {
+			selectiveKey, selectiveLen = k, l
+		}
+	}
+	if selectiveLen == -1 {
+		return []*indexedConnection{}
+	}
+
+	candidates := map[int]*indexedConnection{}
+	for id := range ic.index[selectiveKey][attributes[selectiveKey]] {
+		candidates[id] = ic.connections[id]
+	}
+	if ic.keyCount[selectiveKey] < ic.setCount {
+		// some attribute sets do not have the selective key, they can match by any other key
+		for k, v := range attributes {
+			if k == selectiveKey {
+				continue
+			}
+			for id := range ic.index[k][v] {
+				candidates[id] = ic.connections[id]
+			}
+		}
+	}
+
+	entries := make([]*indexedConnection, 0, len(candidates))
+	for _, entry := range candidates {
+		// a candidate shares at least one attribute, make sure none of the others conflict
+		if entry.connection.AttributesContained(attributes) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].sequence < entries[j].sequence })
+	return entries
+}
+
+// Remove removes the connections with given attributes from the routing table
+func (ic *IndexedConnections) Remove(attributes map[string]string) {
+	ic.mutex.Lock()
+	for _, entry := range ic.get(attributes) {
+		ic.remove(entry.connection.ID)
+	}
+	ic.mutex.Unlock()
+}
+
+// RemoveID removes a connection with a given ID from the routing table
+func (ic *IndexedConnections) RemoveID(id int) {
+	ic.mutex.Lock()
+	ic.remove(id)
+	ic.mutex.Unlock()
+}
+
+// Replace swaps the websocket of the connection with a given ID for a new one, in a single step
+func (ic *IndexedConnections) Replace(id int, conn *websocket.Conn, attributes map[string]string) (*websocketactions.Connection, bool) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	entry, ok := ic.connections[id]
+	if !ok {
+		return nil, false
+	}
+	// the attributes may have changed, the connection is indexed again
+	ic.unindexSets(entry)
+	connection := websocketactions.NewConnection(conn, id, attributes)
+	if subscriptions := entry.connection.Subscriptions(); subscriptions != nil {
+		connection.SetSubscriptions(subscriptions)
+	}
+	entry.connection = connection
+	ic.indexSets(entry)
+	return entry.connection, true
+}
+
+// SetSubscriptions routes the connection with a given ID by the given attribute sets instead of its own attributes
+func (ic *IndexedConnections) SetSubscriptions(id int, subscriptions []map[string]string) bool {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	entry, ok := ic.connections[id]
+	if !ok {
+		return false
+	}
+	ic.unindexSets(entry)
+	entry.connection.SetSubscriptions(subscriptions)
+	ic.indexSets(entry)
+	return true
+}
+
+// Get retrieves the connections with given attributes from the routing table
+func (ic *IndexedConnections) Get(attributes map[string]string) []*websocketactions.Connection {
+	ic.mutex.RLock()
+	entries := ic.get(attributes)
+	ic.mutex.RUnlock()
+
+	conns := make([]*websocketactions.Connection, len(entries))
+	for i := range entries {
+		conns[i] = entries[i].connection
+	}
+	return conns
+}
+
+// List returns all the currently managed connections, in the order they were appended
+func (ic *IndexedConnections) List() []*websocketactions.Connection {
+	ic.mutex.RLock()
+	entries := make([]*indexedConnection, 0, len(ic.connections))
+	for _, entry := range ic.connections {
+		entries = append(entries, entry)
+	}
+	ic.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].sequence < entries[j].sequence })
+	conns := make([]*websocketactions.Connection, len(entries))
+	for i := range entries {
+		conns[i] = entries[i].connection
+	}
+	return conns
+}
+
+// Len returns the number of the currently managed connections
+func (ic *IndexedConnections) Len() int {
+	ic.mutex.RLock()
+	l := len(ic.connections)
+	ic.mutex.RUnlock()
+	return l
+}
+
+// CloseConnections closes all connections that have a set of provided attributes
+func (ic *IndexedConnections) CloseConnections(wa websocketactions.IWebsocketActions, attributes map[string]string) {
+	conns := ic.Get(attributes)
+	for i := range conns {
+		wa.Close(conns[i])
+	}
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexedConnectionsGet(t *testing.T) {
+	ic := NewIndexedConnections()
+	_, id := ic.Append(ATTRIBUTES_MOCK, nil, nil)
+
+	rtv1 := ic.Get(ATTRIBUTES_MOCK)
+	assert.Equal(t, 1, len(rtv1))
+	assert.Equal(t, id, rtv1[0].ID)
+
+	assert.Equal(t, 1, len(ic.Get(map[string]string{"customer": "test"})))
+	assert.Equal(t, 0, len(ic.Get(map[string]string{"customer": "test", "cluster": "bla"})))
+	assert.Equal(t, 1, len(ic.Get(map[string]string{"cluster": "yay"})))
+	assert.Equal(t, 0, len(ic.Get(map[string]string{"customerGUID": "test"})))
+	assert.Equal(t, 0, len(ic.Get(map[string]string{})))
+}
+
+func TestIndexedConnectionsSubscriptions(t *testing.T) {
+	for name, router := range map[string]Router{"indexed": NewIndexedConnections(), "slice": NewConnectionsObj()} {
+		t.Run(name, func(t *testing.T) {
+			_, id := router.Append(map[string]string{"customer": "test"}, nil, nil)
+			assert.Equal(t, 1, len(router.Get(map[string]string{"customer": "test", "cluster": "b"})), "routed by its attributes until it subscribes")
+
+			assert.True(t, router.SetSubscriptions(id, []map[string]string{{"customer": "test", "cluster": "a"}, {"customer": "test", "cluster": "c"}}))
+			assert.Equal(t, 1, len(router.Get(map[string]string{"customer": "test", "cluster": "a"})))
+			assert.Equal(t, 1, len(router.Get(map[string]string{"cluster": "c"})))
+			assert.Equal(t, 1, len(router.Get(map[string]string{"customer": "test"})))
+			assert.Equal(t, 0, len(router.Get(map[string]string{"customer": "test", "cluster": "b"})))
+			assert.Equal(t, 0, len(router.Get(map[string]string{"customer": "other", "cluster": "a"})))
+
+			assert.True(t, router.SetSubscriptions(id, []map[string]string{}))
+			assert.Equal(t, 0, len(router.Get(map[string]string{"customer": "test"})), "routed nothing once unsubscribed from everything")
+			assert.Equal(t, 1, router.Len())
+
+			router.RemoveID(id)
+			assert.False(t, router.SetSubscriptions(id, nil))
+		})
+	}
+}
+
+func TestIndexedConnectionsRemove(t *testing.T) {
+	ic := NewIndexedConnections()
+	_, id1 := ic.Append(map[string]string{"customer": "a", "cluster": "1"}, nil, nil)
+	_, id2 := ic.Append(map[string]string{"customer": "a", "cluster": "2"}, nil, nil)
+	_, id3 := ic.Append(map[string]string{"customer": "b", "cluster": "1"}, nil, nil)
+	assert.Equal(t, 3, ic.Len())
+
+	conns := ic.Get(map[string]string{"customer": "a"})
+	assert.Equal(t, 2, len(conns))
+	assert.Equal(t, id1, conns[0].ID, "connections are returned in the order they were appended")
+	assert.Equal(t, id2, conns[1].ID)
+	all := ic.List()
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, id3, all[2].ID, "connections are listed in the order they were appended")
+
+	ic.Remove(map[string]string{"cluster": "1"})
+	assert.Equal(t, 1, ic.Len())
+	assert.Equal(t, 0, len(ic.Get(map[string]string{"customer": "b"})))
+
+	ic.RemoveID(id3) // already removed
+	ic.RemoveID(id2)
+	assert.Equal(t, 0, ic.Len())
+	assert.Equal(t, 0, len(ic.index), "index should not keep empty entries")
+}
+
+func TestIndexedConnectionsReplace(t *testing.T) {
+	for _, router := range []Router{NewIndexedConnections(), NewConnectionsObj()} {
+		old, id := router.Append(ATTRIBUTES_MOCK, nil, nil)
+		router.Append(map[string]string{"customer": "other"}, nil, nil)
+
+		replaced, ok := router.Replace(id, nil, ATTRIBUTES_MOCK)
+		if !assert.True(t, ok) {
+			continue
+		}
+		assert.NotSame(t, old, replaced)
+		assert.Equal(t, id, replaced.ID, "the replacement keeps the ID")
+		assert.Equal(t, ATTRIBUTES_MOCK, replaced.GetAttributes())
+		assert.Equal(t, 2, router.Len())
+		assert.Equal(t, []*websocketactions.Connection{replaced}, router.Get(ATTRIBUTES_MOCK))
+
+		registered := map[string]string{"customer": "registered"}
+		reregistered, ok := router.Replace(id, nil, registered)
+		if assert.True(t, ok) {
+			assert.Equal(t, registered, reregistered.GetAttributes(), "the replacement registers with the new attributes")
+			assert.Equal(t, []*websocketactions.Connection{reregistered}, router.Get(registered), "the replacement is routed by the new attributes")
+			assert.Equal(t, 0, len(router.Get(ATTRIBUTES_MOCK)))
+		}
+
+		router.RemoveID(id)
+		_, ok = router.Replace(id, nil, ATTRIBUTES_MOCK)
+		assert.False(t, ok, "a removed connection is not replaced")
+	}
+}
+
+// TestIndexedConnectionsMatchesConnections makes sure the indexed router keeps the semantics of the slice router
+func TestIndexedConnectionsMatchesConnections(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	randomAttributes := func() map[string]string {
+		att := map[string]string{}
+		for _, k := range []string{"customer", "cluster", "component"} {
+			if r.Intn(3) > 0 {
+				att[k] = fmt.Sprintf("%d", r.Intn(3))
+			}
+		}
+		return att
+	}
+
+	cs := NewConnectionsObj()
+	ic := NewIndexedConnections()
+	for i := 0; i < 200; i++ {
+		att := randomAttributes()
+		cs.Append(att, nil, nil)
+		ic.Append(att, nil, nil)
+	}
+	for i := 0; i < 200; i++ {
+		att := randomAttributes()
+		expected := cs.Get(att)
+		got := ic.Get(att)
+		if !assert.Equal(t, len(expected), len(got), "attributes %v", att) {
+			continue
+		}
+		for j := range expected {
+			assert.Equal(t, expected[j].GetAttributes(), got[j].GetAttributes())
+		}
+	}
+}
+
+func populateRouter(router Router, n int) {
+	for i := 0; i < n; i++ {
+		router.Append(map[string]string{
+			"customer":  fmt.Sprintf("customer-%d", i%100),
+			"cluster":   fmt.Sprintf("cluster-%d", i),
+			"component": fmt.Sprintf("component-%d", i%5),
+		}, nil, nil)
+	}
+}
+
+func benchmarkRouterGet(b *testing.B, router Router, n int) {
+	populateRouter(router, n)
+	route := map[string]string{"customer": "customer-7", "component": "component-2"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.Get(route)
+	}
+}
+
+func BenchmarkRouterGet(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("Connections/%d", n), func(b *testing.B) {
+			benchmarkRouterGet(b, NewConnectionsObj(), n)
+		})
+		b.Run(fmt.Sprintf("IndexedConnections/%d", n), func(b *testing.B) {
+			benchmarkRouterGet(b, NewIndexedConnections(), n)
+		})
+	}
+}
+
+func BenchmarkRouterAppendRemoveID(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("Connections/%d", n), func(b *testing.B) {
+			cs := NewConnectionsObj()
+			populateRouter(cs, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, id := cs.Append(ATTRIBUTES_MOCK, nil, nil)
+				cs.RemoveID(id)
+			}
+		})
+		b.Run(fmt.Sprintf("IndexedConnections/%d", n), func(b *testing.B) {
+			ic := NewIndexedConnections()
+			populateRouter(ic, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, id := ic.Append(ATTRIBUTES_MOCK, nil, nil)
+				ic.RemoveID(id)
+			}
+		})
+	}
+}
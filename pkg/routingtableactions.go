@@ -13,6 +13,43 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Router is a routing table of open connections.
+// Connections are registered with a set of attributes and looked up by the
+// attributes provided in requests. A connection matches a set of attributes if
+// at least one of its attributes is present in the set and none of the
+// attributes present in both have different values
+type Router interface {
+	// Append registers a given connection with provided attributes.
+	// setup, if set, is called with the new connection before it is routed
+	Append(attributes map[string]string, conn *websocket.Conn, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int)
+	// AppendStream registers a given Server-Sent Events stream with provided attributes.
+	// setup, if set, is called with the new connection before it is routed
+	AppendStream(attributes map[string]string, stream *websocketactions.SSEStream, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int)
+	// Remove removes all connections matching the given attributes
+	Remove(attributes map[string]string)
+	// RemoveID removes a connection with a given ID
+	RemoveID(id int)
+	// Replace swaps the websocket of the connection with a given ID for a new one registered with given attributes, keeping its ID.
+	// Returns false if there is no such connection
+	Replace(id int, conn *websocket.Conn, attributes map[string]string) (*websocketactions.Connection, bool)
+	// SetSubscriptions routes the connection with a given ID by the given attribute sets instead of the attributes it registered with.
+	// Returns false if there is no such connection
+	SetSubscriptions(id int, subscriptions []map[string]string) bool
+	// Get retrieves all connections matching the given attributes, in the order they were appended
+	Get(attributes map[string]string) []*websocketactions.Connection
+	// List retrieves all registered connections, in the order they were appended
+	List() []*websocketactions.Connection
+	// Len returns the number of registered connections
+	Len() int
+	// CloseConnections closes all connections matching the given attributes
+	CloseConnections(wa websocketactions.IWebsocketActions, attributes map[string]string)
+}
+
+// NewRouter creates the default Router implementation
+func NewRouter() Router {
+	return NewIndexedConnections()
+}
+
 // Connections manages the open websocket connections.
 // It acts as a routing table that routes requests to matching connections by
 // the attributes provided in requests
@@ -29,9 +66,25 @@ func NewConnectionsObj() *Connections {
 }
 
 // Append appends a given connection with provided attributes to the current connections
-func (cs *Connections) Append(attributes map[string]string, conn *websocket.Conn) (*websocketactions.Connection, int) {
+func (cs *Connections) Append(attributes map[string]string, conn *websocket.Conn, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int) {
 	id := rand.Int()
 	connection := websocketactions.NewConnection(conn, id, attributes)
+	if setup != nil {
+		setup(connection)
+	}
+	cs.mutex.Lock()
+	cs.connections = append(cs.connections, connection)
+	cs.mutex.Unlock()
+	return connection, id
+}
+
+// AppendStream appends a given Server-Sent Events stream with provided attributes to the current connections
+func (cs *Connections) AppendStream(attributes map[string]string, stream *websocketactions.SSEStream, setup func(*websocketactions.Connection)) (*websocketactions.Connection, int) {
+	id := rand.Int()
+	connection := websocketactions.NewStreamConnection(stream, id, attributes)
+	if setup != nil {
+		setup(connection)
+	}
 	cs.mutex.Lock()
 	cs.connections = append(cs.connections, connection)
 	cs.mutex.Unlock()
@@ -80,6 +133,36 @@ func (cs *Connections) RemoveID(id int) {
 	cs.mutex.Unlock()
 }
 
+// Replace swaps the websocket of the connection with a given ID for a new one, in a single step
+func (cs *Connections) Replace(id int, conn *websocket.Conn, attributes map[string]string) (*websocketactions.Connection, bool) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	for i := range cs.connections {
+		if cs.connections[i].ID == id {
+			connection := websocketactions.NewConnection(conn, id, attributes)
+			if subscriptions := cs.connections[i].Subscriptions(); subscriptions != nil {
+				connection.SetSubscriptions(subscriptions)
+			}
+			cs.connections[i] = connection
+			return cs.connections[i], true
+		}
+	}
+	return nil, false
+}
+
+// SetSubscriptions routes the connection with a given ID by the given attribute sets instead of its own attributes
+func (cs *Connections) SetSubscriptions(id int, subscriptions []map[string]string) bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	for i := range cs.connections {
+		if cs.connections[i].ID == id {
+			cs.connections[i].SetSubscriptions(subscriptions)
+			return true
+		}
+	}
+	return false
+}
+
 // Get retrieves a connection with given attributes from the routing table
 func (cs *Connections) Get(attributes map[string]string) []*websocketactions.Connection {
 	conns := []*websocketactions.Connection{}
@@ -93,6 +176,15 @@ func (cs *Connections) Get(attributes map[string]string) []*websocketactions.Con
 	return conns
 }
 
+// List returns all the currently managed connections
+func (cs *Connections) List() []*websocketactions.Connection {
+	cs.mutex.RLocker().Lock()
+	conns := make([]*websocketactions.Connection, len(cs.connections))
+	copy(conns, cs.connections)
+	cs.mutex.RLocker().Unlock()
+	return conns
+}
+
 // Len returns the number of the currently managed connections
 func (cs *Connections) Len() int {
 	cs.mutex.RLocker().Lock()
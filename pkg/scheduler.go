@@ -0,0 +1,373 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+const (
+	defaultSchedulerMaxDelay = 7 * 24 * time.Hour
+	// schedulerWorkers is the number of due notifications delivered at once, so a slow delivery does not hold up the others
+	schedulerWorkers = 16
+	// schedulerIdleWait is how long the scheduler sleeps when nothing is scheduled, scheduling a notification wakes it up
+	schedulerIdleWait = time.Hour
+)
+
+var (
+	// errSchedulerFull is returned when the scheduler holds as many notifications as it may
+	errSchedulerFull = errors.New("scheduler is full")
+	// errAlreadyScheduled is returned when a notification with the same message ID is scheduled
+	errAlreadyScheduled = errors.New("already scheduled")
+)
+
+// ScheduledNotification is a notification held until it is due
+type ScheduledNotification struct {
+	// ID is the message ID of the notification
+	ID     string            `json:"id"`
+	Target map[string]string `json:"target,omitempty"`
+	// DeliverAt is when the notification is routed
+	DeliverAt time.Time `json:"deliverAt"`
+	// Scheduled is when the notification was received
+	Scheduled    time.Time `json:"scheduled"`
+	Notification []byte    `json:"notification"`
+}
+
+// Scheduler holds the scheduled notifications and hands them over once they are due
+type Scheduler struct {
+	pending map[string]*ScheduledNotification
+	size    int
+	mutex   *sync.Mutex
+	// wake interrupts waiting for the next due notification, when an earlier one is scheduled
+	wake chan struct{}
+	// stopped is closed by Stop
+	stopped chan struct{}
+	stop    sync.Once
+	// persist is called with the ID of a scheduled notification after it was scheduled, with nil after it was removed
+	persist func(id string, sn *ScheduledNotification)
+}
+
+// NewScheduler creates a new Scheduler holding up to a given number of notifications in memory
+func NewScheduler(size int) *Scheduler {
+	return &Scheduler{
+		pending: map[string]*ScheduledNotification{},
+		size:    size,
+		mutex:   &sync.Mutex{},
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+		persist: func(string, *ScheduledNotification) {},
+	}
+}
+
+// NewDiskScheduler creates a Scheduler that mirrors every scheduled notification to a file in a given directory.
+// Notifications found in the directory are loaded, so scheduled notifications survive restarts
+func NewDiskScheduler(dir string, size int) (*Scheduler, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create scheduler directory '%s', reason: %s", dir, err.Error())
+	}
+	s := NewScheduler(size)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			logger.L().Warning("failed to read scheduled notification", helpers.String("file", file), helpers.Error(err))
+			continue
+		}
+		sn := &ScheduledNotification{}
+		if err := json.Unmarshal(b, sn); err != nil || sn.ID == "" {
+			logger.L().Warning("failed to parse scheduled notification", helpers.String("file", file), helpers.Error(err))
+			continue
+		}
+		// the notifications scheduled before a restart are kept even if the size was lowered
+		s.pending[sn.ID] = sn
+	}
+	logger.L().Info("loaded scheduled notifications", helpers.String("dir", dir), helpers.Int("count", len(s.pending)))
+
+	s.persist = func(id string, sn *ScheduledNotification) {
+		sum := sha256.Sum256([]byte(id))
+		file := filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+		if sn == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				logger.L().Warning("failed to remove scheduled notification", helpers.String("file", file), helpers.Error(err))
+			}
+			return
+		}
+		b, _ := json.Marshal(sn)
+		// write to a temporary file first so a crash never leaves a partial notification behind
+		if err := os.WriteFile(file+".tmp", b, 0o600); err != nil {
+			logger.L().Warning("failed to persist scheduled notification", helpers.String("file", file), helpers.Error(err))
+			return
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			logger.L().Warning("failed to persist scheduled notification", helpers.String("file", file), helpers.Error(err))
+		}
+	}
+	return s, nil
+}
+
+// newScheduler creates the configured Scheduler.
+// Returns nil when scheduling is disabled
+func newScheduler(cfg SchedulerConfig) *Scheduler {
+	if cfg.Size == 0 {
+		return nil
+	}
+	if cfg.Dir == "" {
+		return NewScheduler(cfg.Size)
+	}
+	s, err := NewDiskScheduler(cfg.Dir, cfg.Size)
+	if err != nil {
+		logger.L().Error("failed to create disk scheduler, scheduling in memory", helpers.Error(err))
+		return NewScheduler(cfg.Size)
+	}
+	return s
+}
+
+// Schedule holds a notification until it is due.
+// Fails if the scheduler is full, or if a notification with the same ID is scheduled
+func (s *Scheduler) Schedule(sn *ScheduledNotification) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.pending[sn.ID]; ok {
+		return fmt.Errorf("a notification with message ID '%s' is %w", sn.ID, errAlreadyScheduled)
+	}
+	if len(s.pending) >= s.size {
+		return fmt.Errorf("%w, %d notifications are pending", errSchedulerFull, len(s.pending))
+	}
+	s.pending[sn.ID] = sn
+	s.persist(sn.ID, sn)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Cancel removes a scheduled notification before it is due. Returns false if there is no such notification
+func (s *Scheduler) Cancel(id string) (*ScheduledNotification, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sn, ok := s.pending[id]
+	if !ok {
+		return nil, false
+	}
+	delete(s.pending, id)
+	s.persist(id, nil)
+	return sn, true
+}
+
+// Get returns a scheduled notification. Returns false if there is no such notification
+func (s *Scheduler) Get(id string) (*ScheduledNotification, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sn, ok := s.pending[id]
+	return sn, ok
+}
+
+// List returns the scheduled notifications whose target contains the given attributes, all of them if there are none, the next due first
+func (s *Scheduler) List(attributes map[string]string) []*ScheduledNotification {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	scheduled := make([]*ScheduledNotification, 0, len(s.pending))
+	for _, sn := range s.pending {
+		if targetHas(sn.Target, attributes) {
+			scheduled = append(scheduled, sn)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].DeliverAt.Before(scheduled[j].DeliverAt) })
+	return scheduled
+}
+
+// targetHas reports whether a target has every given attribute
+func targetHas(target, attributes map[string]string) bool {
+	for k, v := range attributes {
+		if target[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of scheduled notifications
+func (s *Scheduler) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.pending)
+}
+
+// due removes and returns the notifications due at a given time, the earliest first,
+// and returns when the next notification is due, zero if none is left.
+// The due notifications stay persisted until they are delivered
+func (s *Scheduler) due(now time.Time) ([]*ScheduledNotification, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	due := []*ScheduledNotification{}
+	next := time.Time{}
+	for id, sn := range s.pending {
+		if !sn.DeliverAt.After(now) {
+			due = append(due, sn)
+			delete(s.pending, id)
+			continue
+		}
+		if next.IsZero() || sn.DeliverAt.Before(next) {
+			next = sn.DeliverAt
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].DeliverAt.Before(due[j].DeliverAt) })
+	return due, next
+}
+
+// delivered removes a due notification from the disk once it was delivered, unless it was scheduled again meanwhile
+func (s *Scheduler) delivered(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.pending[id]; !ok {
+		s.persist(id, nil)
+	}
+}
+
+// Run hands the due notifications over to a given function, the earliest first, until the scheduler is stopped.
+// Up to schedulerWorkers notifications are handed over at once. Run returns once the notifications being handed over were delivered
+func (s *Scheduler) Run(deliver func(sn *ScheduledNotification)) {
+	workers := make(chan struct{}, schedulerWorkers)
+	delivering := sync.WaitGroup{}
+	defer delivering.Wait()
+	for {
+		due, next := s.due(time.Now())
+		for _, sn := range due {
+			select {
+			case workers <- struct{}{}:
+			case <-s.stopped:
+				// the notifications left are delivered after the next start, if the scheduler is on disk
+				return
+			}
+			delivering.Add(1)
+			go func(sn *ScheduledNotification) {
+				defer func() {
+					<-workers
+					delivering.Done()
+				}()
+				deliver(sn)
+				s.delivered(sn.ID)
+			}(sn)
+		}
+		wait := schedulerIdleWait
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.stopped:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Stop stops handing the due notifications over. The pending notifications are kept, persisted if the scheduler is on disk
+func (s *Scheduler) Stop() {
+	if s == nil {
+		return
+	}
+	s.stop.Do(func() { close(s.stopped) })
+}
+
+// deliverAt returns when a notification received at a given time is due, the zero time if it is not scheduled
+func (n *Notification) deliverAt(now time.Time) time.Time {
+	if n.DeliverAt != nil {
+		return *n.DeliverAt
+	}
+	if n.DelayMs > 0 {
+		return now.Add(time.Duration(n.DelayMs) * time.Millisecond)
+	}
+	return time.Time{}
+}
+
+// scheduled reports whether a notification received now is due later
+func (n *Notification) scheduled() bool {
+	return n.deliverAt(time.Now()).After(time.Now())
+}
+
+// validateSchedule records the problems of the scheduling fields of a notification
+func (nh *Gateway) validateSchedule(n *Notification, e *ValidationError) {
+	field := "deliverAt"
+	if n.DeliverAt == nil {
+		field = "delayMs"
+	}
+	if n.DelayMs < 0 {
+		e.add("delayMs", "must not be negative")
+	}
+	if n.DeliverAt != nil && n.DelayMs != 0 {
+		e.add("delayMs", "must not be set with deliverAt")
+	}
+	if !n.scheduled() {
+		return
+	}
+	if nh.scheduler == nil {
+		e.add(field, "scheduling is disabled")
+		return
+	}
+	if n.CorrelationID != "" {
+		e.add(field, "requests cannot be scheduled, nobody would wait for the replies")
+	}
+	if maxDelay := time.Duration(nh.currentConfig().Scheduler.MaxDelay); maxDelay > 0 && time.Until(n.deliverAt(time.Now())) > maxDelay {
+		e.add(field, "must be within %s", maxDelay.String())
+	}
+}
+
+// scheduleNotification holds a notification until it is due. It is then routed like a notification received over the REST API.
+// The result carries the message ID the notification can be cancelled by, and when it is due
+func (nh *Gateway) scheduleNotification(n *Notification, notification []byte) (*SendResult, error) {
+	result := newSendResult()
+	if n.MessageID != "" {
+		result.NotificationID = n.MessageID
+	}
+	deliverAt := n.deliverAt(time.Now())
+	result.DeliverAt = &deliverAt
+	// stamp the message ID to cancel the notification by, and drop the scheduling fields so it is routed right away once due
+	stamped, err := stampMessage(notification, map[string]interface{}{"messageID": result.NotificationID, "deliverAt": nil, "delayMs": nil})
+	if err != nil {
+		return result, fmt.Errorf("failed to stamp message ID, reason: %s", err.Error())
+	}
+	if err := nh.scheduler.Schedule(&ScheduledNotification{
+		ID:           result.NotificationID,
+		Target:       n.Target,
+		DeliverAt:    deliverAt,
+		Scheduled:    time.Now(),
+		Notification: stamped,
+	}); err != nil {
+		return result, err
+	}
+	logger.L().Info("scheduled notification", helpers.String("notificationID", result.NotificationID), helpers.String("target", strutils.ObjectToString(n.Target)), helpers.String("deliverAt", deliverAt.Format(time.RFC3339)))
+	return result, nil
+}
+
+// deliverScheduled routes a scheduled notification that is due
+func (nh *Gateway) deliverScheduled(sn *ScheduledNotification) {
+	n, err := nh.UnmarshalMessage(sn.Notification)
+	if err != nil {
+		logger.L().Error("failed to parse scheduled notification, dropping it", helpers.String("notificationID", sn.ID), helpers.Error(err))
+		return
+	}
+	logger.L().Info("delivering scheduled notification", helpers.String("notificationID", sn.ID), helpers.String("late", time.Since(sn.DeliverAt).String()))
+	if _, err := nh.SendNotification(n, sn.Notification); err != nil {
+		logger.L().Error("failed to deliver scheduled notification", helpers.String("notificationID", sn.ID), helpers.String("target", strutils.ObjectToString(sn.Target)), helpers.Error(err))
+	}
+}
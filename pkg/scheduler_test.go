@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scheduledMock(id string, target map[string]string, deliverAt time.Time) *ScheduledNotification {
+	return &ScheduledNotification{ID: id, Target: target, DeliverAt: deliverAt, Scheduled: time.Now(), Notification: []byte(`{"messageID":"` + id + `"}`)}
+}
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler(3)
+	now := time.Now()
+	assert.NoError(t, s.Schedule(scheduledMock("b", map[string]string{"customer": "a", "cluster": "x"}, now.Add(2*time.Minute))))
+	assert.NoError(t, s.Schedule(scheduledMock("a", map[string]string{"customer": "a"}, now.Add(time.Minute))))
+	assert.ErrorIs(t, s.Schedule(scheduledMock("a", nil, now)), errAlreadyScheduled)
+	assert.NoError(t, s.Schedule(scheduledMock("c", map[string]string{"customer": "b"}, now.Add(3*time.Minute))))
+	assert.ErrorIs(t, s.Schedule(scheduledMock("d", nil, now)), errSchedulerFull)
+
+	ids := func(scheduled []*ScheduledNotification) []string {
+		l := []string{}
+		for _, sn := range scheduled {
+			l = append(l, sn.ID)
+		}
+		return l
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, ids(s.List(nil)), "the next due first")
+	assert.Equal(t, []string{"a", "b"}, ids(s.List(map[string]string{"customer": "a"})))
+	assert.Equal(t, []string{"b"}, ids(s.List(map[string]string{"customer": "a", "cluster": "x"})))
+
+	sn, ok := s.Cancel("c")
+	assert.True(t, ok)
+	assert.Equal(t, "c", sn.ID)
+	_, ok = s.Cancel("c")
+	assert.False(t, ok)
+
+	due, next := s.due(now.Add(90 * time.Second))
+	assert.Equal(t, []string{"a"}, ids(due))
+	assert.Equal(t, now.Add(2*time.Minute), next)
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestDiskScheduler(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskScheduler(dir, 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+	deliverAt := time.Now().Add(time.Hour).Round(0)
+	assert.NoError(t, s.Schedule(scheduledMock("a", map[string]string{"customer": "a"}, deliverAt)))
+	assert.NoError(t, s.Schedule(scheduledMock("b", map[string]string{"customer": "b"}, deliverAt)))
+	s.Cancel("b")
+
+	restarted, err := NewDiskScheduler(dir, 10)
+	if !assert.NoError(t, err) {
+		return
+	}
+	sn, ok := restarted.Get("a")
+	if assert.True(t, ok, "scheduled notifications survive restarts") {
+		assert.True(t, deliverAt.Equal(sn.DeliverAt))
+		assert.Equal(t, `{"messageID":"a"}`, string(sn.Notification))
+	}
+	assert.Equal(t, 1, restarted.Len(), "cancelled notifications are removed from the disk")
+
+	due, _ := restarted.due(deliverAt)
+	if assert.Equal(t, 1, len(due)) {
+		crashed, _ := NewDiskScheduler(dir, 10)
+		assert.Equal(t, 1, crashed.Len(), "due notifications stay on the disk until they are delivered")
+		restarted.delivered(due[0].ID)
+	}
+	restarted, _ = NewDiskScheduler(dir, 10)
+	assert.Equal(t, 0, restarted.Len(), "delivered notifications are removed from the disk")
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s := NewScheduler(10)
+	delivered := make(chan string, 2)
+	done := make(chan struct{})
+	go func() {
+		s.Run(func(sn *ScheduledNotification) { delivered <- sn.ID })
+		close(done)
+	}()
+
+	// scheduling wakes up the idle scheduler
+	assert.NoError(t, s.Schedule(scheduledMock("later", nil, time.Now().Add(100*time.Millisecond))))
+	assert.NoError(t, s.Schedule(scheduledMock("sooner", nil, time.Now().Add(20*time.Millisecond))))
+	for _, want := range []string{"sooner", "later"} {
+		select {
+		case id := <-delivered:
+			assert.Equal(t, want, id)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s was not delivered", want)
+		}
+	}
+
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the scheduler did not stop")
+	}
+}
+
+func TestSchedulerRunSlowDelivery(t *testing.T) {
+	s := NewScheduler(10)
+	release := make(chan struct{})
+	delivered := make(chan string, 2)
+	done := make(chan struct{})
+	go func() {
+		s.Run(func(sn *ScheduledNotification) {
+			if sn.ID == "slow" {
+				<-release
+			}
+			delivered <- sn.ID
+		})
+		close(done)
+	}()
+
+	assert.NoError(t, s.Schedule(scheduledMock("slow", nil, time.Now())))
+	assert.NoError(t, s.Schedule(scheduledMock("fast", nil, time.Now().Add(20*time.Millisecond))))
+	select {
+	case id := <-delivered:
+		assert.Equal(t, "fast", id, "a slow delivery does not hold up the others")
+	case <-time.After(2 * time.Second):
+		t.Fatal("fast was not delivered")
+	}
+
+	s.Stop()
+	select {
+	case <-done:
+		t.Fatal("the scheduler stopped before the slow delivery returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the scheduler did not stop")
+	}
+	assert.Equal(t, "slow", <-delivered)
+}
+
+func TestRestAPIScheduledNotification(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	body := `{"target":{"customer":"test"},"delayMs":60000}`
+
+	w, e := sendRequestMock(t, ns, http.MethodPost, body)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	if assert.Equal(t, 1, len(e.Violations)) {
+		assert.Equal(t, Violation{Field: "delayMs", Message: "scheduling is disabled"}, e.Violations[0])
+	}
+
+	ns.scheduler = NewScheduler(10)
+	ns.config.Routing.Strict = true
+	w, _ = sendRequestMock(t, ns, http.MethodPost, body)
+	assert.Equal(t, http.StatusOK, w.Code, "a scheduled notification is not unrouted")
+	result := SendResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	if assert.NotNil(t, result.DeliverAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), *result.DeliverAt, 5*time.Second)
+	}
+	sn, ok := ns.scheduler.Get(result.NotificationID)
+	if assert.True(t, ok) {
+		n, err := ns.UnmarshalMessage(sn.Notification)
+		assert.NoError(t, err)
+		assert.Equal(t, result.NotificationID, n.MessageID)
+		assert.False(t, n.scheduled(), "the notification is routed right away once due")
+	}
+
+	deliverAt := time.Now().Add(30 * 24 * time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		body  string
+		field string
+	}{
+		{body: `{"target":{"customer":"test"},"deliverAt":"` + deliverAt + `"}`, field: "deliverAt"},
+		{body: `{"target":{"customer":"test"},"delayMs":-1}`, field: "delayMs"},
+		{body: `{"target":{"customer":"test"},"delayMs":1000,"correlationID":"scan-1"}`, field: "delayMs"},
+	}
+	for _, tt := range tests {
+		w, e := sendRequestMock(t, ns, http.MethodPost, tt.body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.body)
+		if assert.Equal(t, 1, len(e.Violations), tt.body) {
+			assert.Equal(t, tt.field, e.Violations[0].Field)
+		}
+	}
+
+	w, e = sendRequestMock(t, ns, http.MethodPost, `{"target":{"customer":"test"},"delayMs":60000,"messageID":"`+result.NotificationID+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ErrorCodeAlreadyScheduled, e.Code)
+
+	w, _ = sendRequestMock(t, ns, http.MethodPost, `{"target":{"customer":"test"},"deliverAt":"2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code, "a notification due in the past is routed right away")
+}
+
+func TestDeliverScheduled(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.scheduler = NewScheduler(10)
+	ns.notificationBuffer = NewMemoryNotificationBuffer(time.Minute, 10)
+	n := &Notification{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"target":{"customer":"test"},"delayMs":1000}`), n))
+	result, err := ns.scheduleNotification(n, []byte(`{"target":{"customer":"test"},"delayMs":1000}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	due, _ := ns.scheduler.due(result.DeliverAt.Add(time.Millisecond))
+	if !assert.Equal(t, 1, len(due)) {
+		return
+	}
+	ns.deliverScheduled(due[0])
+	buffered, _ := ns.notificationBuffer.Pop(map[string]string{"customer": "test"})
+	if assert.Equal(t, 1, len(buffered), "the due notification is routed like any other") {
+		assert.JSONEq(t, fmt.Sprintf(`{"target":{"customer":"test"},"messageID":"%s"}`, result.NotificationID), string(buffered[0].Notification))
+	}
+}
+
+func TestAdminScheduled(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Admin.Token = "secret"
+	request := func(method, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		ns.AdminScheduledHandler(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, PathAdminScheduledV1).Code, "scheduling is disabled")
+
+	ns.scheduler = NewScheduler(10)
+	ns.scheduler.Schedule(scheduledMock("a", map[string]string{"customer": "a"}, time.Now().Add(time.Minute)))
+	ns.scheduler.Schedule(scheduledMock("b", map[string]string{"customer": "b"}, time.Now().Add(time.Minute)))
+
+	w := request(http.MethodGet, PathAdminScheduledV1+"?customer=a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := struct {
+		Scheduled []ScheduledNotification `json:"scheduled"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.Equal(t, 1, len(body.Scheduled)) {
+		assert.Equal(t, "a", body.Scheduled[0].ID)
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, PathAdminScheduledV1+"/b").Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodDelete, PathAdminScheduledV1).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, PathAdminScheduledV1+"/b").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, PathAdminScheduledV1+"/b").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, PathAdminScheduledV1+"/a/b").Code)
+	assert.Equal(t, 1, ns.scheduler.Len())
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPut, PathAdminScheduledV1).Code)
+}
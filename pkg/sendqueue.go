@@ -0,0 +1,64 @@
+package gateway
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+const (
+	defaultSendQueueSize = 256
+	defaultWriteTimeout  = 10 * time.Second
+)
+
+const (
+	// evictionReasonOverflow a subscriber whose send queue overflowed under the "disconnect" policy
+	evictionReasonOverflow = "overflow"
+	// evictionReasonWriteTimeout a subscriber that did not read a notification within the write timeout
+	evictionReasonWriteTimeout = "write_timeout"
+)
+
+// startSendQueue makes an incoming connection write through its own bounded send queue
+func (nh *Gateway) startSendQueue(conn *websocketactions.Connection) {
+	cfg := nh.currentConfig().SendQueue
+	policy := websocketactions.OverflowPolicy(cfg.OverflowPolicy)
+	conn.StartSendQueue(cfg.Size, policy, time.Duration(cfg.WriteTimeout), func(policy websocketactions.OverflowPolicy) {
+		if policy == websocketactions.OverflowDisconnect {
+			nh.metrics.slowConsumersEvicted.WithLabelValues(evictionReasonOverflow).Inc()
+			logger.L().Warning("send queue is full, disconnecting slow subscriber", helpers.String("attributes", strutils.ObjectToString(conn.GetAttributes())), helpers.Int("id", conn.ID))
+			nh.CleanupIncomingConnection(conn.ID)
+			return
+		}
+		nh.metrics.notificationsDropped.Inc()
+		logger.L().Warning("send queue is full, dropping notification", helpers.String("attributes", strutils.ObjectToString(conn.GetAttributes())), helpers.Int("id", conn.ID), helpers.String("policy", string(policy)))
+	})
+}
+
+// enqueueNotification queues a notification to a connection. done, if set, is called with the outcome of the write.
+// The notification counts as a pending write until it was written or dropped, so a graceful shutdown flushes it
+func (nh *Gateway) enqueueNotification(conn *websocketactions.Connection, message *outboundNotification, done func(err error)) {
+	nh.pendingWrites.add()
+	conn.Enqueue(&websocketactions.QueuedWrite{
+		Write: func() error {
+			return nh.sendSingleNotification(conn, message)
+		},
+		Done: func(err error) {
+			if done != nil {
+				done(err)
+			}
+			nh.pendingWrites.done()
+		},
+	})
+}
+
+// isTimeout reports whether a write failed because it missed its deadline
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+// stalledSubscriberMock connects a subscriber that never reads its notifications
+func stalledSubscriberMock(t *testing.T, ns *Gateway) func() {
+	ns.wa = websocketactions.NewWebsocketActions()
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?customer=test", nil)
+	if !assert.NoError(t, err) {
+		server.Close()
+		t.FailNow()
+	}
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 1 }, time.Second, time.Millisecond)
+	return func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestSendQueueEvictsOnOverflow(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.SendQueue = SendQueueConfig{Size: 2, WriteTimeout: Duration(time.Minute), OverflowPolicy: string(websocketactions.OverflowDisconnect)}
+	defer stalledSubscriberMock(t, ns)()
+
+	notification := []byte(strings.Repeat("x", 1<<20))
+	start := time.Now()
+	for i := 0; i < 50 && ns.incomingConnections.Len() > 0; i++ {
+		_, err := ns.SendNotification(NotificationMock(map[string]string{"customer": "test"}, false), notification)
+		assert.NoError(t, err)
+	}
+	assert.Less(t, time.Since(start), 5*time.Second, "senders are not blocked by a stalled subscriber")
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrapeMock(t, ns.metrics), `gateway_slow_consumers_evicted_total{reason="overflow"} 1`)
+}
+
+func TestSendQueueEvictsOnWriteTimeout(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.SendQueue = SendQueueConfig{Size: 100, WriteTimeout: Duration(100 * time.Millisecond), OverflowPolicy: string(websocketactions.OverflowDropNewest)}
+	defer stalledSubscriberMock(t, ns)()
+
+	// more than the socket buffers hold, so a write blocks until it times out
+	notification := []byte(strings.Repeat("x", 1<<20))
+	for i := 0; i < 64; i++ {
+		ns.SendNotification(NotificationMock(map[string]string{"customer": "test"}, false), notification)
+	}
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, scrapeMock(t, ns.metrics), `gateway_slow_consumers_evicted_total{reason="write_timeout"} 1`)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, ns.pendingWrites.wait(ctx), "the notifications left in the queue are discarded")
+}
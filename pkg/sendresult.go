@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+// DeliveryStatus describes the outcome of delivering a notification to a single connection
+type DeliveryStatus string
+
+const (
+	// DeliveryStatusSent the notification was written to the connection
+	DeliveryStatusSent DeliveryStatus = "sent"
+	// DeliveryStatusFailed writing the notification to the connection failed
+	DeliveryStatusFailed DeliveryStatus = "failed"
+	// DeliveryStatusAsync the notification is being written to the connection asynchronously
+	DeliveryStatusAsync DeliveryStatus = "async"
+	// DeliveryStatusAcked the subscriber acknowledged the notification
+	DeliveryStatusAcked DeliveryStatus = "acked"
+	// DeliveryStatusUnacked the subscriber did not acknowledge the notification in time, it is being retried
+	DeliveryStatusUnacked DeliveryStatus = "unacked"
+	// DeliveryStatusReplied the subscriber replied to the request
+	DeliveryStatusReplied DeliveryStatus = "replied"
+	// DeliveryStatusNoReply the subscriber did not reply to the request in time
+	DeliveryStatusNoReply DeliveryStatus = "noReply"
+	// DeliveryStatusDuplicate the subscriber already received a notification with the same message ID, it was not written again
+	DeliveryStatusDuplicate DeliveryStatus = "duplicate"
+)
+
+// ConnectionDelivery describes the delivery of a notification to a single connection
+type ConnectionDelivery struct {
+	ID         int               `json:"id"`
+	Attributes map[string]string `json:"attributes"`
+	Status     DeliveryStatus    `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	// Reply is the reply of the subscriber to a request
+	Reply json.RawMessage `json:"reply,omitempty"`
+}
+
+// SendResult describes the outcome of routing a single notification
+type SendResult struct {
+	NotificationID string `json:"notificationID"`
+	// CorrelationID is the correlation ID of a request
+	CorrelationID string               `json:"correlationID,omitempty"`
+	Connections   []ConnectionDelivery `json:"connections"`
+	// Queued is set when there were no subscribers and the notification was buffered until one connects
+	Queued bool `json:"queued,omitempty"`
+	// Forwarded is set when there were no local subscribers and the notification was forwarded to the parent gateway
+	Forwarded bool `json:"forwarded,omitempty"`
+	// Duplicates is the number of connections that already received the notification, it was not written to them again
+	Duplicates int `json:"duplicates,omitempty"`
+	// DeliverAt is set when the notification was scheduled, it is routed then
+	DeliverAt *time.Time `json:"deliverAt,omitempty"`
+}
+
+// newSendResult creates an empty SendResult with a newly generated notification ID
+func newSendResult() *SendResult {
+	return &SendResult{
+		NotificationID: uuid.NewString(),
+		Connections:    []ConnectionDelivery{},
+	}
+}
+
+// add records the delivery outcome of a given connection
+func (sr *SendResult) add(conn *websocketactions.Connection, status DeliveryStatus, err error) {
+	delivery := ConnectionDelivery{
+		ID:         conn.ID,
+		Attributes: conn.GetAttributes(),
+		Status:     status,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	sr.Connections = append(sr.Connections, delivery)
+}
@@ -1,10 +1,15 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"syscall"
+	"time"
 
 	"github.com/kubescape/gateway/docs"
 
@@ -13,44 +18,92 @@ import (
 	"github.com/kubescape/go-logger/helpers"
 )
 
+// PathHealthV1 is the REST API path reporting the state of the links to the parent gateway
+const PathHealthV1 = "/v1/health"
+
+// PortRestAPI and PortWebsocket are the default ports of the listeners
 var (
 	PortRestAPI   = "8002"
 	PortWebsocket = "8001"
 )
 
-// SetupAndServe configures the HTTP servers and makes them serve incoming requests
+// SetupAndServe configures the HTTP servers and makes them serve incoming requests.
+// Returns once the gateway was shut down by SIGTERM or SIGINT
 func (ns *Gateway) SetupAndServe() {
-	if port, ok := os.LookupEnv(GatewayWebsocketPortEnvironmentVariable); ok {
-		PortWebsocket = port
-	}
-	if port, ok := os.LookupEnv(GatewayRestApiPortEnvironmentVariable); ok {
-		PortRestAPI = port
-	}
-	finish := make(chan bool)
+	finish := make(chan struct{})
 
 	restAPIServer := http.NewServeMux()
 	var restAPIHandler = new(RegexpHandler)
+	// the batch path starts with the path of a single notification, it is matched first
+	batchRoute, _ := regexp.Compile(fmt.Sprintf("^%s$", PathBatchV1))
+	restAPIHandler.HandleFunc(batchRoute, ns.BatchNotificationHandler)
 	restAPIRoute, _ := regexp.Compile(fmt.Sprintf("%s.*", notifier.PathRESTV1))
 	restAPIHandler.HandleFunc(restAPIRoute, ns.RestAPINotificationHandler)
+	healthRoute, _ := regexp.Compile(fmt.Sprintf("^%s$", PathHealthV1))
+	restAPIHandler.HandleFunc(healthRoute, ns.HealthHandler)
+	adminRoute, _ := regexp.Compile(fmt.Sprintf("^%s(/.*)?$", PathAdminConnectionsV1))
+	restAPIHandler.HandleFunc(adminRoute, ns.AdminConnectionsHandler)
+	adminScheduledRoute, _ := regexp.Compile(fmt.Sprintf("^%s(/.*)?$", PathAdminScheduledV1))
+	restAPIHandler.HandleFunc(adminScheduledRoute, ns.AdminScheduledHandler)
 	restAPIServer.Handle("/", restAPIHandler)
 
+	restAPIServer.Handle(PathMetrics, ns.metrics.Handler())
+
 	openAPIHandler := docs.NewOpenAPIUIHandler()
 	restAPIServer.Handle(docs.OpenAPIV2Prefix, openAPIHandler)
 
+	if ns.serverTLS != nil {
+		go func() {
+			if err := ns.serverTLS.Watch(finish); err != nil {
+				logger.L().Error("failed to watch TLS certificates, rotated certificates require a restart", helpers.Error(err))
+			}
+		}()
+	}
+
+	if ns.scheduler != nil {
+		go ns.scheduler.Run(ns.deliverScheduled)
+	}
+
 	go func() {
-		logger.L().Fatal("", helpers.Error(http.ListenAndServe(fmt.Sprintf(":%s", PortRestAPI), restAPIServer)))
+		if err := ns.WatchConfig(finish); err != nil {
+			logger.L().Error("failed to watch config, changes require a restart", helpers.Error(err))
+		}
 	}()
 
+	ns.restAPIServer = &http.Server{Addr: fmt.Sprintf(":%s", ns.config.Listeners.RestAPIPort), Handler: ns.trackRESTRequests(restAPIServer)}
+	go ns.serve(ns.restAPIServer)
+
 	websocketServer := http.NewServeMux()
 	var websocketHandler = new(RegexpHandler)
+	// the stream path extends the websocket path, so it is matched first
+	sseRoute, _ := regexp.Compile(fmt.Sprintf("^%s$", PathSSEV1))
+	websocketHandler.HandleFunc(sseRoute, ns.SSENotificationHandler)
 	websocketRoute, _ := regexp.Compile(fmt.Sprintf("%s.*", notifier.PathWebsocketV1))
 	websocketHandler.HandleFunc(websocketRoute, ns.WebsocketNotificationHandler)
 	websocketServer.Handle("/", websocketHandler)
-	go func() {
-		logger.L().Fatal("", helpers.Error(http.ListenAndServe(fmt.Sprintf(":%s", PortWebsocket), websocketServer)))
-	}()
+	ns.websocketServer = &http.Server{Addr: fmt.Sprintf(":%s", ns.config.Listeners.WebsocketPort), Handler: websocketServer}
+	go ns.serve(ns.websocketServer)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	signal.Stop(signals)
 
-	<-finish
+	timeout := time.Duration(ns.config.ShutdownTimeout)
+	logger.L().Info("received signal, shutting down", helpers.String("signal", sig.String()), helpers.String("timeout", timeout.String()))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := ns.Shutdown(ctx); err != nil {
+		logger.L().Error("failed to shut down gracefully", helpers.Error(err))
+	}
+	close(finish)
+}
+
+// serve serves a server until it is shut down
+func (ns *Gateway) serve(server *http.Server) {
+	if err := ns.serverTLS.listenAndServe(server); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.L().Fatal("", helpers.Error(err))
+	}
 }
 
 type route struct {
@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+// subjectValue returns a client certificate subject field that can be mapped to a connection attribute.
+// Returns false if the field is not supported
+func subjectValue(subject pkix.Name, field string) (string, bool) {
+	first := func(values []string) string {
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+	switch field {
+	case "CN":
+		return subject.CommonName, true
+	case "O":
+		return first(subject.Organization), true
+	case "OU":
+		return first(subject.OrganizationalUnit), true
+	case "C":
+		return first(subject.Country), true
+	case "L":
+		return first(subject.Locality), true
+	case "ST":
+		return first(subject.Province), true
+	}
+	return "", false
+}
+
+// ServerTLS serves the listeners over TLS, and verifies client certificates when a client CA is set (mTLS).
+// The certificate, key and client CA are reloaded when they change on disk, so rotated certificates are picked up without a restart
+type ServerTLS struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	// subjectAttributes maps client certificate subject fields (e.g. "CN") to connection attributes
+	subjectAttributes map[string]string
+
+	mutex       *sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// NewServerTLS creates a new ServerTLS and loads its certificates.
+// subjectAttributes is optional and requires a client CA, since only verified certificates are trusted
+func NewServerTLS(certFile, keyFile, clientCAFile string, subjectAttributes map[string]string) (*ServerTLS, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS requires both a certificate and a key")
+	}
+	if len(subjectAttributes) > 0 && clientCAFile == "" {
+		return nil, fmt.Errorf("mapping client certificate subjects to attributes requires a client CA")
+	}
+	st := &ServerTLS{
+		certFile:          certFile,
+		keyFile:           keyFile,
+		clientCAFile:      clientCAFile,
+		subjectAttributes: subjectAttributes,
+		mutex:             &sync.RWMutex{},
+	}
+	if err := st.reload(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// parseSubjectAttributes parses a mapping of the form "CN=cluster,O=customerGUID"
+func parseSubjectAttributes(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+	if s == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, attribute, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		field = strings.ToUpper(strings.TrimSpace(field))
+		attribute = strings.TrimSpace(attribute)
+		if !ok || attribute == "" {
+			return nil, fmt.Errorf("invalid subject attribute mapping '%s'", pair)
+		}
+		if _, ok := subjectValue(pkix.Name{}, field); !ok {
+			return nil, fmt.Errorf("unsupported subject field '%s'", field)
+		}
+		mapping[field] = attribute
+	}
+	return mapping, nil
+}
+
+// newServerTLS creates the configured ServerTLS.
+// Returns nil when TLS is disabled
+func newServerTLS(cfg TLSConfig) *ServerTLS {
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		return nil
+	}
+	st, err := NewServerTLS(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile, cfg.ClientSubjectAttributes)
+	if err != nil {
+		// never fall back to plain text when TLS was requested
+		logger.L().Fatal("failed to configure TLS", helpers.Error(err))
+		return nil
+	}
+	logger.L().Info("serving over TLS", helpers.String("cert", cfg.CertFile), helpers.String("clientCA", cfg.ClientCAFile))
+	return st
+}
+
+// reload loads the certificate, key and client CA. The previous ones are kept if loading fails
+func (st *ServerTLS) reload() error {
+	certificate, err := tls.LoadX509KeyPair(st.certFile, st.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate, reason: %s", err.Error())
+	}
+	var clientCAs *x509.CertPool
+	if st.clientCAFile != "" {
+		pem, err := os.ReadFile(st.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA, reason: %s", err.Error())
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA '%s'", st.clientCAFile)
+		}
+	}
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.certificate = &certificate
+	st.clientCAs = clientCAs
+	return nil
+}
+
+// Config returns the TLS configuration of a listener. Every handshake uses the latest loaded certificates
+func (st *ServerTLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			st.mutex.RLock()
+			defer st.mutex.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*st.certificate},
+			}
+			if st.clientCAs != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = st.clientCAs
+			}
+			return config, nil
+		},
+	}
+}
+
+// Watch reloads the certificates whenever their files change, until stop is closed
+func (st *ServerTLS) Watch(stop <-chan struct{}) error {
+	return watchFiles([]string{st.certFile, st.keyFile, st.clientCAFile}, stop, func() {
+		// a rotation may be halfway through, the next change reloads the complete files
+		if err := st.reload(); err != nil {
+			logger.L().Warning("failed to reload TLS certificates, keeping the previous ones", helpers.Error(err))
+			return
+		}
+		logger.L().Info("reloaded TLS certificates")
+	})
+}
+
+// applyClientAttributes sets the attributes mapped from the verified client certificate subject on the attributes of a subscription.
+// A subscription asking for a different value than its certificate grants is rejected
+func (st *ServerTLS) applyClientAttributes(r *http.Request, attributes map[string]string) (map[string]string, error) {
+	if st == nil || len(st.subjectAttributes) == 0 {
+		return attributes, nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return attributes, fmt.Errorf("a verified client certificate is required")
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	applied := make(map[string]string, len(attributes)+len(st.subjectAttributes))
+	for k, v := range attributes {
+		applied[k] = v
+	}
+	for field, attribute := range st.subjectAttributes {
+		value, _ := subjectValue(subject, field)
+		if value == "" {
+			return attributes, fmt.Errorf("client certificate has no %s", field)
+		}
+		if requested, ok := applied[attribute]; ok && requested != value {
+			return attributes, fmt.Errorf("client certificate does not allow %s=%s", attribute, requested)
+		}
+		applied[attribute] = value
+	}
+	return applied, nil
+}
+
+// listenAndServe serves a server, over TLS if configured
+func (st *ServerTLS) listenAndServe(server *http.Server) error {
+	if st == nil {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = st.Config()
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// certificateMock creates a certificate signed by a given parent, self signed if parent is nil
+func certificateMock(t *testing.T, subject pkix.Name, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFileMock(t *testing.T, path string, b []byte) {
+	assert.NoError(t, os.WriteFile(path, b, 0o600))
+}
+
+func TestParseSubjectAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", s: "", want: map[string]string{}},
+		{name: "mapping", s: "CN=cluster, o=customerGUID", want: map[string]string{"CN": "cluster", "O": "customerGUID"}},
+		{name: "missing attribute", s: "CN=", wantErr: true},
+		{name: "unsupported field", s: "SERIAL=cluster", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSubjectAttributes(tt.s)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestServerTLSClientAttributes(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caPEM, _ := certificateMock(t, pkix.Name{CommonName: "ca"}, nil, nil)
+	_, _, serverPEM, serverKeyPEM := certificateMock(t, pkix.Name{CommonName: "localhost"}, ca, caKey)
+	_, _, clientPEM, clientKeyPEM := certificateMock(t, pkix.Name{CommonName: "cluster-1", Organization: []string{"customer-1"}}, ca, caKey)
+	writeFileMock(t, filepath.Join(dir, "ca.crt"), caPEM)
+	writeFileMock(t, filepath.Join(dir, "tls.crt"), serverPEM)
+	writeFileMock(t, filepath.Join(dir, "tls.key"), serverKeyPEM)
+
+	_, err := NewServerTLS(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "", map[string]string{"CN": "cluster"})
+	assert.Error(t, err, "mapping subjects requires verified client certificates")
+
+	st, err := NewServerTLS(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"), map[string]string{"CN": "cluster", "O": "customer"})
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		att, err := st.applyClientAttributes(r, map[string]string{"customer": r.URL.Query().Get("customer")})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(att)
+	}))
+	server.TLS = st.Config()
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.X509KeyPair(clientPEM, clientKeyPEM)
+	assert.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}}}}
+
+	resp, err := client.Get(server.URL + "?customer=customer-1")
+	if assert.NoError(t, err) {
+		att := map[string]string{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&att))
+		resp.Body.Close()
+		assert.Equal(t, map[string]string{"customer": "customer-1", "cluster": "cluster-1"}, att)
+	}
+
+	resp, err = client.Get(server.URL + "?customer=customer-2")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	_, err = anonymous.Get(server.URL)
+	assert.Error(t, err, "clients without a certificate are rejected in the handshake")
+}
+
+func TestServerTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, _, _ := certificateMock(t, pkix.Name{CommonName: "ca"}, nil, nil)
+	_, _, certPEM, keyPEM := certificateMock(t, pkix.Name{CommonName: "first"}, ca, caKey)
+	writeFileMock(t, filepath.Join(dir, "tls.crt"), certPEM)
+	writeFileMock(t, filepath.Join(dir, "tls.key"), keyPEM)
+
+	st, err := NewServerTLS(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "", nil)
+	assert.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go st.Watch(stop)
+
+	servedName := func() string {
+		config, _ := st.Config().GetConfigForClient(nil)
+		cert, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		return cert.Subject.CommonName
+	}
+	assert.Equal(t, "first", servedName())
+
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+	_, _, certPEM, keyPEM = certificateMock(t, pkix.Name{CommonName: "rotated"}, ca, caKey)
+	writeFileMock(t, filepath.Join(dir, "tls.key.new"), keyPEM)
+	writeFileMock(t, filepath.Join(dir, "tls.crt.new"), certPEM)
+	assert.NoError(t, os.Rename(filepath.Join(dir, "tls.key.new"), filepath.Join(dir, "tls.key")))
+	assert.NoError(t, os.Rename(filepath.Join(dir, "tls.crt.new"), filepath.Join(dir, "tls.crt")))
+
+	assert.Eventually(t, func() bool { return servedName() == "rotated" }, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultShutdownTimeout stays below the default termination grace period of a Kubernetes pod (30s)
+const defaultShutdownTimeout = 25 * time.Second
+
+// drainPollInterval is the interval of checking whether the subscribers disconnected after the close frames were sent
+const drainPollInterval = 50 * time.Millisecond
+
+// pendingWrites counts the notifications being written in the background, so they are flushed before shutting down.
+// The zero value is ready to use
+type pendingWrites struct {
+	mutex sync.Mutex
+	count int
+	// idle is closed once count drops to 0
+	idle chan struct{}
+}
+
+func (pw *pendingWrites) add() {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+	if pw.count == 0 {
+		pw.idle = make(chan struct{})
+	}
+	pw.count++
+}
+
+func (pw *pendingWrites) done() {
+	pw.mutex.Lock()
+	defer pw.mutex.Unlock()
+	pw.count--
+	if pw.count == 0 {
+		close(pw.idle)
+	}
+}
+
+// wait waits until there are no pending writes, or the context is done
+func (pw *pendingWrites) wait(ctx context.Context) error {
+	pw.mutex.Lock()
+	if pw.count == 0 {
+		pw.mutex.Unlock()
+		return nil
+	}
+	idle := pw.idle
+	pw.mutex.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the gateway gracefully, within the deadline of a given context:
+// it stops accepting subscriptions and notifications, closes the links to the parent, flushes the notifications being written,
+// and sends every subscriber a "going away" close frame so it reconnects to another instance.
+// The REST API keeps answering 503 until then, so the senders retry against another instance.
+// Whatever is left when the deadline expires is closed abruptly
+func (nh *Gateway) Shutdown(ctx context.Context) error {
+	if !nh.shuttingDown.CompareAndSwap(false, true) {
+		return fmt.Errorf("gateway is already shutting down")
+	}
+	logger.L().Info("shutting down", helpers.Int("number of incoming websockets", nh.incomingConnections.Len()), helpers.Int("number of outgoing websockets", nh.outgoingConnections.Len()))
+	// the notifications that are not due yet are left for the next start, they survive it if the scheduler is on disk
+	nh.scheduler.Stop()
+
+	// the websocket server closes its listener right away, and returns once the stream handlers returned
+	websocketServerDone := make(chan error, 1)
+	go func() { websocketServerDone <- shutdownServer(ctx, nh.websocketServer) }()
+
+	err := nh.drain(ctx, websocketServerDone)
+	if err != nil {
+		logger.L().Warning("graceful shutdown deadline expired, closing the remaining connections", helpers.Error(err))
+		for _, server := range []*http.Server{nh.restAPIServer, nh.websocketServer} {
+			if server != nil {
+				server.Close()
+			}
+		}
+		for _, conn := range append(nh.outgoingConnections.List(), nh.incomingConnections.List()...) {
+			nh.wa.Close(conn)
+		}
+	}
+	logger.L().Info("shut down", helpers.Int("number of incoming websockets", nh.incomingConnections.Len()))
+	return err
+}
+
+// drain runs the graceful shutdown steps in order, and returns once they are done or the context is done
+func (nh *Gateway) drain(ctx context.Context, websocketServerDone <-chan error) error {
+	// 1. no new notifications from senders, they are answered 503 from now on, and the in-flight REST requests complete
+	if err := nh.restRequests.wait(ctx); err != nil {
+		return err
+	}
+
+	// 2. no new notifications from the parent
+	for _, conn := range nh.outgoingConnections.List() {
+		if err := nh.wa.WriteCloseMessage(conn, websocket.CloseGoingAway, "gateway is shutting down"); err != nil {
+			logger.L().Debug("failed to send close frame to master", helpers.Int("id", conn.ID), helpers.Error(err))
+		}
+		nh.wa.Close(conn)
+	}
+
+	// 3. flush the notifications being written
+	if err := nh.pendingWrites.wait(ctx); err != nil {
+		return err
+	}
+
+	// 4. ask the subscribers to go away, and wait for their handlers to clean up
+	for _, conn := range nh.incomingConnections.List() {
+		if err := nh.wa.WriteCloseMessage(conn, websocket.CloseGoingAway, "gateway is shutting down"); err != nil {
+			logger.L().Debug("failed to send close frame", helpers.Int("id", conn.ID), helpers.Error(err))
+			nh.wa.Close(conn)
+		}
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for nh.incomingConnections.Len() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// 5. the REST API stops answering
+	if err := shutdownServer(ctx, nh.restAPIServer); err != nil {
+		return err
+	}
+
+	// 6. the stream handlers returned
+	select {
+	case err := <-websocketServerDone:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// trackRESTRequests counts the REST requests being handled, so shutting down waits for the notifications they send
+func (nh *Gateway) trackRESTRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nh.restRequests.add()
+		defer nh.restRequests.done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdownServer gracefully shuts down a server, if it was started
+func shutdownServer(ctx context.Context, server *http.Server) error {
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// rejectWhileShuttingDown responds with 503 to requests received while shutting down. Returns true if the request was rejected
+func (nh *Gateway) rejectWhileShuttingDown(w http.ResponseWriter, r *http.Request) bool {
+	if !nh.shuttingDown.Load() {
+		return false
+	}
+	writeError(w, r, http.StatusServiceUnavailable, &ErrorResponse{Code: ErrorCodeShuttingDown, Message: "gateway is shutting down"})
+	return true
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingWrites(t *testing.T) {
+	pw := pendingWrites{}
+	assert.NoError(t, pw.wait(context.Background()), "nothing to wait for")
+
+	pw.add()
+	pw.add()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, pw.wait(ctx))
+
+	go func() {
+		pw.done()
+		pw.done()
+	}()
+	assert.NoError(t, pw.wait(context.Background()))
+}
+
+func TestShutdown(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?customer=test", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 1 }, time.Second, time.Millisecond)
+
+	// a subscriber replies to the close frame, like browsers and gorilla clients do
+	closeCode := make(chan int, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		if closeErr, ok := err.(*websocket.CloseError); ok {
+			closeCode <- closeErr.Code
+		}
+		close(closeCode)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, ns.Shutdown(ctx))
+	assert.Equal(t, websocket.CloseGoingAway, <-closeCode)
+	assert.Equal(t, 0, ns.incomingConnections.Len(), "shutdown waits for the subscribers to disconnect")
+	assert.Error(t, ns.Shutdown(ctx), "shutting down twice")
+
+	w := httptest.NewRecorder()
+	ns.WebsocketNotificationHandler(w, httptest.NewRequest(http.MethodGet, "/?customer=test", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "new subscriptions are rejected while shutting down")
+}
+
+func TestShutdownRESTAPI(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	server := httptest.NewServer(ns.trackRESTRequests(http.HandlerFunc(ns.RestAPINotificationHandler)))
+	defer server.Close()
+	ns.restAPIServer = server.Config
+	// a notification still being written holds the shutdown
+	ns.pendingWrites.add()
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- ns.Shutdown(context.Background()) }()
+	assert.Eventually(t, ns.shuttingDown.Load, time.Second, time.Millisecond)
+
+	resp, err := http.Post(server.URL+"/v1/sendnotification", "application/json", strings.NewReader(`{"target":{"customer":"test"},"notification":{}}`))
+	if assert.NoError(t, err, "the REST API stays up until the notifications were flushed") {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		body := ErrorResponse{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, ErrorCodeShuttingDown, body.Code)
+	}
+
+	ns.pendingWrites.done()
+	select {
+	case err := <-shutdown:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the gateway did not shut down")
+	}
+	_, err = http.Post(server.URL+"/v1/sendnotification", "application/json", strings.NewReader(`{}`))
+	assert.Error(t, err, "the REST API is closed once shut down")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.incomingConnections.Append(map[string]string{"customer": "test"}, nil, nil)
+	ns.pendingWrites.add()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, ns.Shutdown(ctx), context.DeadlineExceeded, "a stuck write does not block the shutdown past its deadline")
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"net/http"
+	"time"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+// PathSSEV1 is the path of the Server-Sent Events subscription, served by the websocket listener
+const PathSSEV1 = "/v1/waitfornotification/sse"
+
+// sseKeepAliveInterval is the interval of the comments that keep idle streams open through proxies
+var sseKeepAliveInterval = 15 * time.Second
+
+// SSENotificationHandler subscribes to notifications over Server-Sent Events, for clients that cannot upgrade to websockets.
+// The subscription takes the same query attributes as the websocket subscription, and is routed the same way
+func (nh *Gateway) SSENotificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logger.L().Error("Method not allowed")
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+	if nh.rejectWhileShuttingDown(w, r) {
+		return
+	}
+	if e := nh.authorizeSubscription(r); e != nil {
+		logger.L().Error("in SSENotificationHandler", helpers.String("error", e.response.Message))
+		writeError(w, r, e.status, e.response)
+		return
+	}
+
+	notificationAtt, err := nh.subscriptionAttributes(r)
+	if err != nil {
+		logger.L().Error(err.Error())
+		writeError(w, r, http.StatusBadRequest, &ErrorResponse{Code: ErrorCodeInvalidRequest, Message: err.Error()})
+		return
+	}
+	stream, err := websocketactions.NewSSEStream(w, r)
+	if err != nil {
+		logger.L().Error("in SSENotificationHandler", helpers.Error(err))
+		writeError(w, r, http.StatusInternalServerError, &ErrorResponse{Code: ErrorCodeStreamingUnsupported, Message: err.Error()})
+		return
+	}
+
+	newConn, id := nh.incomingConnections.AppendStream(notificationAtt, stream, nh.setupIncomingConnection)
+	logger.L().Info("accepting stream connection", helpers.String("url query", r.URL.RawQuery), helpers.Int("id", id), helpers.Int("number of incoming websockets", nh.incomingConnections.Len()))
+	nh.registerIncomingConnection(newConn)
+
+	nh.keepStreamAlive(r, newConn, stream)
+	nh.CleanupIncomingConnection(id)
+	// closing under the connection lock makes sure no write is in progress once the handler returns
+	nh.wa.Close(newConn)
+}
+
+// keepStreamAlive writes keep-alive comments to a stream until the client goes away or the stream is closed
+func (nh *Gateway) keepStreamAlive(r *http.Request, conn *websocketactions.Connection, stream *websocketactions.SSEStream) {
+	ticker := time.NewTicker(sseKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.Done():
+			return
+		case <-ticker.C:
+			if err := nh.wa.WritePingMessage(conn); err != nil {
+				logger.L().Warning("in keepStreamAlive", helpers.Int("id", conn.ID), helpers.Error(err))
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSENotificationHandler(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	server := httptest.NewServer(http.HandlerFunc(ns.SSENotificationHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + PathSSEV1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "attributes are required")
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + PathSSEV1 + "?customer=test&cluster=yay")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 1 }, time.Second, time.Millisecond)
+
+	// stream subscribers are routed like websocket subscribers
+	result, err := ns.SendNotification(NotificationMock(map[string]string{"customer": "test"}, true), []byte(`{"notification":"hello"}`))
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(result.Connections)) {
+		assert.Equal(t, DeliveryStatusSent, result.Connections[0].Status)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"event: notification", `data: {"notification":"hello"}`}, lines)
+
+	// closing the connection removes the subscriber
+	ns.incomingConnections.CloseConnections(ns.wa, map[string]string{"customer": "test"})
+	assert.Eventually(t, func() bool { return ns.incomingConnections.Len() == 0 }, time.Second, time.Millisecond)
+}
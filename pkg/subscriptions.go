@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"encoding/json"
+	"sort"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SubscriptionFrame is the frame an edge gateway sends over a multiplexed link to tell its parent which attribute sets to route to it.
+// Replace drops the attribute sets subscribed to before
+type SubscriptionFrame struct {
+	Subscribe   []map[string]string `json:"subscribe,omitempty" bson:"subscribe,omitempty"`
+	Unsubscribe []map[string]string `json:"unsubscribe,omitempty" bson:"unsubscribe,omitempty"`
+	Replace     bool                `json:"replace,omitempty" bson:"replace,omitempty"`
+}
+
+// parseSubscriptionFrame returns the frame if a given message is a SubscriptionFrame
+func parseSubscriptionFrame(message []byte) (*SubscriptionFrame, bool) {
+	frame := &SubscriptionFrame{}
+	if err := json.Unmarshal(message, frame); err == nil && frame.isSubscription() {
+		return frame, true
+	}
+	frame = &SubscriptionFrame{}
+	if err := bson.Unmarshal(message, frame); err == nil && frame.isSubscription() {
+		return frame, true
+	}
+	return nil, false
+}
+
+// isSubscription reports whether the frame has any of the subscription fields, a notification has none of them
+func (f *SubscriptionFrame) isSubscription() bool {
+	return f.Subscribe != nil || f.Unsubscribe != nil || f.Replace
+}
+
+// subscriptionAuthorized reports whether a connection registered with given attributes may subscribe to an attribute set.
+// The set must keep every registered attribute, so a subscription only narrows what the connection was authorized for
+func subscriptionAuthorized(registered, subscription map[string]string) bool {
+	for k, v := range registered {
+		if subscription[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// applySubscriptionFrame updates the attribute sets a gateway link is routed by
+func (nh *Gateway) applySubscriptionFrame(connObj *websocketactions.Connection, frame *SubscriptionFrame) {
+	sets := map[string]map[string]string{}
+	if !frame.Replace {
+		for _, set := range connObj.Subscriptions() {
+			sets[strutils.ObjectToString(set)] = set
+		}
+	}
+	for _, set := range frame.Unsubscribe {
+		delete(sets, strutils.ObjectToString(set))
+	}
+	for _, set := range frame.Subscribe {
+		if !subscriptionAuthorized(connObj.GetAttributes(), set) {
+			logger.L().Warning("ignoring subscription not covered by the attributes the connection registered with", helpers.String("subscription", strutils.ObjectToString(set)), helpers.String("attributes", strutils.ObjectToString(connObj.GetAttributes())), helpers.Int("id", connObj.ID))
+			continue
+		}
+		sets[strutils.ObjectToString(set)] = set
+	}
+	if !nh.incomingConnections.SetSubscriptions(connObj.ID, sortedSubscriptions(sets)) {
+		return
+	}
+	logger.L().Debug("updated subscriptions", helpers.Int("id", connObj.ID), helpers.Int("subscriptions", len(sets)))
+
+	// a gateway in the middle passes the subscriptions of its children on to its own parent
+	if nh.currentConfig().Parent.Multiplexed {
+		go nh.syncSubscriptions()
+	}
+}
+
+// sortedSubscriptions returns the attribute sets ordered by their string form
+func sortedSubscriptions(sets map[string]map[string]string) []map[string]string {
+	keys := make([]string, 0, len(sets))
+	for k := range sets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	subscriptions := make([]map[string]string, len(keys))
+	for i, k := range keys {
+		subscriptions[i] = sets[k]
+	}
+	return subscriptions
+}
+
+// linkSubscriptions returns the attribute sets of the local subscribers a link to the parent carries, by their string form.
+// Each set is completed with the attributes of the link, the parent does not accept subscriptions wider than the link
+func (nh *Gateway) linkSubscriptions(link *upstreamLink) map[string]map[string]string {
+	registration := link.registration()
+	sets := map[string]map[string]string{}
+	for _, conn := range nh.incomingConnections.List() {
+		for _, routingAttributes := range conn.RoutingAttributes() {
+			if !websocketactions.AttributesContained(routingAttributes, registration) {
+				continue
+			}
+			set := make(map[string]string, len(routingAttributes)+len(registration))
+			for k, v := range routingAttributes {
+				set[k] = v
+			}
+			for k, v := range registration {
+				set[k] = v
+			}
+			sets[strutils.ObjectToString(set)] = set
+		}
+	}
+	return sets
+}
+
+// advertiseSubscriptions tells the parent which attribute sets to route over a link.
+// A full advertisement replaces whatever the parent knew, e.g. on a new connection, otherwise only the changes are sent
+func (nh *Gateway) advertiseSubscriptions(link *upstreamLink, full bool) {
+	if !nh.currentConfig().Parent.Multiplexed {
+		return
+	}
+	link.subscriptionsMutex.Lock()
+	defer link.subscriptionsMutex.Unlock()
+
+	link.mutex.RLock()
+	connObj := link.conn
+	link.mutex.RUnlock()
+	if connObj == nil {
+		// the subscriptions are advertised in full once the link connects
+		return
+	}
+
+	sets := nh.linkSubscriptions(link)
+	frame := SubscriptionFrame{Replace: full}
+	if full {
+		frame.Subscribe = sortedSubscriptions(sets)
+	} else {
+		subscribe := map[string]map[string]string{}
+		unsubscribe := map[string]map[string]string{}
+		for k, set := range sets {
+			if _, ok := link.advertised[k]; !ok {
+				subscribe[k] = set
+			}
+		}
+		for k, set := range link.advertised {
+			if _, ok := sets[k]; !ok {
+				unsubscribe[k] = set
+			}
+		}
+		if len(subscribe) == 0 && len(unsubscribe) == 0 {
+			return
+		}
+		if len(subscribe) > 0 {
+			frame.Subscribe = sortedSubscriptions(subscribe)
+		}
+		if len(unsubscribe) > 0 {
+			frame.Unsubscribe = sortedSubscriptions(unsubscribe)
+		}
+	}
+
+	b, _ := json.Marshal(frame)
+	if err := nh.wa.WriteBinaryMessage(connObj, b); err != nil {
+		// the link reconnects and advertises the subscriptions in full
+		logger.L().Warning("failed to advertise subscriptions to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.Error(err))
+		return
+	}
+	link.advertised = sets
+	logger.L().Debug("advertised subscriptions to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.Int("subscribe", len(frame.Subscribe)), helpers.Int("unsubscribe", len(frame.Unsubscribe)))
+}
+
+// syncSubscriptions advertises the changes of the local subscriptions over all the links to the parent
+func (nh *Gateway) syncSubscriptions() {
+	if !nh.currentConfig().Parent.Multiplexed {
+		return
+	}
+	nh.outgoingConnectionsMutex.Lock()
+	links := make([]*upstreamLink, 0, len(nh.upstreamLinks))
+	for _, link := range nh.upstreamLinks {
+		links = append(links, link)
+	}
+	nh.outgoingConnectionsMutex.Unlock()
+
+	for _, link := range links {
+		nh.advertiseSubscriptions(link, false)
+	}
+}
@@ -0,0 +1,250 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseSubscriptionFrame(t *testing.T) {
+	frame, ok := parseSubscriptionFrame([]byte(`{"subscribe":[{"customer":"test","cluster":"a"}],"replace":true}`))
+	if assert.True(t, ok) {
+		assert.Equal(t, []map[string]string{{"customer": "test", "cluster": "a"}}, frame.Subscribe)
+		assert.True(t, frame.Replace)
+	}
+
+	b, _ := bson.Marshal(bson.M{"unsubscribe": []bson.M{{"customer": "test"}}})
+	frame, ok = parseSubscriptionFrame(b)
+	if assert.True(t, ok) {
+		assert.Equal(t, []map[string]string{{"customer": "test"}}, frame.Unsubscribe)
+	}
+
+	_, ok = parseSubscriptionFrame([]byte(`{"target":{"customer":"test"},"notification":"hello"}`))
+	assert.False(t, ok)
+}
+
+func TestApplySubscriptionFrame(t *testing.T) {
+	ns := NewNotificationServerMasterMock()
+	connObj, _ := ns.incomingConnections.Append(ATTRIBUTES_MOCK, nil, nil)
+
+	ns.applySubscriptionFrame(connObj, &SubscriptionFrame{Subscribe: []map[string]string{
+		{"customer": "test", "cluster": "yay", "namespace": "default"},
+		{"customer": "other", "cluster": "yay"},
+		{"customer": "test"},
+	}})
+	assert.Equal(t, []map[string]string{{"customer": "test", "cluster": "yay", "namespace": "default"}}, connObj.Subscriptions(), "subscriptions wider than the registered attributes are ignored")
+
+	ns.applySubscriptionFrame(connObj, &SubscriptionFrame{Subscribe: []map[string]string{{"customer": "test", "cluster": "yay", "namespace": "kube-system"}}})
+	assert.Equal(t, 2, len(connObj.Subscriptions()))
+	ns.applySubscriptionFrame(connObj, &SubscriptionFrame{Unsubscribe: []map[string]string{{"customer": "test", "cluster": "yay", "namespace": "default"}}})
+	assert.Equal(t, []map[string]string{{"customer": "test", "cluster": "yay", "namespace": "kube-system"}}, connObj.Subscriptions())
+	assert.Equal(t, 0, len(ns.incomingConnections.Get(map[string]string{"namespace": "default"})))
+
+	ns.applySubscriptionFrame(connObj, &SubscriptionFrame{Replace: true})
+	assert.Equal(t, 0, len(connObj.Subscriptions()))
+	assert.Equal(t, 0, len(ns.incomingConnections.Get(ATTRIBUTES_MOCK)))
+}
+
+// multiplexedGatewayMock serves a gateway advertising its subscriptions to a given parent, none for a root gateway
+func multiplexedGatewayMock(t *testing.T, parentURL string) (*Gateway, string) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.config.Parent.Multiplexed = true
+	ns.config.Routing.ParentAttributes = []string{"customer"}
+	if parentURL != "" {
+		ns.parents = []string{parentURL}
+	}
+	ns.reconnectBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	t.Cleanup(server.Close)
+	return ns, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestMultiplexedUpstream(t *testing.T) {
+	unsetParentURLMock(t)
+	root, rootURL := multiplexedGatewayMock(t, "")
+	_, edgeURL := multiplexedGatewayMock(t, rootURL)
+
+	subscriptions := func() []map[string]string {
+		links := root.incomingConnections.List()
+		if len(links) != 1 {
+			return nil
+		}
+		return links[0].Subscriptions()
+	}
+
+	subscriberA, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer=test&cluster=a", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriberA.Close()
+	assert.Eventually(t, func() bool { return len(subscriptions()) == 1 }, 5*time.Second, time.Millisecond, "the edge advertises its subscriptions once linked")
+
+	// the root only routes to the edge what its subscribers need
+	result, err := root.SendNotification(NotificationMock(map[string]string{"customer": "test", "cluster": "b"}, true), []byte(`{"notification":"b"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(result.Connections))
+	result, err = root.SendNotification(NotificationMock(map[string]string{"customer": "test", "cluster": "a"}, true), []byte(`{"target":{"customer":"test","cluster":"a"},"notification":"a"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Connections))
+	subscriberA.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := subscriberA.ReadMessage()
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(message), `"notification":"a"`)
+	}
+
+	// local subscribers coming and going are advertised as changes
+	subscriberB, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer=test&cluster=b", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriberB.Close()
+	assert.Eventually(t, func() bool { return len(subscriptions()) == 2 }, 5*time.Second, time.Millisecond)
+
+	subscriberA.Close()
+	assert.Eventually(t, func() bool {
+		subs := subscriptions()
+		return len(subs) == 1 && subs[0]["cluster"] == "b"
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 0, len(root.incomingConnections.Get(map[string]string{"customer": "test", "cluster": "a"})))
+}
+
+func TestMultiplexedSingleLink(t *testing.T) {
+	unsetParentURLMock(t)
+	root, rootURL := multiplexedGatewayMock(t, "")
+	edge, edgeURL := multiplexedGatewayMock(t, rootURL)
+	edge.config.Routing.ParentAttributes = []string{"customer", "cluster"}
+
+	subscriberA, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer=test&cluster=a", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriberA.Close()
+	assert.Eventually(t, func() bool {
+		links := root.incomingConnections.List()
+		return len(links) == 1 && len(links[0].Subscriptions()) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// a subscriber of another cluster is carried by the same link, registered again with the attributes both share
+	subscriberB, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer=test&cluster=b", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriberB.Close()
+	assert.Eventually(t, func() bool {
+		links := root.incomingConnections.List()
+		return len(links) == 1 && len(links[0].Subscriptions()) == 2 && assert.ObjectsAreEqual(map[string]string{"customer": "test"}, links[0].GetAttributes())
+	}, 5*time.Second, time.Millisecond)
+	statuses := edge.UpstreamStatus()
+	if assert.Equal(t, 1, len(statuses)) {
+		assert.Equal(t, map[string]string{"customer": "test"}, statuses[0].Attributes)
+	}
+	assert.Eventually(t, func() bool {
+		links := edge.outgoingConnections.List()
+		return len(links) == 1 && assert.ObjectsAreEqual(map[string]string{"customer": "test"}, links[0].GetAttributes())
+	}, 5*time.Second, time.Millisecond, "the swapped connection is routed by the attributes it registered with")
+
+	result, err := root.SendNotification(NotificationMock(map[string]string{"customer": "test", "cluster": "b"}, true), []byte(`{"target":{"customer":"test","cluster":"b"},"notification":"b"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Connections))
+	subscriberB.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := subscriberB.ReadMessage()
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(message), `"notification":"b"`)
+	}
+}
+
+func TestMultiplexedNoSharedAttributes(t *testing.T) {
+	unsetParentURLMock(t)
+	root, rootURL := multiplexedGatewayMock(t, "")
+	edge, edgeURL := multiplexedGatewayMock(t, rootURL)
+
+	subscribers := map[string]*websocket.Conn{}
+	for _, customer := range []string{"a", "b"} {
+		subscriber, _, err := websocket.DefaultDialer.Dial(edgeURL+"?customer="+customer+"&cluster=x", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer subscriber.Close()
+		subscribers[customer] = subscriber
+	}
+
+	// the customers share no parent attributes, the root accepts the subscriptions of each over a link of its own
+	assert.Eventually(t, func() bool {
+		links := root.incomingConnections.List()
+		if len(links) != 2 {
+			return false
+		}
+		for _, link := range links {
+			if len(link.Subscriptions()) != 1 || link.Subscriptions()[0]["customer"] != link.GetAttributes()["customer"] {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 2, len(edge.UpstreamStatus()))
+
+	for customer, subscriber := range subscribers {
+		target := map[string]string{"customer": customer, "cluster": "x"}
+		result, err := root.SendNotification(NotificationMock(target, true), []byte(`{"target":{"customer":"`+customer+`","cluster":"x"},"notification":"`+customer+`"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(result.Connections))
+		subscriber.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, message, err := subscriber.ReadMessage()
+		if assert.NoError(t, err) {
+			assert.Contains(t, string(message), `"notification":"`+customer+`"`)
+		}
+	}
+}
+
+func TestMultiplexedSharedAttributesAgain(t *testing.T) {
+	unsetParentURLMock(t)
+	root, rootURL := multiplexedGatewayMock(t, "")
+	edge, edgeURL := multiplexedGatewayMock(t, rootURL)
+	edge.config.Routing.ParentAttributes = []string{"customer", "cluster"}
+	dial := func(query string) *websocket.Conn {
+		subscriber, _, err := websocket.DefaultDialer.Dial(edgeURL+"?"+query, nil)
+		assert.NoError(t, err)
+		return subscriber
+	}
+	rootLinks := func(n int) func() bool {
+		return func() bool { return len(root.incomingConnections.List()) == n }
+	}
+
+	subscriberX := dial("customer=a&cluster=x")
+	defer subscriberX.Close()
+	assert.Eventually(t, rootLinks(1), 5*time.Second, time.Millisecond)
+	other := dial("customer=b&cluster=w")
+	assert.Eventually(t, rootLinks(2), 5*time.Second, time.Millisecond)
+	subscriberY := dial("customer=a&cluster=y")
+	defer subscriberY.Close()
+	assert.Eventually(t, rootLinks(3), 5*time.Second, time.Millisecond, "nothing is shared, cluster y gets a link of its own")
+
+	other.Close()
+	assert.Eventually(t, func() bool { return edge.incomingConnections.Len() == 2 }, 5*time.Second, time.Millisecond)
+
+	// the subscribers share the customer again, the link registers with it and takes over the other link
+	subscriberZ := dial("customer=a&cluster=z")
+	defer subscriberZ.Close()
+	assert.Eventually(t, func() bool {
+		links := root.incomingConnections.List()
+		return len(links) == 1 && len(links[0].Subscriptions()) == 3 && assert.ObjectsAreEqual(map[string]string{"customer": "a"}, links[0].GetAttributes())
+	}, 5*time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return len(edge.UpstreamStatus()) == 1 }, 5*time.Second, time.Millisecond)
+
+	result, err := root.SendNotification(NotificationMock(map[string]string{"customer": "a", "cluster": "y"}, true), []byte(`{"target":{"customer":"a","cluster":"y"},"notification":"y"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Connections), "the notification is not delivered twice")
+	subscriberY.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := subscriberY.ReadMessage()
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(message), `"notification":"y"`)
+	}
+}
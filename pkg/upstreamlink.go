@@ -0,0 +1,409 @@
+package gateway
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+const (
+	defaultReconnectInitialBackoff = time.Second
+	defaultReconnectMaxBackoff     = 2 * time.Minute
+	upstreamPingInterval           = 10 * time.Second
+)
+
+// LinkState describes the state of a link to the parent gateway
+type LinkState string
+
+const (
+	// LinkStateConnecting the link is dialing the parent gateway
+	LinkStateConnecting LinkState = "connecting"
+	// LinkStateConnected the link is connected to the parent gateway
+	LinkStateConnected LinkState = "connected"
+	// LinkStateDisconnected the link is disconnected and waits before reconnecting
+	LinkStateDisconnected LinkState = "disconnected"
+)
+
+// Backoff computes jittered exponential delays between reconnection attempts
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// Jitter is the fraction of the delay that is randomized, between 0 and 1
+	Jitter float64
+}
+
+// NewBackoff creates a Backoff with the default settings
+func NewBackoff() Backoff {
+	return Backoff{
+		Initial:    defaultReconnectInitialBackoff,
+		Max:        defaultReconnectMaxBackoff,
+		Multiplier: 2,
+		Jitter:     0.2,
+	}
+}
+
+// newBackoff creates the Backoff of the links to the parent gateway
+func newBackoff(cfg ParentConfig) Backoff {
+	b := NewBackoff()
+	b.Initial = time.Duration(cfg.ReconnectInitialBackoff)
+	b.Max = time.Duration(cfg.ReconnectMaxBackoff)
+	return b
+}
+
+// Delay returns the time to wait before a given reconnection attempt, starting from 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial)
+	for i := 0; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	// spread the delay over [delay*(1-jitter), delay] so edges do not reconnect all at once
+	delay -= delay * b.Jitter * rand.Float64()
+	return time.Duration(delay)
+}
+
+// UpstreamLinkStatus is a snapshot of the state of a link to the parent gateway
+type UpstreamLinkStatus struct {
+	Attributes map[string]string `json:"attributes"`
+	// Parent is the URL of the parent gateway the link is connected to, or last tried to connect to
+	Parent    string    `json:"parent,omitempty"`
+	State     LinkState `json:"state"`
+	Since     time.Time `json:"since"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// upstreamLink is a supervised link to the parent gateway for a set of attributes.
+// The link reconnects with a backoff for as long as there are local subscribers for its attributes
+type upstreamLink struct {
+	// attributes are the attributes the link registers with. Guarded by mutex, a multiplexed link registers again when they change
+	attributes map[string]string
+	// pinned is the parent gateway an active-active link connects to, empty for a failover link which connects to the first reachable parent
+	pinned string
+	mutex  sync.RWMutex
+	// parent is the parent gateway the link is connected to, or last tried to connect to
+	parent string
+	state  LinkState
+	since  time.Time
+	// failures counts the consecutive failed connection attempts
+	failures  int
+	lastError string
+	// conn is the current connection of the link, nil while it is not connected
+	conn *websocketactions.Connection
+	// replacement is a connection dialed with a reloaded configuration or to a preferred parent, taking over once conn is closed
+	replacement *websocketactions.Connection
+	// replacementParent is the parent gateway of the replacement
+	replacementParent string
+	// subscriptionsMutex serializes advertising the subscriptions over the link
+	subscriptionsMutex sync.Mutex
+	// advertised are the attribute sets the parent routes to the link, by their string form. Only used in multiplexed mode
+	advertised map[string]map[string]string
+}
+
+// newUpstreamLink creates a new upstreamLink with given attributes, pinned to a given parent unless empty
+func newUpstreamLink(attributes map[string]string, pinned string) *upstreamLink {
+	return &upstreamLink{
+		attributes: attributes,
+		pinned:     pinned,
+		parent:     pinned,
+		state:      LinkStateConnecting,
+		since:      time.Now(),
+	}
+}
+
+// setState records a state transition of the link
+func (l *upstreamLink) setState(state LinkState, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.state != state {
+		l.since = time.Now()
+	}
+	l.state = state
+	switch {
+	case state == LinkStateConnected:
+		l.failures = 0
+	case err != nil:
+		l.lastError = err.Error()
+	}
+}
+
+// connectionFailed records a failed connection attempt and returns the number of consecutive failures
+func (l *upstreamLink) connectionFailed(err error) int {
+	l.setState(LinkStateDisconnected, err)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.failures++
+	return l.failures
+}
+
+// setConnection records the current connection of the link and its parent
+func (l *upstreamLink) setConnection(conn *websocketactions.Connection, parent string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.conn = conn
+	l.parent = parent
+}
+
+// registration returns the attributes the link registers with
+func (l *upstreamLink) registration() map[string]string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.attributes
+}
+
+// setRegistration sets the attributes the link registers with on its next connection
+func (l *upstreamLink) setRegistration(attributes map[string]string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.attributes = attributes
+}
+
+// setParent records the parent the link tries to connect to
+func (l *upstreamLink) setParent(parent string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.parent = parent
+}
+
+// connection returns the current connection of the link and its parent
+func (l *upstreamLink) connection() (*websocketactions.Connection, string) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.conn, l.parent
+}
+
+// next switches the link to the connection replacing its closed connection. Returns nil if the connection was not replaced
+func (l *upstreamLink) next() (*websocketactions.Connection, string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.conn = l.replacement
+	if l.replacement != nil {
+		l.parent = l.replacementParent
+	}
+	l.replacement = nil
+	l.replacementParent = ""
+	return l.conn, l.parent
+}
+
+// status returns a snapshot of the link state
+func (l *upstreamLink) status() UpstreamLinkStatus {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return UpstreamLinkStatus{
+		Attributes: l.attributes,
+		Parent:     l.parent,
+		State:      l.state,
+		Since:      l.since,
+		Failures:   l.failures,
+		LastError:  l.lastError,
+	}
+}
+
+// UpstreamStatus returns the state of all the links to the parent gateway
+func (nh *Gateway) UpstreamStatus() []UpstreamLinkStatus {
+	nh.outgoingConnectionsMutex.Lock()
+	defer nh.outgoingConnectionsMutex.Unlock()
+	statuses := make([]UpstreamLinkStatus, 0, len(nh.upstreamLinks))
+	for _, link := range nh.upstreamLinks {
+		statuses = append(statuses, link.status())
+	}
+	return statuses
+}
+
+// HealthHandler reports the state of the links to the parent gateway, and of each parent.
+// It responds with 503 while a set of attributes has no connected link, local subscribers are served regardless
+func (nh *Gateway) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, &ErrorResponse{Code: ErrorCodeMethodNotAllowed, Message: "method not allowed"})
+		return
+	}
+	statuses := nh.UpstreamStatus()
+	connected := map[string]bool{}
+	for i := range statuses {
+		key := strutils.ObjectToString(statuses[i].Attributes)
+		connected[key] = connected[key] || statuses[i].State == LinkStateConnected
+	}
+	status := http.StatusOK
+	for _, ok := range connected {
+		if !ok {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"upstream": statuses, "parents": nh.parentStatuses(statuses)})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// superviseUpstreamLink keeps a link to the parent gateway connected while there are local subscribers for it.
+// Failed connection attempts are retried with a jittered exponential backoff, the process never exits because of the parent
+func (nh *Gateway) superviseUpstreamLink(link *upstreamLink, key string) {
+	for attempt := 0; ; attempt++ {
+		// checking and removing under the lock makes sure a new subscriber either sees this link or starts a new one
+		nh.outgoingConnectionsMutex.Lock()
+		// a multiplexed link also stops once another link took over its subscribers
+		if nh.shuttingDown.Load() || nh.hasParent() || !nh.isParent(link.pinned) || len(nh.incomingConnections.Get(link.registration())) == 0 || nh.upstreamLinks[key] != link {
+			if nh.upstreamLinks[key] == link {
+				delete(nh.upstreamLinks, key)
+			}
+			nh.outgoingConnectionsMutex.Unlock()
+			logger.L().Info("no local subscribers left or no master, stopping connection to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.String("parent", link.pinned))
+			return
+		}
+		nh.outgoingConnectionsMutex.Unlock()
+
+		if attempt > 0 {
+			nh.metrics.parentReconnects.Inc()
+		}
+		link.setState(LinkStateConnecting, nil)
+		conn, parent, err := nh.dialLinkParent(link)
+		if err != nil {
+			failures := link.connectionFailed(err)
+			delay := nh.reconnectBackoff.Delay(failures - 1)
+			logger.L().Warning("failed to connect to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.Int("failures", failures), helpers.String("retrying in", delay.String()), helpers.Error(err))
+			time.Sleep(delay)
+			continue
+		}
+		connObj, _ := nh.outgoingConnections.Append(link.registration(), conn, nil)
+
+		if nh.shuttingDown.Load() {
+			// connected while the links were being closed
+			nh.wa.Close(connObj)
+			nh.outgoingConnections.RemoveID(connObj.ID)
+			continue
+		}
+		link.setConnection(connObj, parent)
+		link.setState(LinkStateConnected, nil)
+		logger.L().Info("successfully connected to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.String("parent", parent), helpers.Int("number of outgoing websockets", nh.outgoingConnections.Len()))
+
+		for {
+			nh.upstreamLinkConnected(link, connObj, parent)
+			err = nh.receiveNotifications(connObj, parent)
+			nh.wa.Close(connObj)
+			replacement, replacementParent := link.next()
+			if replacement == nil {
+				break
+			}
+			// the connection was closed because it was replaced after the configuration was reloaded, or by a preferred parent
+			connObj, parent = replacement, replacementParent
+			if nh.shuttingDown.Load() {
+				nh.wa.Close(connObj)
+				link.next()
+				break
+			}
+			logger.L().Info("switched to new connection to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.String("parent", parent))
+		}
+		nh.outgoingConnections.RemoveID(connObj.ID)
+
+		// local subscribers stay connected while the link is down
+		link.setState(LinkStateDisconnected, err)
+		delay := nh.reconnectBackoff.Delay(0)
+		logger.L().Warning("disconnected from master, reconnecting", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.String("retrying in", delay.String()), helpers.Error(err))
+		time.Sleep(delay)
+	}
+}
+
+// upstreamLinkConnected starts serving a new connection of a link
+func (nh *Gateway) upstreamLinkConnected(link *upstreamLink, connObj *websocketactions.Connection, parent string) {
+	go nh.keepUpstreamAlive(connObj)
+	nh.advertiseSubscriptions(link, true)
+	if link.pinned == "" && !nh.isPreferredParent(parent) {
+		go nh.failBack(link, connObj)
+	}
+}
+
+// redialUpstreamLinks connects the connected links to the master again, with the current URLs and credentials
+func (nh *Gateway) redialUpstreamLinks() {
+	nh.outgoingConnectionsMutex.Lock()
+	links := make([]*upstreamLink, 0, len(nh.upstreamLinks))
+	for _, link := range nh.upstreamLinks {
+		links = append(links, link)
+	}
+	nh.outgoingConnectionsMutex.Unlock()
+
+	for _, link := range links {
+		if !nh.isParent(link.pinned) {
+			// the master was removed from the config, the link stops once disconnected
+			if conn, _ := link.connection(); conn != nil {
+				nh.wa.Close(conn)
+			}
+			continue
+		}
+		nh.redialUpstreamLink(link)
+	}
+}
+
+// redialUpstreamLink dials a new connection for a link while its current connection still serves,
+// then swaps them in the routing table and closes the current connection.
+// A link that is not connected dials with the current configuration on its next attempt
+func (nh *Gateway) redialUpstreamLink(link *upstreamLink) {
+	current, _ := link.connection()
+	if current == nil {
+		return
+	}
+
+	conn, parent, err := nh.dialLinkParent(link)
+	if err != nil {
+		logger.L().Warning("failed to connect to master again, keeping the current connection", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.Error(err))
+		return
+	}
+	if nh.replaceUpstreamConnection(link, current, conn, parent) {
+		logger.L().Info("reconnected to master", helpers.String("attributes", strutils.ObjectToString(link.registration())), helpers.String("parent", parent))
+	}
+}
+
+// replaceUpstreamConnection swaps the current connection of a link for a new websocket to a given parent in the routing table,
+// and closes the current connection so the link switches to the new one. Returns false and closes the websocket if the link moved on meanwhile
+func (nh *Gateway) replaceUpstreamConnection(link *upstreamLink, current *websocketactions.Connection, conn *websocket.Conn, parent string) bool {
+	link.mutex.Lock()
+	// the current connection may have dropped, or the gateway may be shutting down, while dialing
+	if link.conn != current || link.replacement != nil || nh.shuttingDown.Load() {
+		link.mutex.Unlock()
+		conn.Close()
+		return false
+	}
+	// a multiplexed link may have registered with other attributes
+	replacement, ok := nh.outgoingConnections.Replace(current.ID, conn, link.attributes)
+	if !ok {
+		link.mutex.Unlock()
+		conn.Close()
+		return false
+	}
+	link.replacement = replacement
+	link.replacementParent = parent
+	link.mutex.Unlock()
+
+	nh.wa.Close(current)
+	return true
+}
+
+// closeUpstreamLinks closes the connections to the master, the links stop once they see there is no master
+func (nh *Gateway) closeUpstreamLinks() {
+	for _, conn := range nh.outgoingConnections.List() {
+		nh.wa.Close(conn)
+	}
+}
+
+// keepUpstreamAlive pings the parent gateway to keep the websocket connection alive
+func (nh *Gateway) keepUpstreamAlive(connObj *websocketactions.Connection) {
+	for {
+		time.Sleep(upstreamPingInterval)
+		if err := nh.wa.WritePingMessage(connObj); err != nil {
+			logger.L().Warning("in WritePingMessage", helpers.String("attributes", strutils.ObjectToString(connObj.GetAttributes())), helpers.Error(err))
+			connObj.Close()
+			return
+		}
+	}
+}
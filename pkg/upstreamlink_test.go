@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2, Jitter: 0.5}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		d := b.Delay(attempt)
+		assert.LessOrEqual(t, d, want, "attempt %d", attempt)
+		assert.GreaterOrEqual(t, d, want/2, "attempt %d", attempt)
+	}
+	assert.Equal(t, 10*time.Second, Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}.Delay(1000))
+}
+
+func TestConnectToMasterSupervision(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.parents = []string{"wss://localhost"}
+	ns.reconnectBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	_, id := ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+
+	done := make(chan struct{})
+	go func() {
+		// the mock parent closes every connection right away, the link keeps reconnecting
+		ns.connectToMaster(ATTRIBUTES_MOCK)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return len(ns.UpstreamStatus()) == 1 }, time.Second, time.Millisecond)
+
+	// a second subscriber with the same attributes shares the link
+	ns.connectToMaster(ATTRIBUTES_MOCK)
+	assert.Equal(t, 1, len(ns.UpstreamStatus()))
+
+	w := httptest.NewRecorder()
+	ns.HealthHandler(w, httptest.NewRequest(http.MethodGet, PathHealthV1, nil))
+	assert.Contains(t, w.Body.String(), `"upstream":[{`)
+
+	// the link is dropped once there are no local subscribers left
+	ns.CleanupIncomingConnection(id)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("upstream link was not stopped")
+	}
+	assert.Equal(t, 0, len(ns.UpstreamStatus()))
+
+	w = httptest.NewRecorder()
+	ns.HealthHandler(w, httptest.NewRequest(http.MethodGet, PathHealthV1, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"fmt"
+
+	strutils "github.com/armosec/utils-go/str"
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+
+	"github.com/kubescape/gateway/pkg/websocketactions"
+)
+
+const (
+	// RoutingModeDownstream routes the notifications to the local subscribers only
+	RoutingModeDownstream = "downstream"
+	// RoutingModeBidirectional also forwards the notifications without local subscribers to the parent gateway,
+	// which routes them down to the edges holding matching subscribers
+	RoutingModeBidirectional = "bidirectional"
+
+	defaultMaxHops = 8
+)
+
+// routesUpstream reports whether the notifications without local subscribers are forwarded to the parent gateway
+func (nh *Gateway) routesUpstream() bool {
+	return nh.currentConfig().Routing.Mode == RoutingModeBidirectional && !nh.hasParent()
+}
+
+// forwardUpstream forwards a notification to the parent gateway, stamped with one more hop.
+// Notifications that were already forwarded by routing.maxHops gateways are not forwarded, so a cycle of gateways cannot loop them forever
+func (nh *Gateway) forwardUpstream(n *Notification, notification []byte) error {
+	if maxHops := nh.currentConfig().Routing.MaxHops; n.Hops >= maxHops {
+		return fmt.Errorf("notification was already forwarded by %d gateways, the maximum", n.Hops)
+	}
+	conn := nh.upstreamConnection(n.Target)
+	if conn == nil {
+		return fmt.Errorf("not connected to the parent gateway")
+	}
+	stamped, err := stampMessage(notification, map[string]interface{}{"hops": n.Hops + 1})
+	if err != nil {
+		return fmt.Errorf("failed to stamp hops, reason: %s", err.Error())
+	}
+	if err := nh.wa.WriteBinaryMessage(conn, stamped); err != nil {
+		return fmt.Errorf("failed to forward notification to the parent gateway, reason: %s", err.Error())
+	}
+	nh.metrics.notificationsUpstream.Inc()
+	logger.L().Info("forwarded notification to the parent gateway", helpers.String("target", strutils.ObjectToString(n.Target)), helpers.Int("id", conn.ID), helpers.Int("hops", n.Hops+1))
+	return nil
+}
+
+// upstreamConnection returns a connection to the parent gateway to forward a notification with a given target by,
+// preferably one registered with attributes of the target. Returns nil if there is no connection to the parent
+func (nh *Gateway) upstreamConnection(target map[string]string) *websocketactions.Connection {
+	conns := nh.outgoingConnections.List()
+	if len(conns) == 0 {
+		return nil
+	}
+	for _, conn := range conns {
+		if websocketactions.AttributesContained(conn.GetAttributes(), target) {
+			return conn
+		}
+	}
+	return conns[0]
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteNotificationUpstream(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.parents = []string{"wss://localhost"}
+	parent, _ := ns.outgoingConnections.Append(map[string]string{"customer": "test"}, &websocket.Conn{}, nil)
+
+	// downstream mode keeps the notifications without subscribers local
+	result, err := ns.SendNotification(NotificationMock(ATTRIBUTES_MOCK, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.False(t, result.Forwarded)
+
+	ns.config.Routing.Mode = RoutingModeBidirectional
+	result, err = ns.SendNotification(NotificationMock(ATTRIBUTES_MOCK, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.True(t, result.Forwarded)
+	messages, _ := parent.Sent()
+	assert.Equal(t, uint64(1), messages)
+
+	// notifications from the parent are never sent back to it
+	result, err = ns.routeNotification(NotificationMock(ATTRIBUTES_MOCK, true), []byte("{}"), parent, true, nil)
+	assert.NoError(t, err)
+	assert.False(t, result.Forwarded)
+
+	// notifications forwarded by too many gateways are not forwarded again
+	n := NotificationMock(ATTRIBUTES_MOCK, true)
+	n.Hops = ns.config.Routing.MaxHops
+	result, err = ns.SendNotification(n, []byte("{}"))
+	assert.NoError(t, err)
+	assert.False(t, result.Forwarded)
+	messages, _ = parent.Sent()
+	assert.Equal(t, uint64(1), messages)
+
+	// a notification with local subscribers stays local
+	ns.incomingConnections.Append(ATTRIBUTES_MOCK, &websocket.Conn{}, nil)
+	result, err = ns.SendNotification(NotificationMock(ATTRIBUTES_MOCK, true), []byte("{}"))
+	assert.NoError(t, err)
+	assert.False(t, result.Forwarded)
+	assert.Equal(t, 1, len(result.Connections))
+}
+
+// bidirectionalGatewayMock serves a gateway in bidirectional routing mode, an edge of a given parent if it is not empty
+func bidirectionalGatewayMock(t *testing.T, parentURL string) string {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.config.Routing.Mode = RoutingModeBidirectional
+	ns.config.Routing.ParentAttributes = []string{"customer"}
+	if parentURL != "" {
+		ns.parents = []string{parentURL}
+	}
+	ns.reconnectBackoff = Backoff{Initial: time.Millisecond, Max: 5 * time.Millisecond, Multiplier: 2}
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestBidirectionalRouting(t *testing.T) {
+	unsetParentURLMock(t)
+	root := bidirectionalGatewayMock(t, "")
+	edgeA := bidirectionalGatewayMock(t, root)
+	edgeB := bidirectionalGatewayMock(t, root)
+
+	subscriberB, _, err := websocket.DefaultDialer.Dial(edgeB+"?customer=test&cluster=b", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer subscriberB.Close()
+	publisherA, _, err := websocket.DefaultDialer.Dial(edgeA+"?customer=test&cluster=a", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer publisherA.Close()
+
+	// the edges link to the root once their subscribers registered, until then the notification stays at edge A
+	deadline := time.Now().Add(5 * time.Second)
+	subscriberB.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		assert.NoError(t, publisherA.WriteMessage(websocket.TextMessage, []byte(`{"target":{"customer":"test","cluster":"b"},"notification":"hello"}`)))
+		_, message, err := subscriberB.ReadMessage()
+		if err == nil {
+			assert.Contains(t, string(message), `"notification":"hello"`)
+			assert.Contains(t, string(message), `"hops":1`, "forwarded once, by edge A")
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("notification was not routed from edge A to edge B through the root")
+		}
+		// a timed out read leaves the websocket unusable, dial again
+		subscriberB.Close()
+		subscriberB, _, err = websocket.DefaultDialer.Dial(edgeB+"?customer=test&cluster=b", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		subscriberB.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	}
+}
@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	logger "github.com/kubescape/go-logger"
+	"github.com/kubescape/go-logger/helpers"
+)
+
+const (
+	defaultMaxBodySize  = 4 << 20
+	defaultMaxFrameSize = 4 << 20
+)
+
+// Violation is a problem of a notification envelope
+type Violation struct {
+	// Field is the path of the offending field, e.g. "target.customerGUID", empty if the envelope is malformed
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports every problem found in a notification envelope
+type ValidationError struct {
+	Violations []Violation `json:"violations"`
+}
+
+// Error implements error
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		if v.Field == "" {
+			messages = append(messages, v.Message)
+			continue
+		}
+		messages = append(messages, fmt.Sprintf("%s: %s", v.Field, v.Message))
+	}
+	return "invalid notification: " + strings.Join(messages, "; ")
+}
+
+// add records a violation of a given field
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Violations = append(e.Violations, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// EnvelopeValidator checks the attribute keys the notifications select their subscribers by, and the format of the target values
+type EnvelopeValidator struct {
+	// keys are the allowed attribute keys, any key is allowed if empty
+	keys map[string]bool
+	// formats map attribute keys to the expression their target values must match entirely
+	formats map[string]*regexp.Regexp
+}
+
+// NewEnvelopeValidator creates an EnvelopeValidator allowing the given attribute keys and value formats
+func NewEnvelopeValidator(keys []string, formats map[string]string) (*EnvelopeValidator, error) {
+	v := &EnvelopeValidator{
+		keys:    map[string]bool{},
+		formats: map[string]*regexp.Regexp{},
+	}
+	for _, key := range keys {
+		v.keys[key] = true
+	}
+	for key, format := range formats {
+		expression, err := regexp.Compile("^(?:" + format + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid format of '%s', reason: %s", key, err.Error())
+		}
+		v.formats[key] = expression
+	}
+	return v, nil
+}
+
+// newEnvelopeValidator creates the configured EnvelopeValidator.
+// Returns nil when neither keys nor formats are configured
+func newEnvelopeValidator(cfg ValidationConfig) *EnvelopeValidator {
+	if len(cfg.TargetKeys) == 0 && len(cfg.TargetFormats) == 0 {
+		return nil
+	}
+	v, err := NewEnvelopeValidator(cfg.TargetKeys, cfg.TargetFormats)
+	if err != nil {
+		// never fall back to accepting everything
+		logger.L().Fatal("failed to configure the notification validation", helpers.Error(err))
+		return nil
+	}
+	return v
+}
+
+// validate records the attribute keys that are not allowed and the target values that do not match their format
+func (v *EnvelopeValidator) validate(n *Notification, e *ValidationError) {
+	keys := make([]string, 0, len(n.Target))
+	for key := range n.Target {
+		keys = append(keys, key)
+	}
+	// report in a stable order
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(v.keys) > 0 && !v.keys[key] {
+			e.add("target."+key, "attribute is not allowed")
+			continue
+		}
+		if format, ok := v.formats[key]; ok && !format.MatchString(n.Target[key]) {
+			e.add("target."+key, "value '%s' does not match '%s'", n.Target[key], format.String())
+		}
+	}
+	if n.Match != nil && len(v.keys) > 0 {
+		for i, expression := range n.Match.Expressions {
+			if !v.keys[expression.Key] {
+				e.add(fmt.Sprintf("match.expressions[%d].key", i), "attribute '%s' is not allowed", expression.Key)
+			}
+		}
+	}
+}
+
+// validateNotification checks a notification selects its subscribers by a target or by attribute expressions,
+// and follows the configured attribute keys and value formats. Returns the problems found, nil if there are none
+func (nh *Gateway) validateNotification(n *Notification) *ValidationError {
+	e := &ValidationError{}
+	if (n.Match == nil || len(n.Match.Expressions) == 0) && len(n.Target) == 0 {
+		e.add("target", "must not be empty without match expressions")
+	}
+	if n.Match != nil {
+		if err := n.Match.Validate(); err != nil {
+			e.add("match", "%s", err.Error())
+		}
+	}
+	nh.validateSchedule(n, e)
+	if nh.validator != nil {
+		nh.validator.validate(n, e)
+	}
+	if len(e.Violations) > 0 {
+		return e
+	}
+	return nil
+}
+
+// closeReason truncates a reason to the 123 bytes a close frame can carry
+func closeReason(reason string) string {
+	const maxCloseReason = 123
+	if len(reason) > maxCloseReason {
+		return reason[:maxCloseReason]
+	}
+	return reason
+}
+
+// malformedNotification reports a notification that could not be parsed
+func malformedNotification(err error) *ValidationError {
+	e := &ValidationError{}
+	e.add("", "malformed notification, reason: %s", err.Error())
+	return e
+}
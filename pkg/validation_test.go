@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kubescape/gateway/pkg/websocketactions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNotification(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	validator, err := NewEnvelopeValidator([]string{"customer", "cluster"}, map[string]string{"customer": "[a-z]+"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	ns.validator = validator
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{name: "valid", body: `{"target":{"customer":"test","cluster":"prod-1"}}`},
+		{name: "empty target", body: `{"target":{}}`, fields: []string{"target"}},
+		{name: "attribute not allowed", body: `{"target":{"customer":"test","namespace":"default"}}`, fields: []string{"target.namespace"}},
+		{name: "value format", body: `{"target":{"customer":"Test1"}}`, fields: []string{"target.customer"}},
+		{name: "partial value", body: `{"target":{"customer":"test-1"}}`, fields: []string{"target.customer"}},
+		{
+			name:   "match",
+			body:   `{"match":{"expressions":[{"key":"cluster","operator":"~","values":["dev"]},{"key":"namespace","operator":"=","values":["dev"]}]}}`,
+			fields: []string{"match", "match.expressions[1].key"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := ns.UnmarshalMessage([]byte(tt.body))
+			if !assert.NoError(t, err) {
+				return
+			}
+			e := ns.validateNotification(n)
+			if tt.fields == nil {
+				assert.Nil(t, e)
+				return
+			}
+			if assert.NotNil(t, e) {
+				fields := []string{}
+				for _, v := range e.Violations {
+					fields = append(fields, v.Field)
+				}
+				assert.Equal(t, tt.fields, fields)
+			}
+		})
+	}
+
+	_, err = NewEnvelopeValidator(nil, map[string]string{"customer": "("})
+	assert.Error(t, err)
+}
+
+func TestRestAPINotificationHandlerValidation(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.config.Validation.MaxBodySize = 64
+	ns.validator, _ = NewEnvelopeValidator([]string{"customer"}, nil)
+	send := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ns.RestAPINotificationHandler(w, httptest.NewRequest(http.MethodPost, "/v1/sendnotification", strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, send(`{"target":{"customer":"test"}}`).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send(`{"target":{"customer":"test"},"notification":"`+strings.Repeat("a", 64)+`"}`).Code)
+
+	w := send(`{"target":{"cluster":"test"}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	body := ErrorResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, ErrorCodeInvalidNotification, body.Code)
+	assert.Equal(t, []Violation{{Field: "target.cluster", Message: "attribute is not allowed"}}, body.Violations)
+
+	w = send(`{"target":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	body = ErrorResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.Equal(t, 1, len(body.Violations)) {
+		assert.Contains(t, body.Violations[0].Message, "malformed notification")
+	}
+}
+
+func TestWebsocketValidation(t *testing.T) {
+	ns := NewNotificationServerEdgeMock()
+	ns.wa = websocketactions.NewWebsocketActions()
+	ns.config.Validation.MaxFrameSize = 64
+	server := httptest.NewServer(http.HandlerFunc(ns.WebsocketNotificationHandler))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	closeCode := func(message string) int {
+		sender, _, err := websocket.DefaultDialer.Dial(url+"?customer=sender", nil)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		defer sender.Close()
+		assert.NoError(t, sender.WriteMessage(websocket.TextMessage, []byte(message)))
+		sender.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err = sender.ReadMessage()
+		if closeErr, ok := err.(*websocket.CloseError); ok {
+			return closeErr.Code
+		}
+		t.Errorf("expected a close frame, got %v", err)
+		return 0
+	}
+
+	assert.Equal(t, websocket.CloseMessageTooBig, closeCode(`{"target":{"customer":"test"},"notification":"`+strings.Repeat("a", 64)+`"}`))
+	assert.Equal(t, websocket.ClosePolicyViolation, closeCode(`{"target":{}}`))
+	assert.Equal(t, websocket.CloseInvalidFramePayloadData, closeCode(`{"target":`))
+}
@@ -2,6 +2,8 @@ package websocketactions
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,15 +14,39 @@ type Connection struct {
 	ID         int
 	conn       *websocket.Conn
 	attributes map[string]string
+	// stream is set instead of conn for Server-Sent Events subscribers
+	stream      *SSEStream
+	connectedAt time.Time
+	// messagesSent and bytesSent count the notifications written to the connection
+	messagesSent atomic.Uint64
+	bytesSent    atomic.Uint64
+	// queue is the send queue of the connection, nil if it writes right away
+	queue atomic.Pointer[sendQueue]
+	// subscriptions are the attribute sets a gateway link advertised, nil if it is routed by its own attributes
+	subscriptions atomic.Pointer[[]map[string]string]
+	// sender identifies the peer by its credential or its address, set before the connection is read
+	sender string
 }
 
 // NewConnection -
 func NewConnection(conn *websocket.Conn, id int, attributes map[string]string) *Connection {
 	return &Connection{
-		mutex:      &sync.Mutex{},
-		ID:         id,
-		conn:       conn,
-		attributes: attributes,
+		mutex:       &sync.Mutex{},
+		ID:          id,
+		conn:        conn,
+		attributes:  attributes,
+		connectedAt: time.Now(),
+	}
+}
+
+// NewStreamConnection creates a Connection of a Server-Sent Events subscriber
+func NewStreamConnection(stream *SSEStream, id int, attributes map[string]string) *Connection {
+	return &Connection{
+		mutex:       &sync.Mutex{},
+		ID:          id,
+		stream:      stream,
+		attributes:  attributes,
+		connectedAt: time.Now(),
 	}
 }
 
@@ -29,16 +55,99 @@ func (c *Connection) GetAttributes() map[string]string {
 	return c.attributes
 }
 
-// GetAttributes -
+// Subscriptions returns the attribute sets the connection advertised, nil if it is routed by its own attributes
+func (c *Connection) Subscriptions() []map[string]string {
+	if s := c.subscriptions.Load(); s != nil {
+		return *s
+	}
+	return nil
+}
+
+// SetSubscriptions routes the connection by the given attribute sets instead of its own attributes.
+// An empty set routes nothing to the connection. A Router indexing the connection must be updated as well
+func (c *Connection) SetSubscriptions(subscriptions []map[string]string) {
+	if subscriptions == nil {
+		subscriptions = []map[string]string{}
+	}
+	c.subscriptions.Store(&subscriptions)
+}
+
+// RoutingAttributes returns the attribute sets the connection is routed by, its subscriptions if it advertised any
+func (c *Connection) RoutingAttributes() []map[string]string {
+	if s := c.subscriptions.Load(); s != nil {
+		return *s
+	}
+	return []map[string]string{c.attributes}
+}
+
+// Sender returns what identifies the peer as a sender of notifications, empty if it is unknown
+func (c *Connection) Sender() string {
+	return c.sender
+}
+
+// SetSender sets what identifies the peer as a sender of notifications. Must be called before the connection is read
+func (c *Connection) SetSender(sender string) {
+	c.sender = sender
+}
+
+// IsStream reports whether the connection is a Server-Sent Events stream rather than a websocket
+func (c *Connection) IsStream() bool {
+	return c.stream != nil
+}
+
+// RemoteAddr returns the address of the peer, empty if it is unknown
+func (c *Connection) RemoteAddr() string {
+	if c.stream != nil {
+		return c.stream.remoteAddr
+	}
+	if c.conn == nil || c.conn.NetConn() == nil {
+		return ""
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// ConnectedAt returns the time the connection was registered
+func (c *Connection) ConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// Sent returns the number of messages and bytes written to the connection
+func (c *Connection) Sent() (messages, bytes uint64) {
+	return c.messagesSent.Load(), c.bytesSent.Load()
+}
+
+// sent records a message written to the connection
+func (c *Connection) sent(bytes int) {
+	c.messagesSent.Add(1)
+	c.bytesSent.Add(uint64(bytes))
+}
+
+// Close -
 func (c *Connection) Close() {
+	c.closeSendQueue()
 	defer recover()
+	if c.stream != nil {
+		c.stream.Close()
+		return
+	}
 	c.conn.Close()
 }
 
-// AttributesContained -
+// AttributesContained reports whether a set of attributes matches the connection, by any of its routing attributes
 func (c *Connection) AttributesContained(attributes map[string]string) bool {
+	for _, routingAttributes := range c.RoutingAttributes() {
+		if AttributesContained(routingAttributes, attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttributesContained reports whether a set of attributes matches the attributes of a connection:
+// at least one key is present in both and none of the keys present in both have different values
+func AttributesContained(connectionAttributes, attributes map[string]string) bool {
 	found := false
-	for i, j := range c.attributes {
+	for i, j := range connectionAttributes {
 		if v, k := attributes[i]; k {
 			if v != j {
 				return false
@@ -1,6 +1,8 @@
 package websocketactions
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 
@@ -86,3 +88,141 @@ func TestConnection_AttributesContained(t *testing.T) {
 		})
 	}
 }
+
+func TestConnection_Matches(t *testing.T) {
+	attributes := map[string]string{
+		"customer": "a",
+		"cluster":  "prod-eu",
+	}
+	tests := []struct {
+		name   string
+		target map[string]string
+		match  *Match
+		want   bool
+	}{
+		{
+			name:   "exact target without match",
+			target: map[string]string{"cluster": "prod-eu", "namespace": "default"},
+			want:   true,
+		},
+		{
+			name:   "glob",
+			target: map[string]string{"customer": "a"},
+			match:  &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorEquals, Values: []string{"prod-*"}}}},
+			want:   true,
+		},
+		{
+			name:   "glob does not match",
+			target: map[string]string{"customer": "a"},
+			match:  &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorEquals, Values: []string{"dev-*"}}}},
+			want:   false,
+		},
+		{
+			name:  "expressions without target",
+			match: &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorIn, Values: []string{"dev", "prod-eu"}}}},
+			want:  true,
+		},
+		{
+			name:  "not in the list",
+			match: &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorIn, Values: []string{"dev", "prod-us"}}}},
+			want:  false,
+		},
+		{
+			name:  "exclusion",
+			match: &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorNotEquals, Values: []string{"dev-*"}}}},
+			want:  true,
+		},
+		{
+			name:  "excluded",
+			match: &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorNotIn, Values: []string{"dev", "prod-*"}}}},
+			want:  false,
+		},
+		{
+			name:   "target conflicts",
+			target: map[string]string{"customer": "b"},
+			match:  &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorEquals, Values: []string{"prod-*"}}}},
+			want:   false,
+		},
+		{
+			name:  "missing keys are ignored",
+			match: &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorEquals, Values: []string{"prod-*"}}, {Key: "namespace", Operator: OperatorEquals, Values: []string{"default"}}}},
+			want:  true,
+		},
+		{
+			name:  "no key in common",
+			match: &Match{Expressions: []Expression{{Key: "namespace", Operator: OperatorNotEquals, Values: []string{"kube-system"}}}},
+			want:  false,
+		},
+		{
+			name:  "require all keys",
+			match: &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorEquals, Values: []string{"prod-*"}}, {Key: "namespace", Operator: OperatorEquals, Values: []string{"default"}}}, RequireAll: true},
+			want:  false,
+		},
+		{
+			name:   "require all keys of the target",
+			target: map[string]string{"customer": "a", "namespace": "default"},
+			match:  &Match{RequireAll: true},
+			want:   false,
+		},
+		{
+			name:   "has all keys",
+			target: map[string]string{"customer": "a"},
+			match:  &Match{Expressions: []Expression{{Key: "cluster", Operator: OperatorIn, Values: []string{"prod-??"}}}, RequireAll: true},
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConnection(nil, 1, attributes)
+			if got := c.Matches(tt.target, tt.match); got != tt.want {
+				t.Errorf("Connection.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   Match
+		wantErr bool
+	}{
+		{name: "valid", match: Match{Expressions: []Expression{{Key: "a", Operator: OperatorIn, Values: []string{"b", "c*"}}}}},
+		{name: "no key", match: Match{Expressions: []Expression{{Operator: OperatorEquals, Values: []string{"b"}}}}, wantErr: true},
+		{name: "unknown operator", match: Match{Expressions: []Expression{{Key: "a", Operator: "~", Values: []string{"b"}}}}, wantErr: true},
+		{name: "several values to equal", match: Match{Expressions: []Expression{{Key: "a", Operator: OperatorEquals, Values: []string{"b", "c"}}}}, wantErr: true},
+		{name: "no values", match: Match{Expressions: []Expression{{Key: "a", Operator: OperatorNotIn}}}, wantErr: true},
+		{name: "malformed pattern", match: Match{Expressions: []Expression{{Key: "a", Operator: OperatorEquals, Values: []string{"[b"}}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.match.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Match.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConnection_Sent(t *testing.T) {
+	stream, err := NewSSEStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewStreamConnection(stream, 1, map[string]string{"a": "b"})
+	wa := NewWebsocketActions()
+	for _, message := range []string{"{}", "{\"a\":1}"} {
+		if err := wa.WriteBinaryMessage(conn, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wa.WritePingMessage(conn)
+	if messages, bytes := conn.Sent(); messages != 2 || bytes != 9 {
+		t.Errorf("Connection.Sent() = %d, %d, want 2, 9", messages, bytes)
+	}
+	if got := conn.RemoteAddr(); got != "192.0.2.1:1234" {
+		t.Errorf("Connection.RemoteAddr() = %v", got)
+	}
+	if got := NewConnection(nil, 2, nil).RemoteAddr(); got != "" {
+		t.Errorf("Connection.RemoteAddr() = %v, want empty", got)
+	}
+}
@@ -0,0 +1,125 @@
+package websocketactions
+
+import (
+	"fmt"
+	"path"
+)
+
+// Operator compares an attribute of a connection with the values of an expression
+type Operator string
+
+const (
+	// OperatorEquals the attribute matches the value
+	OperatorEquals Operator = "="
+	// OperatorNotEquals the attribute matches none of the values
+	OperatorNotEquals Operator = "!="
+	// OperatorIn the attribute matches one of the values
+	OperatorIn Operator = "in"
+	// OperatorNotIn the attribute matches none of the values, same as OperatorNotEquals
+	OperatorNotIn Operator = "notIn"
+)
+
+// Expression selects connections by an attribute. Values are shell patterns, e.g. "prod-*"
+type Expression struct {
+	Key      string   `json:"key" bson:"key"`
+	Operator Operator `json:"operator" bson:"operator"`
+	Values   []string `json:"values" bson:"values"`
+}
+
+// Match selects connections by attribute expressions, on top of the exact values of a notification target
+type Match struct {
+	Expressions []Expression `json:"expressions" bson:"expressions"`
+	// RequireAll requires a connection to have every key of the target and of the expressions.
+	// By default, like the target, the keys a connection does not have are ignored as long as it has one of them
+	RequireAll bool `json:"requireAll,omitempty" bson:"requireAll,omitempty"`
+}
+
+// Validate checks the expressions are well formed
+func (m *Match) Validate() error {
+	for i, e := range m.Expressions {
+		if e.Key == "" {
+			return fmt.Errorf("expression %d has no key", i)
+		}
+		switch e.Operator {
+		case OperatorEquals:
+			if len(e.Values) != 1 {
+				return fmt.Errorf("expression '%s' must have a single value with operator '%s'", e.Key, e.Operator)
+			}
+		case OperatorNotEquals, OperatorIn, OperatorNotIn:
+			if len(e.Values) == 0 {
+				return fmt.Errorf("expression '%s' has no values", e.Key)
+			}
+		default:
+			return fmt.Errorf("expression '%s' has unknown operator '%s', must be '%s', '%s', '%s' or '%s'", e.Key, e.Operator, OperatorEquals, OperatorNotEquals, OperatorIn, OperatorNotIn)
+		}
+		for _, v := range e.Values {
+			if _, err := path.Match(v, ""); err != nil {
+				return fmt.Errorf("expression '%s' has malformed pattern '%s'", e.Key, v)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a connection matches a target and the expressions of a given Match, nil for the exact target only
+func (c *Connection) Matches(target map[string]string, m *Match) bool {
+	for _, routingAttributes := range c.RoutingAttributes() {
+		if AttributesMatch(routingAttributes, target, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttributesMatch reports whether the attributes of a connection match a target and the expressions of a given Match.
+// A nil Match is the same as AttributesContained. Otherwise the target values are exact expressions, and all the expressions must hold:
+// at least one key is present in both the connection and the expressions, and the expressions of the keys present in both match.
+// With RequireAll, every key of the expressions must be present in the connection
+func AttributesMatch(connectionAttributes, target map[string]string, m *Match) bool {
+	if m == nil {
+		return AttributesContained(connectionAttributes, target)
+	}
+	found := false
+	for k, v := range target {
+		value, ok := connectionAttributes[k]
+		if !ok {
+			if m.RequireAll {
+				return false
+			}
+			continue
+		}
+		if value != v {
+			return false
+		}
+		found = true
+	}
+	for _, e := range m.Expressions {
+		value, ok := connectionAttributes[e.Key]
+		if !ok {
+			if m.RequireAll {
+				return false
+			}
+			continue
+		}
+		if !e.matches(value) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// matches reports whether an attribute value satisfies the expression
+func (e *Expression) matches(value string) bool {
+	matched := false
+	for _, pattern := range e.Values {
+		if ok, _ := path.Match(pattern, value); ok {
+			matched = true
+			break
+		}
+	}
+	if e.Operator == OperatorNotEquals || e.Operator == OperatorNotIn {
+		return !matched
+	}
+	return matched
+}
@@ -0,0 +1,202 @@
+package websocketactions
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what happens to a message queued for a connection whose send queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest drops the oldest queued message to make room for the new one
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest drops the new message
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDisconnect evicts the slow consumer by closing its connection
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+var (
+	// ErrMessageDropped is the outcome of a message dropped by the overflow policy of a full send queue
+	ErrMessageDropped = errors.New("send queue is full, message dropped")
+	// ErrConnectionClosed is the outcome of a message still queued when its connection was closed
+	ErrConnectionClosed = errors.New("connection closed before the message was written")
+)
+
+// QueuedWrite is a write waiting in the send queue of a connection
+type QueuedWrite struct {
+	// Write writes the message to the connection
+	Write func() error
+	// Done is called once with the outcome of the write, nil if the message was written. Optional
+	Done func(err error)
+}
+
+func (w *QueuedWrite) done(err error) {
+	if w.Done != nil {
+		w.Done(err)
+	}
+}
+
+// sendQueue is a bounded queue of writes to a connection, drained by a single writer goroutine
+type sendQueue struct {
+	mutex        sync.Mutex
+	writes       []*QueuedWrite
+	size         int
+	policy       OverflowPolicy
+	writeTimeout time.Duration
+	// onOverflow is called whenever the queue overflows, with the policy that was applied
+	onOverflow func(policy OverflowPolicy)
+	wake       chan struct{}
+	stop       chan struct{}
+	closed     bool
+}
+
+// StartSendQueue makes the connection write through a bounded send queue drained by a single writer goroutine,
+// so a slow consumer never blocks its senders. Every write must complete within writeTimeout.
+// onOverflow, if set, is called whenever the queue overflows. The queue stops once the connection is closed
+func (c *Connection) StartSendQueue(size int, policy OverflowPolicy, writeTimeout time.Duration, onOverflow func(policy OverflowPolicy)) {
+	q := &sendQueue{
+		size:         size,
+		policy:       policy,
+		writeTimeout: writeTimeout,
+		onOverflow:   onOverflow,
+		wake:         make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+	}
+	if !c.queue.CompareAndSwap(nil, q) {
+		return
+	}
+	go c.writeQueued(q)
+}
+
+// Enqueue queues a write to the connection. A connection without a send queue writes right away
+func (c *Connection) Enqueue(w *QueuedWrite) {
+	q := c.queue.Load()
+	if q == nil {
+		w.done(w.Write())
+		return
+	}
+
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		w.done(ErrConnectionClosed)
+		return
+	}
+	if len(q.writes) < q.size {
+		q.writes = append(q.writes, w)
+		q.mutex.Unlock()
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+	var dropped *QueuedWrite
+	switch q.policy {
+	case OverflowDropOldest:
+		dropped = q.writes[0]
+		q.writes[0] = nil
+		q.writes = append(q.writes[1:], w)
+	default:
+		dropped = w
+	}
+	q.mutex.Unlock()
+
+	if q.onOverflow != nil {
+		q.onOverflow(q.policy)
+	}
+	if q.policy == OverflowDisconnect {
+		// the writer stops, and the reader of the connection notices it was closed
+		c.Close()
+		w.done(ErrConnectionClosed)
+		return
+	}
+	dropped.done(ErrMessageDropped)
+}
+
+// QueueLen returns the number of writes waiting in the send queue of the connection
+func (c *Connection) QueueLen() int {
+	q := c.queue.Load()
+	if q == nil {
+		return 0
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.writes)
+}
+
+// writeQueued writes the queued messages in order until the queue is closed
+func (c *Connection) writeQueued(q *sendQueue) {
+	for {
+		w, ok := q.next()
+		if !ok {
+			return
+		}
+		c.setWriteDeadline(time.Now().Add(q.writeTimeout))
+		err := w.Write()
+		c.setWriteDeadline(time.Time{})
+		w.done(err)
+	}
+}
+
+// next waits for the next queued write. Returns false once the queue is closed
+func (q *sendQueue) next() (*QueuedWrite, bool) {
+	for {
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return nil, false
+		}
+		if len(q.writes) > 0 {
+			w := q.writes[0]
+			q.writes[0] = nil
+			q.writes = q.writes[1:]
+			q.mutex.Unlock()
+			return w, true
+		}
+		q.mutex.Unlock()
+		select {
+		case <-q.wake:
+		case <-q.stop:
+		}
+	}
+}
+
+// closeSendQueue stops the writer of the connection, the messages still queued are not written
+func (c *Connection) closeSendQueue() {
+	q := c.queue.Load()
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return
+	}
+	q.closed = true
+	writes := q.writes
+	q.writes = nil
+	close(q.stop)
+	q.mutex.Unlock()
+	for _, w := range writes {
+		w.done(ErrConnectionClosed)
+	}
+}
+
+// setWriteDeadline sets the deadline of the writes to the connection. The zero time clears it
+func (c *Connection) setWriteDeadline(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stream != nil {
+		// not every response writer supports deadlines, the stream is then written without one
+		http.NewResponseController(c.stream.w).SetWriteDeadline(t)
+		return
+	}
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(t)
+	}
+}
@@ -0,0 +1,116 @@
+package websocketactions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockedConnectionMock returns a stream connection with a send queue whose writer is blocked until release is closed
+func blockedConnectionMock(t *testing.T, size int, policy OverflowPolicy) (*Connection, chan struct{}) {
+	stream, err := NewSSEStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewStreamConnection(stream, 1, map[string]string{"a": "b"})
+	conn.StartSendQueue(size, policy, time.Second, nil)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	conn.Enqueue(&QueuedWrite{Write: func() error {
+		close(started)
+		<-release
+		return nil
+	}})
+	<-started
+	return conn, release
+}
+
+// enqueueMock queues a write of a given number, and returns the channel its outcome is sent to
+func enqueueMock(conn *Connection, n int, written *[]int, mutex *sync.Mutex) chan error {
+	done := make(chan error, 1)
+	conn.Enqueue(&QueuedWrite{
+		Write: func() error {
+			mutex.Lock()
+			*written = append(*written, n)
+			mutex.Unlock()
+			return nil
+		},
+		Done: func(err error) { done <- err },
+	})
+	return done
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy      OverflowPolicy
+		wantWritten []int
+		wantErr     map[int]error
+	}{
+		{policy: OverflowDropOldest, wantWritten: []int{2, 3}, wantErr: map[int]error{1: ErrMessageDropped}},
+		{policy: OverflowDropNewest, wantWritten: []int{1, 2}, wantErr: map[int]error{3: ErrMessageDropped}},
+		{policy: OverflowDisconnect, wantWritten: []int{}, wantErr: map[int]error{1: ErrConnectionClosed, 2: ErrConnectionClosed, 3: ErrConnectionClosed}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			conn, release := blockedConnectionMock(t, 2, tt.policy)
+			mutex := sync.Mutex{}
+			written := []int{}
+			done := map[int]chan error{}
+			for n := 1; n <= 3; n++ {
+				done[n] = enqueueMock(conn, n, &written, &mutex)
+			}
+			close(release)
+			for n := 1; n <= 3; n++ {
+				select {
+				case err := <-done[n]:
+					if err != tt.wantErr[n] {
+						t.Errorf("write %d: got %v, want %v", n, err, tt.wantErr[n])
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("write %d was not completed", n)
+				}
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(written) != len(tt.wantWritten) {
+				t.Fatalf("written %v, want %v", written, tt.wantWritten)
+			}
+			for i := range written {
+				if written[i] != tt.wantWritten[i] {
+					t.Errorf("written %v, want %v", written, tt.wantWritten)
+				}
+			}
+		})
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	conn, release := blockedConnectionMock(t, 10, OverflowDisconnect)
+	defer close(release)
+	mutex := sync.Mutex{}
+	written := []int{}
+	queued := enqueueMock(conn, 1, &written, &mutex)
+	if got := conn.QueueLen(); got != 1 {
+		t.Errorf("QueueLen() = %d, want 1", got)
+	}
+
+	conn.Close()
+	if err := <-queued; err != ErrConnectionClosed {
+		t.Errorf("queued write: got %v, want %v", err, ErrConnectionClosed)
+	}
+	if err := <-enqueueMock(conn, 2, &written, &mutex); err != ErrConnectionClosed {
+		t.Errorf("write after close: got %v, want %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestEnqueueWithoutSendQueue(t *testing.T) {
+	conn := NewConnection(nil, 1, nil)
+	mutex := sync.Mutex{}
+	written := []int{}
+	if err := <-enqueueMock(conn, 1, &written, &mutex); err != nil || len(written) != 1 {
+		t.Errorf("a connection without a send queue writes right away, got %v, %v", err, written)
+	}
+}
@@ -0,0 +1,94 @@
+package websocketactions
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// SSEEventNotification is the event of a notification sent as text
+	SSEEventNotification = "notification"
+	// SSEEventNotificationBase64 is the event of a binary (BSON) notification, sent base64 encoded
+	SSEEventNotificationBase64 = "notification-base64"
+)
+
+// SSEStream is a Server-Sent Events stream written to an HTTP response.
+// Writes must be serialized by the caller, Connection does that with its mutex
+type SSEStream struct {
+	w         http.ResponseWriter
+	flusher   http.Flusher
+	done      chan struct{}
+	closeOnce sync.Once
+	// remoteAddr is the address of the subscriber
+	remoteAddr string
+}
+
+// NewSSEStream starts a Server-Sent Events response
+func NewSSEStream(w http.ResponseWriter, r *http.Request) (*SSEStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// disable buffering in nginx based proxies
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEStream{
+		w:          w,
+		flusher:    flusher,
+		done:       make(chan struct{}),
+		remoteAddr: r.RemoteAddr,
+	}, nil
+}
+
+// WriteEvent writes a notification as an event. Text notifications are sent as is, binary ones base64 encoded
+func (s *SSEStream) WriteEvent(data []byte) error {
+	event := SSEEventNotification
+	if !utf8.Valid(data) {
+		event = SSEEventNotificationBase64
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	return s.write(buf.Bytes())
+}
+
+// WriteComment writes a comment line, used to keep the stream alive
+func (s *SSEStream) WriteComment(comment string) error {
+	return s.write([]byte(fmt.Sprintf(": %s\n\n", comment)))
+}
+
+func (s *SSEStream) write(b []byte) error {
+	select {
+	case <-s.done:
+		return fmt.Errorf("stream is closed")
+	default:
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Done is closed once the stream is closed
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close closes the stream, the HTTP handler serving it should return
+func (s *SSEStream) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
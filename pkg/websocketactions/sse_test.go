@@ -0,0 +1,50 @@
+package websocketactions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSSEStream_WriteEvent(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{
+			name: "text notification",
+			data: []byte(`{"a":"b"}`),
+			want: "event: notification\ndata: {\"a\":\"b\"}\n\n",
+		},
+		{
+			name: "multi line notification",
+			data: []byte("{\n\"a\":\"b\"\n}"),
+			want: "event: notification\ndata: {\ndata: \"a\":\"b\"\ndata: }\n\n",
+		},
+		{
+			name: "binary notification",
+			data: []byte{0xff, 0x00, 0x01},
+			want: "event: notification-base64\ndata: /wAB\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s, err := NewSSEStream(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.WriteEvent(tt.data); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("SSEStream.WriteEvent() = %q, want %q", got, tt.want)
+			}
+			s.Close()
+			if err := s.WriteEvent(tt.data); err == nil {
+				t.Errorf("SSEStream.WriteEvent() on a closed stream should fail")
+			}
+		})
+	}
+}
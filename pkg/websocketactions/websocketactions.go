@@ -10,6 +10,9 @@ import (
 	"github.com/kubescape/go-logger/helpers"
 )
 
+// closeMessageWait is the time allowed to write a close frame
+const closeMessageWait = time.Second
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  2048,
 	WriteBufferSize: 2048,
@@ -21,12 +24,28 @@ type IWebsocketActions interface {
 	WriteBinaryMessage(conn *Connection, readBuffer []byte) error
 	WritePongMessage(conn *Connection) error
 	WritePingMessage(conn *Connection) error
-	WritePreparedMessage(conn *Connection, preparedMessage *websocket.PreparedMessage) error
+	WritePreparedMessage(conn *Connection, preparedMessage *PreparedMessage) error
+	WriteCloseMessage(conn *Connection, code int, text string) error
 	ReadMessage(conn *Connection) (int, []byte, error)
 	Close(conn *Connection) error
 	DefaultDialer(host string, headers http.Header) (*websocket.Conn, *http.Response, error)
 }
 
+// PreparedMessage is a binary message encoded once, to be written to many websocket connections
+type PreparedMessage struct {
+	*websocket.PreparedMessage
+	size int
+}
+
+// NewPreparedMessage prepares a binary message
+func NewPreparedMessage(data []byte) (*PreparedMessage, error) {
+	preparedMessage, err := websocket.NewPreparedMessage(websocket.BinaryMessage, data)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedMessage{PreparedMessage: preparedMessage, size: len(data)}, nil
+}
+
 // WebsocketActions -
 type WebsocketActions struct {
 }
@@ -46,15 +65,29 @@ func (wa *WebsocketActions) ConnectWebsocket(w http.ResponseWriter, r *http.Requ
 func (wa *WebsocketActions) WriteBinaryMessage(conn *Connection, readBuffer []byte) error {
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
-	err := conn.conn.WriteMessage(websocket.BinaryMessage, readBuffer)
+	var err error
+	if conn.stream != nil {
+		err = conn.stream.WriteEvent(readBuffer)
+	} else {
+		err = conn.conn.WriteMessage(websocket.BinaryMessage, readBuffer)
+	}
+	if err == nil {
+		conn.sent(len(readBuffer))
+	}
 	return err
 }
 
-// WritePreparedMessage -
-func (wa *WebsocketActions) WritePreparedMessage(conn *Connection, preparedMessage *websocket.PreparedMessage) error {
+// WritePreparedMessage writes a prepared message to a websocket connection. Streams are written with WriteBinaryMessage
+func (wa *WebsocketActions) WritePreparedMessage(conn *Connection, preparedMessage *PreparedMessage) error {
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
-	err := conn.conn.WritePreparedMessage(preparedMessage)
+	if conn.stream != nil {
+		return fmt.Errorf("prepared messages cannot be written to a stream")
+	}
+	err := conn.conn.WritePreparedMessage(preparedMessage.PreparedMessage)
+	if err == nil {
+		conn.sent(preparedMessage.size)
+	}
 	return err
 }
 
@@ -62,6 +95,9 @@ func (wa *WebsocketActions) WritePreparedMessage(conn *Connection, preparedMessa
 func (wa *WebsocketActions) WritePongMessage(conn *Connection) error {
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
+	if conn.stream != nil {
+		return nil
+	}
 	err := conn.conn.WriteMessage(websocket.PongMessage, []byte{})
 	return err
 }
@@ -70,18 +106,37 @@ func (wa *WebsocketActions) WritePongMessage(conn *Connection) error {
 func (wa *WebsocketActions) WritePingMessage(conn *Connection) error {
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
+	if conn.stream != nil {
+		return conn.stream.WriteComment("ping")
+	}
 	err := conn.conn.WriteMessage(websocket.PingMessage, []byte{})
 	return err
 }
 
+// WriteCloseMessage writes a close frame with a given close code to a websocket connection.
+// Streams have no close frame, so they are closed instead
+func (wa *WebsocketActions) WriteCloseMessage(conn *Connection, code int, text string) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if conn.stream != nil {
+		return conn.stream.Close()
+	}
+	err := conn.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(closeMessageWait))
+	return err
+}
+
 // ReadMessage -
 func (wa *WebsocketActions) ReadMessage(conn *Connection) (int, []byte, error) {
+	if conn.stream != nil {
+		return 0, nil, fmt.Errorf("streams cannot be read")
+	}
 	messageType, p, err := conn.conn.ReadMessage()
 	return messageType, p, err
 }
 
 // Close -
 func (wa *WebsocketActions) Close(conn *Connection) error {
+	conn.closeSendQueue()
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
 	defer func() {
@@ -89,24 +144,18 @@ func (wa *WebsocketActions) Close(conn *Connection) error {
 			logger.L().Error("recover while closing connection", helpers.Interface("reason", err))
 		}
 	}()
+	if conn.stream != nil {
+		return conn.stream.Close()
+	}
 	err := conn.conn.Close()
 	return err
 }
 
-// DefaultDialer -
+// DefaultDialer dials a websocket connection to the given host. Retrying is left to the caller
 func (wa *WebsocketActions) DefaultDialer(host string, headers http.Header) (*websocket.Conn, *http.Response, error) {
-	i := 0
-	for {
-		conn, res, err := websocket.DefaultDialer.Dial(host, headers)
-		if err != nil {
-			err = fmt.Errorf("failed dialing to: '%s', reason: '%s'", host, err.Error())
-		}
-		if err == nil || i == 2 {
-			return conn, res, err
-		}
-		i++
-		logger.L().Warning("dialing websocket", helpers.Int("attempt", i), helpers.Error(err))
-		time.Sleep(time.Second * 5)
+	conn, res, err := websocket.DefaultDialer.Dial(host, headers)
+	if err != nil {
+		err = fmt.Errorf("failed dialing to: '%s', reason: '%s'", host, err.Error())
 	}
-
+	return conn, res, err
 }
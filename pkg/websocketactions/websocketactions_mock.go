@@ -20,11 +20,18 @@ func (wam *WebsocketActionsMock) ConnectWebsocket(w http.ResponseWriter, r *http
 
 // WriteBinaryMessage -
 func (wam *WebsocketActionsMock) WriteBinaryMessage(conn *Connection, readBuffer []byte) error {
+	conn.sent(len(readBuffer))
 	return nil
 }
 
 // WritePreparedMessage -
-func (wam *WebsocketActionsMock) WritePreparedMessage(conn *Connection, preparedMessage *websocket.PreparedMessage) error {
+func (wam *WebsocketActionsMock) WritePreparedMessage(conn *Connection, preparedMessage *PreparedMessage) error {
+	conn.sent(preparedMessage.size)
+	return nil
+}
+
+// WriteCloseMessage -
+func (wam *WebsocketActionsMock) WriteCloseMessage(conn *Connection, code int, text string) error {
 	return nil
 }
 
@@ -50,5 +57,6 @@ func (wam *WebsocketActionsMock) DefaultDialer(host string, headers http.Header)
 
 // Close -
 func (wam *WebsocketActionsMock) Close(conn *Connection) error {
+	conn.closeSendQueue()
 	return nil
 }